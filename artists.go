@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// AllArtists is the -band value that downloads from every relisten.org artist
+const AllArtists = "all"
+
+// EventArtistStarted is emitted when a -band all run moves on to an artist
+const EventArtistStarted = "artist_started"
+
+// DefaultAllArtistsRate is the API rate of -band all runs unless -api-rate is set
+const DefaultAllArtistsRate = 2
+
+// Artist is an entry of relisten.org's artist listing
+type Artist struct {
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	ShowCount   int    `json:"show_count"`
+	SourceCount int    `json:"source_count"`
+}
+
+// fetchArtists lists every artist on relisten.org
+func fetchArtists() ([]Artist, error) {
+	url := fmt.Sprintf("%s/artists", RelistenAPIBase)
+	resp, err := apiGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var artists []Artist
+	if err := json.NewDecoder(resp.Body).Decode(&artists); err != nil {
+		return nil, err
+	}
+	return artists, nil
+}
+
+// runAllArtists downloads the shows of every artist in turn, each as its own
+// run with its own state file, so an interrupted artist can be resumed
+// alone. It returns an error if the run couldn't start or was aborted.
+func runAllArtists(cfg *Config) error {
+	if cfg.Tour != "" || cfg.Era != "" || cfg.Show != "" || cfg.StateFile != "" || cfg.Collection != "" {
+		return fmt.Errorf("-tour, -era, -show, -state-file, and -archive-collection can't be used with -band %s", AllArtists)
+	}
+	if cfg.APIRate == 0 {
+		cfg.APIRate = DefaultAllArtistsRate
+		apiLimiter.SetRate(cfg.APIRate)
+	}
+
+	artists, err := fetchArtists()
+	if err != nil {
+		return fmt.Errorf("failed to fetch artists: %w", err)
+	}
+	logger.Info("Found %d artists", len(artists))
+
+	if !cfg.Yes && !cfg.DryRun && !confirm(fmt.Sprintf("Download from all %d artists without asking per artist?", len(artists))) {
+		logger.Info("Download cancelled")
+		return nil
+	}
+	cfg.Yes = true
+
+	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", cfg.OutputDir, err)
+	}
+
+	for i, artist := range artists {
+		if quota.Reached() {
+			logger.Info("Monthly quota reached, stopping before %s", artist.Name)
+			break
+		}
+		if control.Cancelled() {
+			break
+		}
+
+		logger.Printf("\n=== [%d/%d] %s (%s) ===\n", i+1, len(artists), artist.Name, artist.Slug)
+		events.Emit(ProgressEvent{Type: EventArtistStarted, Artist: artist.Slug, Position: i + 1, Shows: artist.ShowCount})
+
+		artistCfg := *cfg
+		artistCfg.Band = artist.Slug
+		shows, err := fetchRunShows(&artistCfg)
+		if err != nil {
+			logger.Error("Failed to fetch shows for %s: %v", artist.Slug, err)
+			continue
+		}
+		if len(shows) == 0 {
+			logger.Info("No shows for %s in %s", artist.Slug, runLabel(&artistCfg))
+			continue
+		}
+		logger.Info("Found %d shows for %s in %s", len(shows), artist.Slug, runLabel(&artistCfg))
+
+		artistCfg.StateFile = defaultStateFile(&artistCfg)
+		state := newRunState(artistCfg.StateFile, artistCfg, shows)
+		if err := state.Save(); err != nil {
+			logger.Warn("Failed to write state file %s: %v", artistCfg.StateFile, err)
+		}
+		if err := runDownload(&artistCfg, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
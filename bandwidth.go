@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bandwidthMeter counts the bytes received from the network, split by day so
+// a run that crosses midnight is attributed correctly
+type bandwidthMeter struct {
+	mu   sync.Mutex
+	days map[string]int64
+}
+
+// bandwidth is the process-wide meter fed by every download
+var bandwidth = &bandwidthMeter{days: make(map[string]int64)}
+
+// Add records n bytes transferred now
+func (m *bandwidthMeter) Add(n int64) {
+	day := time.Now().Format("2006-01-02")
+	m.mu.Lock()
+	m.days[day] += n
+	m.mu.Unlock()
+}
+
+// Days returns a copy of the bytes transferred per day
+func (m *bandwidthMeter) Days() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	days := make(map[string]int64, len(m.days))
+	for day, bytes := range m.days {
+		days[day] = bytes
+	}
+	return days
+}
+
+// Flush returns the bytes counted since the last flush, per day, and starts
+// over, so runs sharing the process each record only their own share
+func (m *bandwidthMeter) Flush() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	days := m.days
+	m.days = make(map[string]int64)
+	return days
+}
+
+// Reader wraps r so everything read from it is metered. Failed and retried
+// transfers count too, since they used the bandwidth all the same.
+func (m *bandwidthMeter) Reader(r io.Reader) io.Reader {
+	return &meteredReader{r: r, meter: m}
+}
+
+type meteredReader struct {
+	r     io.Reader
+	meter *bandwidthMeter
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.meter.Add(int64(n))
+	}
+	return n, err
+}
+
+// recordRun adds the run and the bytes transferred since the last record to
+// the catalog, and returns those bytes
+func recordRun(cfg *Config, store CatalogStore, started time.Time, summary *RunSummary) int64 {
+	days := bandwidth.Flush()
+	var total int64
+	for _, bytes := range days {
+		total += bytes
+	}
+	quota.Record(days)
+
+	run := RunRecord{
+		StartedAt:  started,
+		FinishedAt: time.Now(),
+		Band:       cfg.Band,
+		Year:       cfg.Year,
+		Files:      summary.Downloaded(),
+		Bytes:      total,
+		Aborted:    summary.Aborted(),
+		Sync:       cfg.Sync && !summary.QuotaReached() && !control.Cancelled(), // Only complete syncs are started from
+	}
+	if err := store.RecordRun(run, days); err != nil {
+		logger.Warn("Failed to update catalog: %v", err)
+	}
+	return total
+}
+
+// printBandwidth reports the bytes transferred per day over the last days,
+// per month, and by the most recent runs
+func printBandwidth(w io.Writer, catalog *Catalog, days, runs int) {
+	fmt.Fprintf(w, "Bandwidth over the last %d day(s):\n", days)
+	var total int64
+	today := time.Now()
+	for i := days - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i).Format("2006-01-02")
+		bytes := catalog.Bandwidth[day]
+		total += bytes
+		if bytes > 0 {
+			fmt.Fprintf(w, "  %s  %10s\n", day, ByteSize(bytes))
+		}
+	}
+	fmt.Fprintf(w, "  %-10s  %10s\n", "total", ByteSize(total))
+
+	months := make(map[string]int64)
+	for day, bytes := range catalog.Bandwidth {
+		if len(day) >= 7 {
+			months[day[:7]] += bytes
+		}
+	}
+	keys := make([]string, 0, len(months))
+	for month := range months {
+		keys = append(keys, month)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(w, "\nPer month:")
+	for _, month := range keys {
+		fmt.Fprintf(w, "  %-10s  %10s\n", month, ByteSize(months[month]))
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+
+	fmt.Fprintln(w, "\nRecent runs:")
+	recent := catalog.Runs
+	if len(recent) > runs {
+		recent = recent[len(recent)-runs:]
+	}
+	for _, run := range recent {
+		status := ""
+		if run.Aborted {
+			status = " (aborted)"
+		}
+		fmt.Fprintf(w, "  %s  %-20s %5d file(s) %10s in %s%s\n",
+			run.StartedAt.Format("2006-01-02 15:04"), run.Band+" "+run.Year, run.Files,
+			ByteSize(run.Bytes), run.FinishedAt.Sub(run.StartedAt).Round(time.Second), status)
+	}
+	if len(recent) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+}
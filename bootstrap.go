@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// showDirPattern matches show directory names: the show date, optionally
+// followed by -sourceN for the second and later sources of a show, or by the
+// identifier of an upgrade kept side by side
+var showDirPattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(?:-source(\d+)|-[^.]+)?$`)
+
+// BootstrapResult counts what a bootstrap scan found
+type BootstrapResult struct {
+	Added      int
+	Known      int
+	Unresolved []string
+}
+
+// bootstrapCatalog scans a downloads tree, including trees written by
+// versions without a catalog, and adds every show directory it can identify.
+// Show directories with a manifest name their identifier; older ones are
+// looked up on relisten.org and archive.org when lookup is set.
+func bootstrapCatalog(store CatalogStore, outputDir string, lookup bool) (BootstrapResult, error) {
+	var result BootstrapResult
+	host, _ := os.Hostname()
+
+	err := filepath.WalkDir(outputDir, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == CatalogDirName || dir == filepath.Join(outputDir, QuarantineDirName) {
+			return filepath.SkipDir
+		}
+
+		match := showDirPattern.FindStringSubmatch(d.Name())
+		if match == nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(outputDir, dir)
+		if err != nil {
+			return err
+		}
+		band := strings.Split(filepath.ToSlash(rel), "/")[0]
+		sourceIndex := 0
+		if match[2] != "" {
+			n, _ := strconv.Atoi(match[2])
+			sourceIndex = n - 1
+		}
+
+		src, err := scanShowDir(dir, band, match[1], sourceIndex, lookup)
+		if err != nil {
+			logger.Warn("Failed to identify %s: %v", dir, err)
+			result.Unresolved = append(result.Unresolved, dir)
+			return filepath.SkipDir
+		}
+		if src == nil {
+			return filepath.SkipDir
+		}
+
+		if known, ok, err := store.LookupSource(src.Identifier); err != nil {
+			return err
+		} else if ok {
+			// Sources cataloged by older versions have no file hashes for dedup
+			if known.Hashes == nil && src.Hashes != nil {
+				known.Hashes = src.Hashes
+				if err := store.AddSource(known); err != nil {
+					return err
+				}
+				logger.Info("Added file hashes to %s", known.Identifier)
+			}
+			result.Known++
+			return filepath.SkipDir
+		}
+
+		src.ShowDir = filepath.ToSlash(rel)
+		src.Host = host
+		if err := store.AddSource(src); err != nil {
+			return err
+		}
+		logger.Info("Added %s (%s, %d files)", src.Identifier, src.ShowDir, src.Files)
+		result.Added++
+
+		// Files of a show can be in subdirectories with -preserve-structure
+		return filepath.SkipDir
+	})
+	return result, err
+}
+
+// scanShowDir builds the catalog entry of a show directory. It returns nil
+// for directories without any downloaded audio.
+func scanShowDir(dir, band, date string, sourceIndex int, lookup bool) (*CatalogSource, error) {
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &CatalogSource{Band: band, Year: dateYear(date), Date: date, Identifier: manifest.Identifier}
+	if len(manifest.Files) > 0 {
+		for _, entry := range manifest.Files {
+			addScannedFile(src, entry.LocalName, entry.Size, entry.DownloadedAt)
+			if entry.Fingerprint != "" {
+				if src.Fingerprints == nil {
+					src.Fingerprints = make(map[string]string)
+				}
+				src.Fingerprints[entry.LocalName] = entry.Fingerprint
+			}
+			if sum := entry.diskMD5(); sum != "" {
+				if src.Hashes == nil {
+					src.Hashes = make(map[string]string)
+				}
+				src.Hashes[entry.LocalName] = sum
+			}
+		}
+	} else {
+		// Older versions didn't write a manifest, so look at the files themselves
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			addScannedFile(src, d.Name(), info.Size(), info.ModTime())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if src.Format == "" {
+		return nil, nil
+	}
+
+	if !lookup {
+		if src.Identifier == "" {
+			return nil, fmt.Errorf("no manifest, and lookups are disabled")
+		}
+		return src, nil
+	}
+
+	detail, err := fetchShowDetail(band, date)
+	if err != nil {
+		if src.Identifier != "" {
+			// The manifest is enough; only the rating is missing
+			return src, nil
+		}
+		return nil, err
+	}
+
+	source, err := matchSource(detail.Sources, src.Identifier, sourceIndex, dir)
+	if err != nil {
+		return nil, err
+	}
+	src.Identifier = sourceIdentifier(*source)
+	src.Rating = source.AvgRating
+	src.Soundboard = source.IsSoundboard
+	return src, nil
+}
+
+// addScannedFile counts a file of a show directory towards its catalog entry
+func addScannedFile(src *CatalogSource, name string, size int64, modTime time.Time) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".flac":
+		src.Format = "flac"
+	case ".mp3":
+		if src.Format == "" {
+			src.Format = "mp3"
+		}
+	}
+	if name == ManifestFileName || strings.HasPrefix(name, LockFileName) { // With the temporary files of locks
+		return
+	}
+	src.Files++
+	src.Bytes += size
+	if modTime.After(src.DownloadedAt) {
+		src.DownloadedAt = modTime
+	}
+}
+
+// matchSource finds the Relisten source a show directory was downloaded from:
+// by identifier when the manifest has one, by the -sourceN suffix, or else by
+// checking which source's files are in the directory
+func matchSource(sources []Source, identifier string, sourceIndex int, dir string) (*Source, error) {
+	if identifier != "" {
+		for i := range sources {
+			if sourceIdentifier(sources[i]) == identifier {
+				return &sources[i], nil
+			}
+		}
+		return nil, fmt.Errorf("source %s is no longer listed", identifier)
+	}
+
+	if sourceIndex > 0 || len(sources) == 1 {
+		if sourceIndex >= len(sources) {
+			return nil, fmt.Errorf("show has only %d source(s)", len(sources))
+		}
+		return &sources[sourceIndex], nil
+	}
+
+	for i := range sources {
+		id := archiveIdentifier(sources[i])
+		if id == "" {
+			continue
+		}
+		metadata, err := fetchArchiveMetadata(id)
+		if err != nil {
+			continue
+		}
+		for _, file := range metadata.Files {
+			if classifyFile(file) != ClassAudio {
+				continue
+			}
+			fileName, oldFileName := localFileNames(file, &Config{})
+			if fileExists(filepath.Join(dir, fileName)) || fileExists(filepath.Join(dir, oldFileName)) {
+				return &sources[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("none of the %d sources match the files on disk", len(sources))
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
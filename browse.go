@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// SourceSummary is a source as `dead-dl sources -json` lists it, without
+// its set list
+type SourceSummary struct {
+	Identifier        string  `json:"identifier"`
+	UUID              string  `json:"uuid"`
+	IsSoundboard      bool    `json:"is_soundboard"`
+	IsRemaster        bool    `json:"is_remaster"`
+	AvgRating         float64 `json:"avg_rating"`
+	AvgRatingWeighted float64 `json:"avg_rating_weighted,omitempty"`
+	Reviews           int64   `json:"reviews"`
+	Duration          float64 `json:"duration"`
+	Taper             string  `json:"taper,omitempty"`
+	Transferrer       string  `json:"transferrer,omitempty"`
+	Source            string  `json:"source,omitempty"`
+	Lineage           string  `json:"lineage,omitempty"`
+	UpdatedAt         string  `json:"updated_at,omitempty"`
+}
+
+// browseFlags parses the flags of a listing command and checks that it got
+// the expected number of arguments
+func browseFlags(name, synopsis string, nargs int, args []string) (*flag.FlagSet, bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print JSON instead of a table")
+	fs.Parse(args)
+	if fs.NArg() != nargs {
+		fmt.Fprintf(os.Stderr, "Usage: dead-dl %s\n", synopsis)
+		os.Exit(2)
+	}
+
+	initLogger(os.Stderr)
+	return fs, *asJSON
+}
+
+// runArtists handles `dead-dl artists`, which lists the artists on relisten.org
+func runArtists(args []string) {
+	_, asJSON := browseFlags("artists", "artists [-json]", 0, args)
+	defer logger.Close()
+
+	artists, err := fetchArtists()
+	if err != nil {
+		logger.Fatal("Failed to fetch artists: %v", err)
+	}
+	if asJSON {
+		printJSON(artists)
+		return
+	}
+
+	w := newTable(os.Stdout)
+	fmt.Fprintln(w, "BAND\tNAME\tSHOWS\tSOURCES")
+	for _, artist := range artists {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", artist.Slug, artist.Name, artist.ShowCount, artist.SourceCount)
+	}
+	w.Flush()
+}
+
+// runYears handles `dead-dl years <band>`, which lists the years a band has
+// shows in
+func runYears(args []string) {
+	fs, asJSON := browseFlags("years", "years [-json] <band>", 1, args)
+	defer logger.Close()
+
+	band := fs.Arg(0)
+	years, err := fetchYears(band)
+	if err != nil {
+		logger.Fatal("Failed to fetch years of %s: %v", band, err)
+	}
+	if asJSON {
+		printJSON(years)
+		return
+	}
+
+	w := newTable(os.Stdout)
+	fmt.Fprintln(w, "YEAR\tSHOWS\tSOURCES\tRATING")
+	for _, year := range years {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", year.Year, year.ShowCount, year.SourceCount, rating(year.AvgRating))
+	}
+	w.Flush()
+}
+
+// runShows handles `dead-dl shows <band> <year>`, which lists a band's
+// shows of a year with their venue, sources, and rating
+func runShows(args []string) {
+	fs, asJSON := browseFlags("shows", "shows [-json] <band> <year>", 2, args)
+	defer logger.Close()
+
+	band, year := fs.Arg(0), fs.Arg(1)
+	shows, err := fetchShows(band, year)
+	if err != nil {
+		logger.Fatal("Failed to fetch shows of %s in %s: %v", band, year, err)
+	}
+	if asJSON {
+		printJSON(shows)
+		return
+	}
+
+	w := newTable(os.Stdout)
+	fmt.Fprintln(w, "DATE\tVENUE\tLOCATION\tSOURCES\tSBD\tRATING")
+	for _, show := range shows {
+		sbd := ""
+		if show.HasSoundboard {
+			sbd = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", show.DisplayDate, show.Venue.Name, show.Venue.Location,
+			show.SourceCount, sbd, rating(show.AvgRating))
+	}
+	w.Flush()
+}
+
+// runSources handles `dead-dl sources <band> <date>`, which lists the
+// sources of a show, so one can be picked with -show
+func runSources(args []string) {
+	fs, asJSON := browseFlags("sources", "sources [-json] <band> <YYYY-MM-DD>", 2, args)
+	defer logger.Close()
+
+	band, date := fs.Arg(0), fs.Arg(1)
+	if err := checkShowDate("date", date); err != nil {
+		logger.Fatal("%v", err)
+	}
+	detail, err := fetchShowDetail(band, date)
+	if err != nil {
+		logger.Fatal("Failed to fetch show %s of %s: %v", date, band, err)
+	}
+
+	summaries := make([]SourceSummary, 0, len(detail.Sources))
+	for _, source := range detail.Sources {
+		summaries = append(summaries, SourceSummary{
+			Identifier:        archiveIdentifier(source),
+			UUID:              source.UUID,
+			IsSoundboard:      source.IsSoundboard,
+			IsRemaster:        source.IsRemaster,
+			AvgRating:         source.AvgRating,
+			AvgRatingWeighted: source.AvgRatingWeighted,
+			Reviews:           sourceReviews(source),
+			Duration:          source.Duration,
+			Taper:             source.Taper,
+			Transferrer:       source.Transferrer,
+			Source:            source.Source,
+			Lineage:           source.Lineage,
+			UpdatedAt:         source.UpdatedAt,
+		})
+	}
+	if asJSON {
+		printJSON(summaries)
+		return
+	}
+
+	w := newTable(os.Stdout)
+	fmt.Fprintln(w, "IDENTIFIER\tTYPE\tRATING\tREVIEWS\tLENGTH\tTAPER")
+	for _, s := range summaries {
+		kind := "AUD"
+		if s.IsSoundboard {
+			kind = "SBD"
+		}
+		identifier := s.Identifier
+		if identifier == "" {
+			identifier = "(not on archive.org)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", identifier, kind, rating(s.AvgRating), s.Reviews,
+			showLength(s.Duration), s.Taper)
+	}
+	w.Flush()
+}
+
+// newTable returns a writer that aligns tab-separated columns
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+}
+
+// rating formats an average rating, or "-" for an unrated entry
+func rating(avg float64) string {
+	if avg <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", avg)
+}
+
+// showLength formats a length in seconds as H:MM, or "-" when it is unknown
+func showLength(seconds float64) string {
+	if seconds <= 0 {
+		return "-"
+	}
+	minutes := int(seconds+30) / 60
+	return fmt.Sprintf("%d:%02d", minutes/60, minutes%60)
+}
+
+// printJSON writes v as indented JSON to stdout
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		logger.Fatal("Failed to encode JSON: %v", err)
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// MetadataCacheDirName is the folder under the catalog directory that API
+// responses are cached in
+const MetadataCacheDirName = "cache"
+
+// errNotCached is returned for API requests in -offline mode that aren't in the cache
+var errNotCached = errors.New("not in the metadata cache")
+
+// metadataCache keeps every relisten.org and archive.org API response on
+// disk, so a run can later be planned from it with -offline
+type metadataCache struct {
+	dir      string
+	offline  bool
+	readOnly bool // -dry-run writes nothing
+}
+
+// apiCache is the process-wide metadata cache; nil disables caching
+var apiCache *metadataCache
+
+// metadataCacheDir returns the cache directory of a config
+func metadataCacheDir(cfg *Config) string {
+	if cfg.MetadataCache != "" {
+		return cfg.MetadataCache
+	}
+	return filepath.Join(cfg.OutputDir, CatalogDirName, MetadataCacheDirName)
+}
+
+// path returns the cache file of a URL
+func (c *metadataCache) path(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get returns the response for url: from the cache when offline, otherwise
+// from the network, storing successful responses in the cache
+func (c *metadataCache) Get(url string) (*http.Response, error) {
+	if c.offline {
+		data, err := os.ReadFile(c.path(url))
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w (offline): %s", errNotCached, url)
+		} else if err != nil {
+			return nil, err
+		}
+		return cachedResponse(data), nil
+	}
+
+	resp, err := apiClient.Get(url)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if c.readOnly {
+		return cachedResponse(data), nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err == nil {
+		// Write to a temporary file first, so a parallel reader never sees half a response
+		tmp := c.path(url) + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err == nil {
+			os.Rename(tmp, c.path(url))
+		}
+	}
+	return cachedResponse(data), nil
+}
+
+// cachedResponse wraps a response body read into memory
+func cachedResponse(data []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+	}
+}
+
+// printOfflinePlan checks an -offline plan against the catalog and tells
+// how to carry it out where there is internet
+func printOfflinePlan(cfg *Config, state *RunState, store CatalogStore) {
+	var missing, cataloged int
+	for _, plan := range state.Plans {
+		for _, sp := range plan.Sources {
+			if sp.Identifier != "" && sp.Files == nil {
+				missing++
+			} else if _, ok, err := store.LookupSource(sp.Identifier); err == nil && ok {
+				cataloged++
+			}
+		}
+	}
+
+	if missing > 0 {
+		logger.Warn("%d source(s) have no cached archive.org metadata; resume fetches it when online", missing)
+	}
+	if cataloged > 0 {
+		logger.Info("%d planned source(s) are already in the catalog", cataloged)
+	}
+	logger.Info("Offline plan written to %s; download it with: dead-dl resume %s", cfg.StateFile, cfg.StateFile)
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CatalogDirName is the directory under the output root where dead-dl keeps its own data
+const CatalogDirName = ".dead-dl"
+
+// CatalogFileName is the name of the catalog inside CatalogDirName
+const CatalogFileName = "catalog.json"
+
+// catalogVersion is the current version of the catalog format. Older
+// catalogs are migrated when they are loaded, see migrateCatalog.
+const catalogVersion = 2
+
+// catalogLockWait is how long to wait for another instance to finish updating the catalog
+const catalogLockWait = 30 * time.Second
+
+// catalogStaleLock is how old a catalog lock must be before it is taken over;
+// updates only hold it for a moment
+const catalogStaleLock = 5 * time.Minute
+
+// Catalog is the persistent record of a downloads tree across runs. It can
+// be shared by several machines syncing the same collection, see -catalog.
+type Catalog struct {
+	Version   int                       `json:"version"`
+	Sources   map[string]*CatalogSource `json:"sources"`   // Keyed by archive.org identifier
+	Bandwidth map[string]int64          `json:"bandwidth"` // Bytes transferred per day (YYYY-MM-DD)
+	Runs      []RunRecord               `json:"runs"`
+	UpdatedAt time.Time                 `json:"updated_at"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// CatalogSource is a source whose files were all downloaded
+type CatalogSource struct {
+	Identifier   string    `json:"identifier"`
+	Band         string    `json:"band"`
+	Year         string    `json:"year"`
+	Date         string    `json:"date"`
+	ShowDir      string    `json:"show_dir"` // Relative to the output directory
+	Format       string    `json:"format"`
+	Rating       float64   `json:"rating"`
+	Soundboard   bool      `json:"soundboard"`
+	Files        int       `json:"files"`
+	Bytes        int64     `json:"bytes"`
+	Host         string    `json:"host"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	SupersededBy string    `json:"superseded_by,omitempty"` // Identifier of the upgrade kept alongside
+	Provider     string    `json:"provider,omitempty"`      // Who served the show's sources, when not relisten.org
+	SourceUUID   string    `json:"source_uuid,omitempty"`   // relisten.org source UUID
+
+	Fingerprints map[string]string `json:"fingerprints,omitempty"` // AcoustID fingerprint per local file name, with -fingerprint
+	Hashes       map[string]string `json:"hashes,omitempty"`       // md5 per local file name, as the file is on disk
+}
+
+// CatalogFile is a file of a cataloged source, as found by its md5
+type CatalogFile struct {
+	Identifier string
+	ShowDir    string // Relative to the output directory
+	Name       string // Local file name
+	MD5        string
+}
+
+// RunRecord describes a single download run
+type RunRecord struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Band       string    `json:"band"`
+	Year       string    `json:"year"`
+	Files      int       `json:"files"`
+	Bytes      int64     `json:"bytes"`
+	Aborted    bool      `json:"aborted,omitempty"`
+	Sync       bool      `json:"sync,omitempty"` // A sync run, see dead-dl sync
+}
+
+// catalogPath returns the default catalog location for an output directory
+func catalogPath(outputDir string) string {
+	return filepath.Join(outputDir, CatalogDirName, CatalogFileName)
+}
+
+// catalogFile returns the catalog used by a run: -catalog, or the default under -output
+func catalogFile(cfg *Config) string {
+	if cfg.Catalog != "" {
+		return cfg.Catalog
+	}
+	return catalogPath(cfg.OutputDir)
+}
+
+// CatalogStore is where the catalog is kept: a JSON file, or a PostgreSQL
+// database when -catalog is a postgres:// URL
+type CatalogStore interface {
+	// Load returns the whole catalog
+	Load() (*Catalog, error)
+	// LookupSource returns a downloaded source by archive.org identifier
+	LookupSource(identifier string) (*CatalogSource, bool, error)
+	// AddSource records a completely downloaded source, replacing any entry
+	// with the same identifier
+	AddSource(src *CatalogSource) error
+	// RemoveSource drops a source that is no longer on disk
+	RemoveSource(identifier string) error
+	// RecordRun adds a finished run and the bytes it transferred on each day
+	RecordRun(run RunRecord, days map[string]int64) error
+	// Import merges another catalog, such as one written by catalog export
+	Import(other *Catalog) error
+	// DuplicateFiles returns the files of all sources grouped by md5, for
+	// every md5 more than one file has
+	DuplicateFiles() ([][]CatalogFile, error)
+	Close() error
+}
+
+// openCatalog opens the catalog store configured for a run
+func openCatalog(cfg *Config) (CatalogStore, error) {
+	location := catalogFile(cfg)
+	if isPostgresURL(location) {
+		return openPostgresCatalog(location)
+	}
+	return &fileCatalog{path: location}, nil
+}
+
+// fileCatalog keeps the catalog in a JSON file, re-reading it on every
+// access so changes by instances sharing the file are seen
+type fileCatalog struct {
+	path string
+}
+
+func (f *fileCatalog) Load() (*Catalog, error) {
+	return loadCatalog(f.path)
+}
+
+func (f *fileCatalog) LookupSource(identifier string) (*CatalogSource, bool, error) {
+	c, err := loadCatalog(f.path)
+	if err != nil {
+		return nil, false, err
+	}
+	src, ok := c.Lookup(identifier)
+	return src, ok, nil
+}
+
+func (f *fileCatalog) AddSource(src *CatalogSource) error {
+	return updateCatalog(f.path, func(c *Catalog) {
+		c.AddSource(src)
+	})
+}
+
+func (f *fileCatalog) RemoveSource(identifier string) error {
+	return updateCatalog(f.path, func(c *Catalog) {
+		c.RemoveSource(identifier)
+	})
+}
+
+func (f *fileCatalog) RecordRun(run RunRecord, days map[string]int64) error {
+	return updateCatalog(f.path, func(c *Catalog) {
+		c.RecordRun(run, days)
+	})
+}
+
+func (f *fileCatalog) Import(other *Catalog) error {
+	return updateCatalog(f.path, func(c *Catalog) {
+		c.Merge(other)
+	})
+}
+
+func (f *fileCatalog) DuplicateFiles() ([][]CatalogFile, error) {
+	c, err := loadCatalog(f.path)
+	if err != nil {
+		return nil, err
+	}
+	return c.DuplicateFiles(), nil
+}
+
+func (f *fileCatalog) Close() error {
+	return nil
+}
+
+// loadCatalog reads the catalog at path; a missing file yields an empty catalog
+func loadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = []byte(fmt.Sprintf(`{"version": %d}`, catalogVersion)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parseCatalog(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse catalog %s: %w", path, err)
+	}
+	c.path = path
+	return c, nil
+}
+
+// parseCatalog decodes a catalog file, migrating it to the current version
+func parseCatalog(data []byte) (*Catalog, error) {
+	// Catalogs without a version field predate versioning
+	c := &Catalog{Version: 1}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if err := migrateCatalog(c); err != nil {
+		return nil, err
+	}
+	if c.Sources == nil {
+		c.Sources = make(map[string]*CatalogSource)
+	}
+	if c.Bandwidth == nil {
+		c.Bandwidth = make(map[string]int64)
+	}
+	return c, nil
+}
+
+// migrateCatalog upgrades a catalog written by an older dead-dl one version
+// at a time. The migrated catalog is written back on the next update.
+func migrateCatalog(c *Catalog) error {
+	if c.Version > catalogVersion {
+		return fmt.Errorf("catalog version %d is newer than this dead-dl supports (%d), please upgrade", c.Version, catalogVersion)
+	}
+
+	for c.Version < catalogVersion {
+		switch c.Version {
+		case 1:
+			// Version 2 added the year to sources
+			for _, src := range c.Sources {
+				src.Year = dateYear(src.Date)
+			}
+		}
+		c.Version++
+	}
+	return nil
+}
+
+// dateYear returns the year of a YYYY-MM-DD show date
+func dateYear(date string) string {
+	if len(date) < 4 {
+		return ""
+	}
+	return date[:4]
+}
+
+// updateCatalog applies fn to the catalog at path while holding its lock, so
+// instances on other machines sharing the file don't overwrite each other
+func updateCatalog(path string, fn func(*Catalog)) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	lock, err := lockCatalog(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			logger.Warn("Failed to release catalog lock: %v", err)
+		}
+	}()
+
+	// Re-read under the lock to pick up changes made by other instances
+	c, err := loadCatalog(path)
+	if err != nil {
+		return err
+	}
+	fn(c)
+
+	// An update that took longer than catalogStaleLock lost the lock to
+	// another instance, which may have written the catalog since
+	if !lock.Held() {
+		return fmt.Errorf("%w: the lock was taken over before the update was saved", ErrCatalogLocked)
+	}
+	return c.Save()
+}
+
+// lockCatalog waits for the catalog lock file next to the catalog
+func lockCatalog(path string) (*FileLock, error) {
+	deadline := time.Now().Add(catalogLockWait)
+	for {
+		lock, err := acquireFileLock(path+".lock", catalogStaleLock, ErrCatalogLocked)
+		if err == nil || !errors.Is(err, ErrCatalogLocked) || time.Now().After(deadline) {
+			return lock, err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// Lookup returns the cataloged source with the given identifier, if any
+func (c *Catalog) Lookup(identifier string) (*CatalogSource, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	src, ok := c.Sources[identifier]
+	return src, ok
+}
+
+// AddSource records a completely downloaded source
+func (c *Catalog) AddSource(src *CatalogSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Sources[src.Identifier] = src
+}
+
+// RemoveSource drops the source with the given identifier
+func (c *Catalog) RemoveSource(identifier string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.Sources, identifier)
+}
+
+// RecordRun adds a finished run and the bytes it transferred on each day
+func (c *Catalog) RecordRun(run RunRecord, days map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Runs = append(c.Runs, run)
+	for day, bytes := range days {
+		c.Bandwidth[day] += bytes
+	}
+}
+
+// Merge adds the sources, runs, and bandwidth of another catalog. Sources
+// replace entries with the same identifier, runs already present are skipped,
+// and each day keeps the larger byte count, so merging the same catalog twice
+// changes nothing.
+func (c *Catalog) Merge(other *Catalog) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, src := range other.Sources {
+		c.Sources[id] = src
+	}
+
+	runKey := func(run RunRecord) string {
+		return fmt.Sprintf("%d/%s/%s", run.StartedAt.UnixNano(), run.Band, run.Year)
+	}
+	known := make(map[string]bool, len(c.Runs))
+	for _, run := range c.Runs {
+		known[runKey(run)] = true
+	}
+	for _, run := range other.Runs {
+		if !known[runKey(run)] {
+			c.Runs = append(c.Runs, run)
+		}
+	}
+	sort.Slice(c.Runs, func(i, j int) bool { return c.Runs[i].StartedAt.Before(c.Runs[j].StartedAt) })
+
+	for day, bytes := range other.Bandwidth {
+		if bytes > c.Bandwidth[day] {
+			c.Bandwidth[day] = bytes
+		}
+	}
+}
+
+// DuplicateFiles indexes the files of all sources by md5 and returns the
+// groups of files sharing one, ordered by md5, identifier, and file name
+func (c *Catalog) DuplicateFiles() [][]CatalogFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index := make(map[string][]CatalogFile)
+	for _, src := range c.Sources {
+		for name, sum := range src.Hashes {
+			sum = strings.ToLower(sum)
+			index[sum] = append(index[sum], CatalogFile{Identifier: src.Identifier, ShowDir: src.ShowDir, Name: name, MD5: sum})
+		}
+	}
+
+	var groups [][]CatalogFile
+	for _, files := range index {
+		if len(files) < 2 {
+			continue
+		}
+		sort.Slice(files, func(i, j int) bool {
+			if files[i].Identifier != files[j].Identifier {
+				return files[i].Identifier < files[j].Identifier
+			}
+			return files[i].Name < files[j].Name
+		})
+		groups = append(groups, files)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].MD5 < groups[j][0].MD5 })
+	return groups
+}
+
+// Save writes the catalog atomically
+func (c *Catalog) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := c.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, c.path)
+}
+
+// catalogedElsewhere returns the catalog entry of a source downloaded by
+// another machine. Sources downloaded by this machine are checked against
+// the files on disk as usual, so missing files still get repaired.
+func catalogedElsewhere(store CatalogStore, identifier string) (*CatalogSource, bool) {
+	src, ok, err := store.LookupSource(identifier)
+	if err != nil {
+		logger.Warn("Failed to look up %s in the catalog: %v", identifier, err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	host, _ := os.Hostname()
+	return src, src.Host != host
+}
+
+// catalogSource records a source in the catalog once every planned file is
+// listed in its manifest
+func catalogSource(cfg *Config, store CatalogStore, show Show, sp *SourcePlan) {
+	manifest, err := loadManifest(sp.ShowDir)
+	if err != nil {
+		logger.Warn("Failed to load manifest of %s: %v", sp.ShowDir, err)
+		return
+	}
+
+	src := &CatalogSource{
+		Identifier: sp.Identifier,
+		Band:       cfg.Band,
+		Year:       dateYear(show.DisplayDate),
+		Date:       show.DisplayDate,
+		ShowDir:    sp.ShowDir,
+		Rating:     sp.Source.AvgRating,
+		Soundboard: sp.Source.IsSoundboard,
+		SourceUUID: sp.Source.UUID,
+	}
+	if show.Provider != ProviderRelisten {
+		src.Provider = show.Provider
+	}
+	if rel, err := filepath.Rel(cfg.OutputDir, sp.ShowDir); err == nil {
+		src.ShowDir = filepath.ToSlash(rel)
+	}
+
+	for _, file := range sp.Files {
+		fileName, _ := localFileNames(file, cfg)
+		entry, ok := manifest.Lookup(fileName)
+		if !ok {
+			return
+		}
+		src.Files++
+		src.Bytes += entry.Size
+		if entry.Fingerprint != "" {
+			if src.Fingerprints == nil {
+				src.Fingerprints = make(map[string]string)
+			}
+			src.Fingerprints[fileName] = entry.Fingerprint
+		}
+		if sum := entry.diskMD5(); sum != "" {
+			if src.Hashes == nil {
+				src.Hashes = make(map[string]string)
+			}
+			src.Hashes[fileName] = sum
+		}
+		switch {
+		case isFlacFile(file):
+			src.Format = "flac"
+		case isMp3File(file) && src.Format == "":
+			src.Format = "mp3"
+		}
+	}
+
+	src.Host, _ = os.Hostname()
+	src.DownloadedAt = time.Now()
+
+	if err := store.AddSource(src); err != nil {
+		logger.Warn("Failed to update catalog: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runCatalog handles `dead-dl catalog export|import|bootstrap|itunes`
+func runCatalog(args []string) {
+	if len(args) == 0 {
+		catalogUsage()
+	}
+
+	fs := flag.NewFlagSet("catalog "+args[0], flag.ExitOnError)
+	outputDir := fs.String("output", "./downloads", "Output directory whose catalog to use")
+	catalog := fs.String("catalog", "", "Catalog file or postgres:// URL to use instead of the catalog under -output")
+	outFile := fs.String("o", "", "File to export to (default stdout)")
+	lookup := fs.Bool("lookup", true, "Look up show directories without a manifest on relisten.org and archive.org")
+	fs.Parse(args[1:])
+
+	initLogger(os.Stderr)
+
+	store, err := openCatalog(&Config{OutputDir: *outputDir, Catalog: *catalog})
+	if err != nil {
+		logger.Fatal("Failed to open catalog: %v", err)
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "export":
+		err = exportCatalog(store, *outFile)
+	case "itunes":
+		err = exportITunes(store, *outputDir, *outFile)
+	case "import":
+		if fs.NArg() != 1 {
+			catalogUsage()
+		}
+		err = importCatalog(store, fs.Arg(0))
+	case "bootstrap":
+		var result BootstrapResult
+		result, err = bootstrapCatalog(store, *outputDir, *lookup)
+		logger.Printf("Added %d source(s), %d already cataloged, %d unidentified\n",
+			result.Added, result.Known, len(result.Unresolved))
+		for _, dir := range result.Unresolved {
+			logger.Printf("  - %s\n", dir)
+		}
+	default:
+		catalogUsage()
+	}
+	if err != nil {
+		logger.Fatal("catalog %s failed: %v", args[0], err)
+	}
+}
+
+func catalogUsage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  dead-dl catalog export [-output dir | -catalog file] [-o catalog.json]
+  dead-dl catalog import [-output dir | -catalog file] catalog.json
+  dead-dl catalog bootstrap [-output dir] [-catalog file] [-lookup=false]
+  dead-dl catalog itunes [-output dir] [-catalog file] [-o Library.xml]`)
+	os.Exit(2)
+}
+
+// exportCatalog writes the whole catalog as JSON in the catalog file format
+func exportCatalog(store CatalogStore, path string) error {
+	c, err := store.Load()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// importCatalog merges an exported catalog, migrating it first if it was
+// written by an older version
+func importCatalog(store CatalogStore, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	other, err := parseCatalog(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if err := store.Import(other); err != nil {
+		return err
+	}
+	logger.Info("Imported %d source(s) and %d run(s) from %s", len(other.Sources), len(other.Runs), path)
+	return nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresMigrations upgrade the catalog schema; migration i brings the
+// database to version i+1. The tables are plain tables so the collection can
+// be queried with any SQL tooling.
+var postgresMigrations = []string{
+	// Version 1: sources, runs, and bandwidth
+	`CREATE TABLE IF NOT EXISTS sources (
+		identifier    TEXT PRIMARY KEY,
+		band          TEXT NOT NULL,
+		date          TEXT NOT NULL,
+		show_dir      TEXT NOT NULL,
+		format        TEXT NOT NULL,
+		rating        DOUBLE PRECISION NOT NULL,
+		soundboard    BOOLEAN NOT NULL,
+		files         INTEGER NOT NULL,
+		bytes         BIGINT NOT NULL,
+		host          TEXT NOT NULL,
+		downloaded_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS runs (
+		id          SERIAL PRIMARY KEY,
+		started_at  TIMESTAMPTZ NOT NULL,
+		finished_at TIMESTAMPTZ NOT NULL,
+		band        TEXT NOT NULL,
+		year        TEXT NOT NULL,
+		files       INTEGER NOT NULL,
+		bytes       BIGINT NOT NULL,
+		aborted     BOOLEAN NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS bandwidth (
+		day   DATE PRIMARY KEY,
+		bytes BIGINT NOT NULL
+	);`,
+
+	// Version 2: the year of each source
+	`ALTER TABLE sources ADD COLUMN year TEXT NOT NULL DEFAULT '';
+	UPDATE sources SET year = substr(date, 1, 4);`,
+
+	// Version 3: upgrades kept alongside the source they replace
+	`ALTER TABLE sources ADD COLUMN superseded_by TEXT NOT NULL DEFAULT '';`,
+
+	// Version 4: AcoustID fingerprints per file
+	`ALTER TABLE sources ADD COLUMN fingerprints JSONB NOT NULL DEFAULT '{}';`,
+
+	// Version 5: the provider that served a source while relisten.org was down
+	`ALTER TABLE sources ADD COLUMN provider TEXT NOT NULL DEFAULT '';`,
+
+	// Version 6: relisten.org source UUIDs, and which runs were syncs
+	`ALTER TABLE sources ADD COLUMN source_uuid TEXT NOT NULL DEFAULT '';
+	ALTER TABLE runs ADD COLUMN sync BOOLEAN NOT NULL DEFAULT FALSE;`,
+
+	// Version 7: the md5 of every file, indexed for dedup
+	`CREATE TABLE file_hashes (
+		identifier TEXT NOT NULL REFERENCES sources ON DELETE CASCADE,
+		file_name  TEXT NOT NULL,
+		md5        TEXT NOT NULL,
+		PRIMARY KEY (identifier, file_name)
+	);
+	CREATE INDEX file_hashes_md5 ON file_hashes (md5);`,
+}
+
+const sourceColumns = "identifier, band, year, date, show_dir, format, rating, soundboard, files, bytes, host, downloaded_at, superseded_by, fingerprints, provider, source_uuid"
+
+// isPostgresURL reports whether a -catalog value names a PostgreSQL database
+func isPostgresURL(location string) bool {
+	return strings.HasPrefix(location, "postgres://") || strings.HasPrefix(location, "postgresql://")
+}
+
+// postgresCatalog keeps the catalog in a PostgreSQL database
+type postgresCatalog struct {
+	db *sql.DB
+}
+
+// openPostgresCatalog connects to the database and migrates it to the current schema
+func openPostgresCatalog(url string) (*postgresCatalog, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if err := migratePostgres(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate catalog schema: %w", err)
+	}
+	return &postgresCatalog{db: db}, nil
+}
+
+// postgresMigrationLock is the key of the advisory lock that instances
+// migrating the same database take turns on ("dead-dl" in ASCII)
+const postgresMigrationLock = 0x646561642d646c
+
+// migratePostgres applies the migrations the database hasn't seen yet, each
+// in its own transaction together with the version bump. Each transaction
+// takes the migration lock and reads the version under it, so instances
+// starting at the same time don't apply a migration twice.
+func migratePostgres(db *sql.DB) error {
+	for {
+		done, err := migratePostgresStep(db)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// migratePostgresStep applies the next migration, or reports that the
+// schema is up to date
+func migratePostgresStep(db *sql.DB) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", postgresMigrationLock); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		return false, err
+	}
+	var version int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return false, err
+	}
+	if version > len(postgresMigrations) {
+		return false, fmt.Errorf("schema version %d is newer than this dead-dl supports (%d), please upgrade", version, len(postgresMigrations))
+	}
+	if version == len(postgresMigrations) {
+		return true, nil
+	}
+
+	if _, err := tx.Exec(postgresMigrations[version]); err != nil {
+		return false, fmt.Errorf("migration to version %d: %w", version+1, err)
+	}
+	if _, err := tx.Exec("DELETE FROM schema_version"); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version) VALUES ($1)", version+1); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	logger.Debug("Migrated catalog schema to version %d", version+1)
+	return false, nil
+}
+
+func (p *postgresCatalog) Load() (*Catalog, error) {
+	c := &Catalog{
+		Version:   catalogVersion,
+		Sources:   make(map[string]*CatalogSource),
+		Bandwidth: make(map[string]int64),
+	}
+
+	rows, err := p.db.Query("SELECT " + sourceColumns + " FROM sources")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		src, err := scanSource(rows)
+		if err != nil {
+			return nil, err
+		}
+		c.Sources[src.Identifier] = src
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hashRows, err := p.db.Query("SELECT identifier, file_name, md5 FROM file_hashes")
+	if err != nil {
+		return nil, err
+	}
+	defer hashRows.Close()
+	for hashRows.Next() {
+		var file CatalogFile
+		if err := hashRows.Scan(&file.Identifier, &file.Name, &file.MD5); err != nil {
+			return nil, err
+		}
+		if src, ok := c.Sources[file.Identifier]; ok {
+			if src.Hashes == nil {
+				src.Hashes = make(map[string]string)
+			}
+			src.Hashes[file.Name] = file.MD5
+		}
+	}
+	if err := hashRows.Err(); err != nil {
+		return nil, err
+	}
+
+	runRows, err := p.db.Query(`SELECT started_at, finished_at, band, year, files, bytes, aborted, sync
+		FROM runs ORDER BY started_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer runRows.Close()
+	for runRows.Next() {
+		var run RunRecord
+		if err := runRows.Scan(&run.StartedAt, &run.FinishedAt, &run.Band, &run.Year,
+			&run.Files, &run.Bytes, &run.Aborted, &run.Sync); err != nil {
+			return nil, err
+		}
+		c.Runs = append(c.Runs, run)
+	}
+	if err := runRows.Err(); err != nil {
+		return nil, err
+	}
+
+	dayRows, err := p.db.Query("SELECT day, bytes FROM bandwidth")
+	if err != nil {
+		return nil, err
+	}
+	defer dayRows.Close()
+	for dayRows.Next() {
+		var day time.Time
+		var bytes int64
+		if err := dayRows.Scan(&day, &bytes); err != nil {
+			return nil, err
+		}
+		c.Bandwidth[day.Format("2006-01-02")] = bytes
+	}
+	return c, dayRows.Err()
+}
+
+func (p *postgresCatalog) LookupSource(identifier string) (*CatalogSource, bool, error) {
+	row := p.db.QueryRow("SELECT "+sourceColumns+" FROM sources WHERE identifier = $1", identifier)
+	src, err := scanSource(row)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	rows, err := p.db.Query("SELECT file_name, md5 FROM file_hashes WHERE identifier = $1", identifier)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, sum string
+		if err := rows.Scan(&name, &sum); err != nil {
+			return nil, false, err
+		}
+		if src.Hashes == nil {
+			src.Hashes = make(map[string]string)
+		}
+		src.Hashes[name] = sum
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return src, true, nil
+}
+
+func (p *postgresCatalog) AddSource(src *CatalogSource) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addPostgresSource(tx, src); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlExecer is implemented by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// addPostgresSource inserts or replaces a source along with its file hashes.
+// It takes several statements, so db should be a transaction.
+func addPostgresSource(db sqlExecer, src *CatalogSource) error {
+	fingerprints, err := json.Marshal(src.Fingerprints)
+	if err != nil {
+		return err
+	}
+	if src.Fingerprints == nil {
+		fingerprints = []byte("{}")
+	}
+	_, err = db.Exec(`INSERT INTO sources (`+sourceColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (identifier) DO UPDATE SET
+			band = EXCLUDED.band, year = EXCLUDED.year, date = EXCLUDED.date, show_dir = EXCLUDED.show_dir,
+			format = EXCLUDED.format, rating = EXCLUDED.rating, soundboard = EXCLUDED.soundboard,
+			files = EXCLUDED.files, bytes = EXCLUDED.bytes, host = EXCLUDED.host,
+			downloaded_at = EXCLUDED.downloaded_at, superseded_by = EXCLUDED.superseded_by,
+			fingerprints = EXCLUDED.fingerprints, provider = EXCLUDED.provider, source_uuid = EXCLUDED.source_uuid`,
+		src.Identifier, src.Band, src.Year, src.Date, src.ShowDir, src.Format, src.Rating, src.Soundboard,
+		src.Files, src.Bytes, src.Host, src.DownloadedAt, src.SupersededBy, string(fingerprints), src.Provider, src.SourceUUID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM file_hashes WHERE identifier = $1", src.Identifier); err != nil {
+		return err
+	}
+	for name, sum := range src.Hashes {
+		if _, err := db.Exec("INSERT INTO file_hashes (identifier, file_name, md5) VALUES ($1, $2, $3)",
+			src.Identifier, name, strings.ToLower(sum)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *postgresCatalog) RemoveSource(identifier string) error {
+	_, err := p.db.Exec("DELETE FROM sources WHERE identifier = $1", identifier)
+	return err
+}
+
+func (p *postgresCatalog) RecordRun(run RunRecord, days map[string]int64) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO runs (started_at, finished_at, band, year, files, bytes, aborted, sync)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		run.StartedAt, run.FinishedAt, run.Band, run.Year, run.Files, run.Bytes, run.Aborted, run.Sync); err != nil {
+		return err
+	}
+	for day, bytes := range days {
+		if _, err := tx.Exec(`INSERT INTO bandwidth (day, bytes) VALUES ($1, $2)
+			ON CONFLICT (day) DO UPDATE SET bytes = bandwidth.bytes + EXCLUDED.bytes`, day, bytes); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Import merges another catalog with the same rules as Catalog.Merge
+func (p *postgresCatalog) Import(other *Catalog) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, src := range other.Sources {
+		if err := addPostgresSource(tx, src); err != nil {
+			return err
+		}
+	}
+	for _, run := range other.Runs {
+		if _, err := tx.Exec(`INSERT INTO runs (started_at, finished_at, band, year, files, bytes, aborted, sync)
+			SELECT $1, $2, $3, $4, $5, $6, $7, $8
+			WHERE NOT EXISTS (SELECT 1 FROM runs WHERE started_at = $1 AND band = $3 AND year = $4)`,
+			run.StartedAt, run.FinishedAt, run.Band, run.Year, run.Files, run.Bytes, run.Aborted, run.Sync); err != nil {
+			return err
+		}
+	}
+	for day, bytes := range other.Bandwidth {
+		if _, err := tx.Exec(`INSERT INTO bandwidth (day, bytes) VALUES ($1, $2)
+			ON CONFLICT (day) DO UPDATE SET bytes = GREATEST(bandwidth.bytes, EXCLUDED.bytes)`, day, bytes); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DuplicateFiles looks the md5s shared by several files up in the index on
+// file_hashes
+func (p *postgresCatalog) DuplicateFiles() ([][]CatalogFile, error) {
+	rows, err := p.db.Query(`SELECT f.identifier, s.show_dir, f.file_name, f.md5
+		FROM file_hashes f JOIN sources s ON s.identifier = f.identifier
+		WHERE f.md5 IN (SELECT md5 FROM file_hashes GROUP BY md5 HAVING COUNT(*) > 1)
+		ORDER BY f.md5, f.identifier, f.file_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups [][]CatalogFile
+	for rows.Next() {
+		var file CatalogFile
+		if err := rows.Scan(&file.Identifier, &file.ShowDir, &file.Name, &file.MD5); err != nil {
+			return nil, err
+		}
+		if n := len(groups); n > 0 && groups[n-1][0].MD5 == file.MD5 {
+			groups[n-1] = append(groups[n-1], file)
+		} else {
+			groups = append(groups, []CatalogFile{file})
+		}
+	}
+	return groups, rows.Err()
+}
+
+func (p *postgresCatalog) Close() error {
+	return p.db.Close()
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSource reads a row selected with sourceColumns
+func scanSource(row rowScanner) (*CatalogSource, error) {
+	src := &CatalogSource{}
+	var fingerprints []byte
+	err := row.Scan(&src.Identifier, &src.Band, &src.Year, &src.Date, &src.ShowDir, &src.Format, &src.Rating,
+		&src.Soundboard, &src.Files, &src.Bytes, &src.Host, &src.DownloadedAt, &src.SupersededBy, &fingerprints, &src.Provider, &src.SourceUUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fingerprints, &src.Fingerprints); err != nil {
+		return nil, fmt.Errorf("fingerprints of %s: %w", src.Identifier, err)
+	}
+	if len(src.Fingerprints) == 0 {
+		src.Fingerprints = nil
+	}
+	return src, nil
+}
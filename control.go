@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Commands -progress-socket clients can send, one per line, either as the
+// bare word or as {"command":"pause"}
+const (
+	CommandPause  = "pause"
+	CommandResume = "resume"
+	CommandCancel = "cancel"
+)
+
+// Events emitted when a command changes the state of the run
+const (
+	EventPaused       = "paused"
+	EventResumed      = "resumed"
+	EventCancelled    = "cancelled"
+	EventCommandError = "command_error"
+)
+
+// errRunCancelled is the cause of transfers stopped by a cancel command
+var errRunCancelled = errors.New("run cancelled")
+
+// runControl holds back new downloads while the run is paused and stops
+// everything once it is cancelled. Pausing lets running transfers finish,
+// since a transfer held open would count as stalled.
+type runControl struct {
+	mu        sync.Mutex
+	unpaused  *sync.Cond
+	paused    bool
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	cancelled bool
+}
+
+// control is the process-wide run control that socket commands act on
+var control = newRunControl()
+
+func newRunControl() *runControl {
+	c := &runControl{}
+	c.unpaused = sync.NewCond(&c.mu)
+	c.ctx, c.cancel = context.WithCancelCause(context.Background())
+	return c
+}
+
+// Context is cancelled with errRunCancelled when the run is cancelled;
+// transfers derive their context from it
+func (c *runControl) Context() context.Context {
+	return c.ctx
+}
+
+// Pause holds back new downloads; it reports false if the run was already
+// paused or is cancelled
+func (c *runControl) Pause() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused || c.cancelled {
+		return false
+	}
+	c.paused = true
+	return true
+}
+
+// Resume lets paused downloads start; it reports false if the run wasn't paused
+func (c *runControl) Resume() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		return false
+	}
+	c.paused = false
+	c.unpaused.Broadcast()
+	return true
+}
+
+// Cancel stops running transfers and every download that hasn't started;
+// it reports false if the run was already cancelled
+func (c *runControl) Cancel() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancelled {
+		return false
+	}
+	c.cancelled = true
+	c.paused = false
+	c.cancel(errRunCancelled)
+	c.unpaused.Broadcast()
+	return true
+}
+
+// Cancelled reports whether the run was cancelled
+func (c *runControl) Cancelled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cancelled
+}
+
+// Wait blocks while the run is paused. It reports false once the run is
+// cancelled, in which case nothing new should be started.
+func (c *runControl) Wait() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.paused && !c.cancelled {
+		c.unpaused.Wait()
+	}
+	return !c.cancelled
+}
+
+// readCommands applies the commands a socket client sends until it disconnects
+func readCommands(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		command := line
+		if strings.HasPrefix(line, "{") {
+			var msg struct {
+				Command string `json:"command"`
+			}
+			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+				events.Emit(ProgressEvent{Type: EventCommandError, Error: "invalid command: " + err.Error()})
+				continue
+			}
+			command = msg.Command
+		}
+		applyCommand(strings.ToLower(command))
+	}
+}
+
+// applyCommand carries out a command and announces the change
+func applyCommand(command string) {
+	switch command {
+	case CommandPause:
+		if control.Pause() {
+			logger.Info("Paused by a progress socket client; running transfers finish, new ones wait for resume")
+			events.Emit(ProgressEvent{Type: EventPaused})
+		}
+	case CommandResume:
+		if control.Resume() {
+			logger.Info("Resumed by a progress socket client")
+			events.Emit(ProgressEvent{Type: EventResumed})
+		}
+	case CommandCancel:
+		if control.Cancel() {
+			logger.Warn("Cancelled by a progress socket client, stopping running transfers")
+			events.Emit(ProgressEvent{Type: EventCancelled})
+		}
+	default:
+		events.Emit(ProgressEvent{Type: EventCommandError, Error: "unknown command: " + command})
+	}
+}
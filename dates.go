@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	monthDatePattern = regexp.MustCompile(`^(\d{4})-(\d{1,2})$`)
+	yearRangePattern = regexp.MustCompile(`^(\d{4})(?:-(\d{4}))?$`)
+	showDatePattern  = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// parseYears expands a -year value, a year, a range like 1972-1977, or a
+// comma-separated list of both, into the years it covers
+func parseYears(value string) ([]string, error) {
+	var years []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		m := yearRangePattern.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return nil, fmt.Errorf("invalid -year %q (use e.g. 1977, 1972-1977, or 1972,1974)", value)
+		}
+		first, _ := strconv.Atoi(m[1])
+		last := first
+		if m[2] != "" {
+			last, _ = strconv.Atoi(m[2])
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid -year range %q", part)
+		}
+		for y := first; y <= last; y++ {
+			year := strconv.Itoa(y)
+			if !seen[year] {
+				seen[year] = true
+				years = append(years, year)
+			}
+		}
+	}
+	return years, nil
+}
+
+// matchesYears reports whether a year is among those a -year value covers;
+// an empty value covers every year
+func matchesYears(value, year string) bool {
+	if value == "" {
+		return true
+	}
+	years, err := parseYears(value)
+	if err != nil {
+		return value == year
+	}
+	for _, y := range years {
+		if y == year {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMonthDate splits a -date value like 1972-08 into year and month
+func parseMonthDate(date string) (string, string, error) {
+	m := monthDatePattern.FindStringSubmatch(date)
+	if m == nil {
+		return "", "", fmt.Errorf("invalid -date %q (use YYYY-MM)", date)
+	}
+	month, err := parseMonth(m[2])
+	if err != nil {
+		return "", "", err
+	}
+	return m[1], month, nil
+}
+
+// parseMonth validates a month number and returns it with two digits, as
+// it appears in show dates
+func parseMonth(month string) (string, error) {
+	n, err := strconv.Atoi(month)
+	if err != nil || n < 1 || n > 12 {
+		return "", fmt.Errorf("invalid month %q (use 01 to 12)", month)
+	}
+	return fmt.Sprintf("%02d", n), nil
+}
+
+// filterMonth keeps the shows performed in month; an empty month keeps all
+func filterMonth(shows []Show, month string) []Show {
+	if month == "" {
+		return shows
+	}
+	var kept []Show
+	for _, show := range shows {
+		parts := strings.Split(show.DisplayDate, "-")
+		if len(parts) >= 2 && parts[1] == month {
+			kept = append(kept, show)
+		}
+	}
+	return kept
+}
+
+// checkShowDate validates a YYYY-MM-DD date given to flag
+func checkShowDate(flag, date string) error {
+	if _, err := time.Parse("2006-01-02", date); err != nil || !showDatePattern.MatchString(date) {
+		return fmt.Errorf("invalid %s %q (use YYYY-MM-DD)", flag, date)
+	}
+	return nil
+}
+
+// checkDateRange validates -from and -to
+func checkDateRange(from, to string) error {
+	if from != "" {
+		if err := checkShowDate("-from", from); err != nil {
+			return err
+		}
+	}
+	if to != "" {
+		if err := checkShowDate("-to", to); err != nil {
+			return err
+		}
+	}
+	if from != "" && to != "" && to < from {
+		return fmt.Errorf("-to %s is before -from %s", to, from)
+	}
+	return nil
+}
+
+// yearInRange reports whether a year has days between from and to, either
+// of which may be empty for an open end
+func yearInRange(year, from, to string) bool {
+	return (from == "" || year >= from[:4]) && (to == "" || year <= to[:4])
+}
+
+// filterDates keeps the shows performed between from and to, inclusive
+func filterDates(shows []Show, from, to string) []Show {
+	if from == "" && to == "" {
+		return shows
+	}
+	var kept []Show
+	for _, show := range shows {
+		if (from == "" || show.DisplayDate >= from) && (to == "" || show.DisplayDate <= to) {
+			kept = append(kept, show)
+		}
+	}
+	return kept
+}
+
+// runLabel names what a run downloads, e.g. 1977, 1972-08, 1972-1977-05,
+// 1977-05-08, 1977-05-01_1977-05-31, a tour or era, or the -show identifier,
+// for log messages and the default state file name
+func runLabel(cfg *Config) string {
+	if cfg.Show != "" {
+		return cfg.Show
+	}
+	if cfg.From != "" && cfg.From == cfg.To {
+		return cfg.From
+	}
+	if cfg.Tour != "" {
+		return tourDirName(cfg.Tour)
+	}
+	if cfg.Era != "" && cfg.Year == "" {
+		return tourDirName(cfg.Era)
+	}
+	if cfg.Month != "" {
+		return cfg.Year + "-" + cfg.Month
+	}
+	switch {
+	case cfg.From != "" && cfg.To != "":
+		return cfg.From + "_" + cfg.To
+	case cfg.From != "":
+		return "from-" + cfg.From
+	case cfg.To != "":
+		return "to-" + cfg.To
+	}
+	if cfg.Year == "" {
+		return "all-years"
+	}
+	return cfg.Year
+}
+
+// defaultStateFile is the state file of a run without -state-file
+func defaultStateFile(cfg *Config) string {
+	return filepath.Join(cfg.OutputDir, fmt.Sprintf(".dead-dl-%s-%s.state.json", cfg.Band, runLabel(cfg)))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dedupFile is a file of a show directory
+type dedupFile struct {
+	Path    string
+	ShowDir string
+}
+
+// dedupGroup is a set of identical files; the first is kept and the others
+// are replaced by hard links to it
+type dedupGroup struct {
+	Size  int64
+	Files []dedupFile
+}
+
+// runDedup handles `dead-dl dedup`, which hard-links identical files across
+// the whole output directory, e.g. the same item downloaded for two bands,
+// using the md5 of every file kept in the catalog
+func runDedup(args []string) {
+	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	outputDir := fs.String("output", "./downloads", "Output directory to deduplicate")
+	dryRun := fs.Bool("dry-run", false, "Only report duplicates and how much space linking them would save")
+	catalogFlag := fs.String("catalog", "", "Catalog file or postgres:// URL to use instead of the catalog under -output")
+	staleLock := fs.Duration("stale-lock", DefaultStaleLock, "Age after which another instance's show lock is considered stale")
+	fs.Parse(args)
+
+	initLogger(os.Stderr)
+	defer logger.Close()
+
+	store, err := openCatalog(&Config{OutputDir: *outputDir, Catalog: *catalogFlag})
+	if err != nil {
+		logger.Fatal("Failed to open catalog: %v", err)
+	}
+	defer store.Close()
+
+	groups, err := findDuplicates(store, *outputDir)
+	if err != nil {
+		logger.Fatal("Failed to look up duplicates in the catalog: %v", err)
+	}
+	if len(groups) == 0 {
+		logger.Info("No duplicate files found")
+		return
+	}
+
+	var files int
+	var saved int64
+	for _, g := range groups {
+		keep := g.Files[0]
+		for _, dup := range g.Files[1:] {
+			if *dryRun {
+				logger.Printf("  %s = %s\n", dup.Path, keep.Path)
+			} else if err := linkDuplicate(keep, dup, *staleLock); err != nil {
+				logger.Warn("Failed to link %s: %v", dup.Path, err)
+				continue
+			}
+			files++
+			saved += g.Size
+		}
+	}
+
+	if *dryRun {
+		logger.Printf("%d duplicate file(s), linking them would save %s\n", files, ByteSize(saved))
+		return
+	}
+	logger.Info("Linked %d duplicate file(s), saved %s", files, ByteSize(saved))
+}
+
+// findDuplicates looks up the files sharing an md5 in the catalog's hash
+// index, then confirms each group by the size and md5 of the files on disk,
+// since a file may have changed since it was cataloged. Files that are
+// already links to the same data are left out.
+func findDuplicates(store CatalogStore, outputDir string) ([]dedupGroup, error) {
+	indexed, err := store.DuplicateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []dedupGroup
+	for _, candidates := range indexed {
+		// Candidates of another size are no copies of the kept file
+		bySize := make(map[int64][]dedupFile)
+		var sizes []int64
+		for _, file := range candidates {
+			showDir, err := safeJoin(outputDir, filepath.FromSlash(file.ShowDir))
+			if err != nil {
+				continue
+			}
+			path, err := safeJoin(showDir, file.Name)
+			if err != nil {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if len(bySize[info.Size()]) == 0 {
+				sizes = append(sizes, info.Size())
+			}
+			bySize[info.Size()] = append(bySize[info.Size()], dedupFile{Path: path, ShowDir: showDir})
+		}
+
+		for _, size := range sizes {
+			if same := sameFiles(bySize[size], candidates[0].MD5); len(same) > 1 {
+				groups = append(groups, dedupGroup{Size: size, Files: same})
+			}
+		}
+	}
+	return groups, nil
+}
+
+// sameFiles hashes files and returns those with the given md5, leaving out
+// files that are already links to the first of them
+func sameFiles(files []dedupFile, sum string) []dedupFile {
+	if len(files) < 2 {
+		return nil
+	}
+	var same []dedupFile
+	var kept os.FileInfo
+	for _, file := range files {
+		got, err := fileMD5(file.Path)
+		if err != nil {
+			logger.Warn("Failed to hash %s: %v", file.Path, err)
+			continue
+		}
+		if !strings.EqualFold(got, sum) {
+			continue
+		}
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			continue
+		}
+		if kept == nil {
+			kept = info
+		} else if os.SameFile(kept, info) {
+			continue
+		}
+		same = append(same, file)
+	}
+	return same
+}
+
+// linkDuplicate replaces dup with a hard link to keep. The link is made
+// next to dup and renamed over it, so dup is never missing.
+func linkDuplicate(keep, dup dedupFile, staleLock time.Duration) error {
+	// Don't swap files under an instance that is still writing to the show
+	lock, err := acquireShowLock(dup.ShowDir, staleLock)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	tmp := fmt.Sprintf("%s.dedup-%d", dup.Path, os.Getpid())
+	if err := os.Link(keep.Path, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dup.Path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
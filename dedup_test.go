@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFindDuplicates links the same item cataloged under two bands, found by
+// the catalog's md5 index, and leaves files that changed on disk alone
+func TestFindDuplicates(t *testing.T) {
+	t.Chdir(t.TempDir())
+	initLogger(io.Discard)
+	defer logger.Close()
+
+	out := "out"
+	store := &fileCatalog{path: catalogPath(out)}
+	write := func(showDir, name, content string) {
+		dir := filepath.Join(out, filepath.FromSlash(showDir))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// md5 of "set one" and "set two"
+	const one, two = "c8a710b4e8d04ed64e96fe3622b6fea4", "bb90b161f7186335b874537c24ad3f1d"
+	for _, src := range []*CatalogSource{
+		{Identifier: "jgb1976", ShowDir: "jerry-garcia-band/1976/1976-03-06", Hashes: map[string]string{"d1t01.flac": one, "d1t02.flac": two}},
+		{Identifier: "jgb1976.copy", ShowDir: "grateful-dead/1976/1976-03-06", Hashes: map[string]string{"d1t01.flac": one, "d1t02.flac": two}},
+	} {
+		write(src.ShowDir, "d1t01.flac", "set one")
+		write(src.ShowDir, "d1t02.flac", "set two")
+		if err := store.AddSource(src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("grateful-dead/1976/1976-03-06", "d1t02.flac", "set 2, retagged")
+
+	groups, err := findDuplicates(store, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0].Files) != 2 || groups[0].Size != int64(len("set one")) {
+		t.Fatalf("found %+v, want the two copies of d1t01.flac", groups)
+	}
+	if err := linkDuplicate(groups[0].Files[0], groups[0].Files[1], DefaultStaleLock); err != nil {
+		t.Fatal(err)
+	}
+
+	// Linked copies are no longer duplicates
+	if groups, err := findDuplicates(store, out); err != nil || len(groups) != 0 {
+		t.Errorf("found %+v (%v) after linking, want nothing", groups, err)
+	}
+}
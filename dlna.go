@@ -0,0 +1,518 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNoSuchObject = errors.New("no such object")
+
+// dlnaUUID derives a stable device UUID from the host and the served
+// directory, so TVs recognize the server across restarts
+func dlnaUUID(root string) string {
+	host, _ := os.Hostname()
+	sum := md5.Sum([]byte(host + "\x00" + root))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// dlnaServer implements the ContentDirectory and ConnectionManager services
+// of a UPnP media server over the catalog
+type dlnaServer struct {
+	store    CatalogStore
+	root     string
+	name     string
+	uuid     string
+	updateID uint32
+}
+
+// register adds the device description, service, and media endpoints
+func (s *dlnaServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("/rootDesc.xml", s.serveDeviceDescription)
+	mux.HandleFunc("/ContentDirectory.xml", serveXML(contentDirectorySCPD))
+	mux.HandleFunc("/ConnectionManager.xml", serveXML(connectionManagerSCPD))
+	mux.HandleFunc("/ctl/ContentDirectory", s.serveContentDirectory)
+	mux.HandleFunc("/ctl/ConnectionManager", s.serveConnectionManager)
+	mux.HandleFunc("/evt/", s.serveEvents)
+	mux.HandleFunc("/media/", s.serveMedia)
+}
+
+func serveXML(doc string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		io.WriteString(w, doc)
+	}
+}
+
+func (s *dlnaServer) serveDeviceDescription(w http.ResponseWriter, r *http.Request) {
+	serveXML(fmt.Sprintf(deviceDescription, xmlEscape(s.name), s.uuid))(w, r)
+}
+
+// serveEvents accepts event subscriptions, which some renderers require
+// before browsing. The collection only changes between runs, so no events
+// are ever sent.
+func (s *dlnaServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		w.Header().Set("SID", "uuid:"+s.uuid)
+		w.Header().Set("TIMEOUT", "Second-1800")
+	case "UNSUBSCRIBE":
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *dlnaServer) serveContentDirectory(w http.ResponseWriter, r *http.Request) {
+	const service = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	action, args, err := readSOAPAction(r)
+	if err != nil {
+		soapFault(w, 402, "Invalid Args")
+		return
+	}
+
+	switch action {
+	case "Browse":
+		result, returned, total, err := s.browse(args, "http://"+r.Host)
+		if errors.Is(err, errNoSuchObject) {
+			soapFault(w, 701, "No such object")
+			return
+		}
+		if err != nil {
+			logger.Warn("DLNA browse of %s failed: %v", args["ObjectID"], err)
+			soapFault(w, 501, "Action Failed")
+			return
+		}
+		soapResponse(w, service, action,
+			"Result", result,
+			"NumberReturned", strconv.Itoa(returned),
+			"TotalMatches", strconv.Itoa(total),
+			"UpdateID", fmt.Sprint(s.updateID))
+	case "GetSystemUpdateID":
+		soapResponse(w, service, action, "Id", fmt.Sprint(s.updateID))
+	case "GetSearchCapabilities":
+		soapResponse(w, service, action, "SearchCaps", "")
+	case "GetSortCapabilities":
+		soapResponse(w, service, action, "SortCaps", "")
+	default:
+		soapFault(w, 401, "Invalid Action")
+	}
+}
+
+func (s *dlnaServer) serveConnectionManager(w http.ResponseWriter, r *http.Request) {
+	const service = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	action, _, err := readSOAPAction(r)
+	if err != nil {
+		soapFault(w, 402, "Invalid Args")
+		return
+	}
+
+	switch action {
+	case "GetProtocolInfo":
+		var protocols []string
+		for _, mime := range mediaTypes {
+			protocols = append(protocols, protocolInfo(mime))
+		}
+		sort.Strings(protocols)
+		soapResponse(w, service, action, "Source", strings.Join(protocols, ","), "Sink", "")
+	case "GetCurrentConnectionIDs":
+		soapResponse(w, service, action, "ConnectionIDs", "0")
+	case "GetCurrentConnectionInfo":
+		soapResponse(w, service, action,
+			"RcsID", "-1", "AVTransportID", "-1", "ProtocolInfo", "",
+			"PeerConnectionManager", "", "PeerConnectionID", "-1",
+			"Direction", "Output", "Status", "OK")
+	default:
+		soapFault(w, 401, "Invalid Action")
+	}
+}
+
+// serveMedia streams a track, /media/<identifier>/<n>, with range support
+// for seeking
+func (s *dlnaServer) serveMedia(w http.ResponseWriter, r *http.Request) {
+	identifier, n, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/media/"), "/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	lib, err := loadMediaLibrary(s.store, s.root, s.name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	track, err := lib.object("track:" + identifier + "/" + n)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(track.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", track.MIME)
+	// Some renderers match these header names case-sensitively
+	w.Header()["transferMode.dlna.org"] = []string{"Streaming"}
+	w.Header()["contentFeatures.dlna.org"] = []string{"DLNA.ORG_OP=01"}
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// browse answers a Browse action with DIDL-Lite, returning the result, how
+// many objects it holds, and how many there are in total
+func (s *dlnaServer) browse(args map[string]string, baseURL string) (string, int, int, error) {
+	lib, err := loadMediaLibrary(s.store, s.root, s.name)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	var objects []mediaObject
+	switch args["BrowseFlag"] {
+	case "BrowseMetadata":
+		obj, err := lib.object(args["ObjectID"])
+		if err != nil {
+			return "", 0, 0, err
+		}
+		objects = []mediaObject{obj}
+	case "BrowseDirectChildren":
+		if objects, err = lib.children(args["ObjectID"]); err != nil {
+			return "", 0, 0, err
+		}
+	default:
+		return "", 0, 0, fmt.Errorf("unknown BrowseFlag %q", args["BrowseFlag"])
+	}
+
+	total := len(objects)
+	start, _ := strconv.Atoi(args["StartingIndex"])
+	count, _ := strconv.Atoi(args["RequestedCount"])
+	if start < 0 || start > total {
+		start = total
+	}
+	objects = objects[start:]
+	if count > 0 && count < len(objects) {
+		objects = objects[:count]
+	}
+	return renderDIDL(objects, baseURL), len(objects), total, nil
+}
+
+// object returns the metadata of an object
+func (l *mediaLibrary) object(id string) (mediaObject, error) {
+	kind, rest, _ := strings.Cut(id, ":")
+	switch kind {
+	case "0":
+		return mediaObject{ID: "0", ParentID: "-1", Title: l.name,
+			Class: "object.container", Children: len(l.bands)}, nil
+	case "band":
+		if years, ok := l.years[rest]; ok {
+			return l.bandObject(rest, years), nil
+		}
+	case "year":
+		if sources, ok := l.shows[rest]; ok {
+			band, year, _ := strings.Cut(rest, "/")
+			return l.yearObject(band, year, sources), nil
+		}
+	case "show":
+		if src, ok := l.sources[rest]; ok {
+			return l.showObject(src), nil
+		}
+	case "track":
+		identifier, n, _ := strings.Cut(rest, "/")
+		if src, ok := l.sources[identifier]; ok {
+			for _, track := range l.tracks(src) {
+				if track.ID == "track:"+identifier+"/"+n {
+					return track, nil
+				}
+			}
+		}
+	}
+	return mediaObject{}, errNoSuchObject
+}
+
+// children returns the objects of a container, in display order
+func (l *mediaLibrary) children(id string) ([]mediaObject, error) {
+	var objects []mediaObject
+	kind, rest, _ := strings.Cut(id, ":")
+	switch kind {
+	case "0":
+		for _, band := range l.bands {
+			objects = append(objects, l.bandObject(band, l.years[band]))
+		}
+	case "band":
+		years, ok := l.years[rest]
+		if !ok {
+			return nil, errNoSuchObject
+		}
+		for _, year := range years {
+			objects = append(objects, l.yearObject(rest, year, l.shows[rest+"/"+year]))
+		}
+	case "year":
+		sources, ok := l.shows[rest]
+		if !ok {
+			return nil, errNoSuchObject
+		}
+		for _, src := range sources {
+			objects = append(objects, l.showObject(src))
+		}
+	case "show":
+		src, ok := l.sources[rest]
+		if !ok {
+			return nil, errNoSuchObject
+		}
+		objects = l.tracks(src)
+	default:
+		return nil, errNoSuchObject
+	}
+	return objects, nil
+}
+
+func (l *mediaLibrary) bandObject(band string, years []string) mediaObject {
+	return mediaObject{ID: "band:" + band, ParentID: "0", Title: bandDisplayName(band),
+		Class: "object.container.person.musicArtist", Children: len(years)}
+}
+
+func (l *mediaLibrary) yearObject(band, year string, sources []*CatalogSource) mediaObject {
+	return mediaObject{ID: "year:" + band + "/" + year, ParentID: "band:" + band, Title: year,
+		Class: "object.container.storageFolder", Children: len(sources)}
+}
+
+func (l *mediaLibrary) showObject(src *CatalogSource) mediaObject {
+	return mediaObject{ID: "show:" + src.Identifier, ParentID: "year:" + src.Band + "/" + src.Year,
+		Title: l.showTitle(src), Class: "object.container.album.musicAlbum",
+		Children: len(l.tracks(src)), Artist: bandDisplayName(src.Band), Date: src.Date}
+}
+
+// renderDIDL writes objects as a DIDL-Lite document
+func renderDIDL(objects []mediaObject, baseURL string) string {
+	var b strings.Builder
+	b.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	for _, o := range objects {
+		if !o.isItem() {
+			fmt.Fprintf(&b, `<container id="%s" parentID="%s" restricted="1" searchable="0" childCount="%d">`,
+				xmlEscape(o.ID), xmlEscape(o.ParentID), o.Children)
+		} else {
+			fmt.Fprintf(&b, `<item id="%s" parentID="%s" restricted="1">`, xmlEscape(o.ID), xmlEscape(o.ParentID))
+		}
+		fmt.Fprintf(&b, "<dc:title>%s</dc:title><upnp:class>%s</upnp:class>", xmlEscape(o.Title), o.Class)
+		if o.Artist != "" {
+			fmt.Fprintf(&b, "<upnp:artist>%s</upnp:artist><dc:creator>%s</dc:creator>", xmlEscape(o.Artist), xmlEscape(o.Artist))
+		}
+		if o.Date != "" {
+			fmt.Fprintf(&b, "<dc:date>%s</dc:date>", xmlEscape(o.Date))
+		}
+		if !o.isItem() {
+			b.WriteString("</container>")
+			continue
+		}
+
+		fmt.Fprintf(&b, "<upnp:album>%s</upnp:album><upnp:originalTrackNumber>%d</upnp:originalTrackNumber>",
+			xmlEscape(o.Album), o.TrackNumber)
+		fmt.Fprintf(&b, `<res protocolInfo="%s" size="%d"`, protocolInfo(o.MIME), o.Size)
+		if o.Duration > 0 {
+			fmt.Fprintf(&b, ` duration="%s"`, didlDuration(o.Duration))
+		}
+		fmt.Fprintf(&b, ">%s</res></item>", xmlEscape(baseURL+o.URLPath))
+	}
+	b.WriteString("</DIDL-Lite>")
+	return b.String()
+}
+
+// protocolInfo describes how a content type is served: over HTTP, with byte
+// range seeking
+func protocolInfo(mime string) string {
+	return "http-get:*:" + mime + ":DLNA.ORG_OP=01"
+}
+
+// didlDuration formats seconds as H:MM:SS.mmm
+func didlDuration(seconds float64) string {
+	ms := int64(seconds * 1000)
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// readSOAPAction returns the action of a SOAP request and its arguments
+func readSOAPAction(r *http.Request) (string, map[string]string, error) {
+	header := strings.Trim(r.Header.Get("SOAPACTION"), `"`)
+	_, action, ok := strings.Cut(header, "#")
+	if !ok {
+		return "", nil, fmt.Errorf("invalid SOAPACTION %q", header)
+	}
+
+	args := make(map[string]string)
+	d := xml.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	inAction := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return action, args, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if !inAction {
+				inAction = t.Name.Local == action
+				continue
+			}
+			var value string
+			if err := d.DecodeElement(&value, &t); err != nil {
+				return "", nil, err
+			}
+			args[t.Name.Local] = value
+		case xml.EndElement:
+			if inAction && t.Name.Local == action {
+				return action, args, nil
+			}
+		}
+	}
+}
+
+// soapResponse writes the response to an action; pairs are argument names
+// followed by their values
+func soapResponse(w http.ResponseWriter, service, action string, pairs ...string) {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fmt.Fprintf(&b, "<%s>%s</%s>", pairs[i], xmlEscape(pairs[i+1]), pairs[i])
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, soapEnvelope, fmt.Sprintf(`<u:%sResponse xmlns:u="%s">%s</u:%sResponse>`, action, service, b.String(), action))
+}
+
+func soapFault(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, soapEnvelope, fmt.Sprintf(`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>`+
+		`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode>`+
+		`<errorDescription>%s</errorDescription></UPnPError></detail></s:Fault>`, code, description))
+}
+
+const soapEnvelope = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>%s</s:Body></s:Envelope>`
+
+const deviceDescription = `<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0" xmlns:dlna="urn:schemas-dlna-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:MediaServer:1</deviceType>
+    <dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
+    <friendlyName>%s</friendlyName>
+    <manufacturer>dead-dl</manufacturer>
+    <modelName>dead-dl</modelName>
+    <modelDescription>Live recordings downloaded by dead-dl</modelDescription>
+    <UDN>uuid:%s</UDN>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:ContentDirectory:1</serviceType>
+        <serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+        <SCPDURL>/ContentDirectory.xml</SCPDURL>
+        <controlURL>/ctl/ContentDirectory</controlURL>
+        <eventSubURL>/evt/ContentDirectory</eventSubURL>
+      </service>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:ConnectionManager:1</serviceType>
+        <serviceId>urn:upnp-org:serviceId:ConnectionManager</serviceId>
+        <SCPDURL>/ConnectionManager.xml</SCPDURL>
+        <controlURL>/ctl/ConnectionManager</controlURL>
+        <eventSubURL>/evt/ConnectionManager</eventSubURL>
+      </service>
+    </serviceList>
+  </device>
+</root>`
+
+const contentDirectorySCPD = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action><name>Browse</name><argumentList>
+      <argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+      <argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+      <argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+      <argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+      <argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+      <argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+      <argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetSystemUpdateID</name><argumentList>
+      <argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetSearchCapabilities</name><argumentList>
+      <argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetSortCapabilities</name><argumentList>
+      <argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+    </argumentList></action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>`
+
+const connectionManagerSCPD = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action><name>GetProtocolInfo</name><argumentList>
+      <argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+      <argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetCurrentConnectionIDs</name><argumentList>
+      <argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetCurrentConnectionInfo</name><argumentList>
+      <argument><name>ConnectionID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+      <argument><name>RcsID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_RcsID</relatedStateVariable></argument>
+      <argument><name>AVTransportID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_AVTransportID</relatedStateVariable></argument>
+      <argument><name>ProtocolInfo</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ProtocolInfo</relatedStateVariable></argument>
+      <argument><name>PeerConnectionManager</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionManager</relatedStateVariable></argument>
+      <argument><name>PeerConnectionID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+      <argument><name>Direction</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Direction</relatedStateVariable></argument>
+      <argument><name>Status</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionStatus</relatedStateVariable></argument>
+    </argumentList></action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionStatus</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>OK</allowedValue><allowedValue>ContentFormatMismatch</allowedValue><allowedValue>InsufficientBandwidth</allowedValue><allowedValue>UnreliableChannel</allowedValue><allowedValue>Unknown</allowedValue></allowedValueList></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionManager</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Direction</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>Input</allowedValue><allowedValue>Output</allowedValue></allowedValueList></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_AVTransportID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_RcsID</name><dataType>i4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>`
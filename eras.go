@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Era is a period of a band's history as relisten.org defines it
+type Era struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Order int    `json:"order"`
+}
+
+// fetchEras lists the eras of a band
+func fetchEras(band string) ([]Era, error) {
+	url := fmt.Sprintf("%s/artists/%s/eras", RelistenAPIBase, band)
+	resp, err := apiGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var eras []Era
+	if err := json.NewDecoder(resp.Body).Decode(&eras); err != nil {
+		return nil, err
+	}
+	return eras, nil
+}
+
+// findEra looks up an era of a band by name, ignoring case
+func findEra(band, name string) (*Era, error) {
+	eras, err := fetchEras(band)
+	if err != nil {
+		return nil, err
+	}
+	for i, era := range eras {
+		if strings.EqualFold(era.Name, name) {
+			return &eras[i], nil
+		}
+	}
+
+	var names []string
+	for _, era := range eras {
+		names = append(names, era.Name)
+	}
+	return nil, fmt.Errorf("no %s era named %q (eras: %s)", band, name, strings.Join(names, ", "))
+}
+
+// filterEra keeps the shows of an era
+func filterEra(shows []Show, eraID int) []Show {
+	var kept []Show
+	for _, show := range shows {
+		if show.EraID == eraID {
+			kept = append(kept, show)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress event types
+const (
+	EventShowStarted  = "show_started"
+	EventFileStarted  = "file_started"
+	EventFileProgress = "file_progress"
+	EventFileFinished = "file_finished"
+	EventFileFailed   = "file_failed"
+	EventShowFinished = "show_finished"
+)
+
+// eventProgressInterval is how often file_progress events are emitted for active transfers
+const eventProgressInterval = time.Second
+
+// eventWriteTimeout bounds how long a slow socket client may block the emitter
+const eventWriteTimeout = 2 * time.Second
+
+// ProgressEvent is a single machine-readable progress event, written as one JSON line
+type ProgressEvent struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Artist   string    `json:"artist,omitempty"`
+	Show     string    `json:"show,omitempty"`
+	File     string    `json:"file,omitempty"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	Total    int64     `json:"total,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Position int       `json:"position,omitempty"`
+	Shows    int       `json:"shows,omitempty"`
+}
+
+// EventEmitter fans progress events out to stdout and socket clients so GUIs
+// and wrappers can render their own progress
+type EventEmitter struct {
+	mu       sync.Mutex
+	stdout   io.Writer
+	listener net.Listener
+	socket   string
+	clients  map[net.Conn]struct{}
+}
+
+// events is the process-wide emitter; it has no sinks until setupEvents is called
+var events = &EventEmitter{}
+
+// setupEvents enables JSON events on stdout for -progress json and starts the
+// socket server for -progress-socket
+func setupEvents(cfg *Config) error {
+	if cfg.Progress == ProgressJSON {
+		events.stdout = os.Stdout
+	}
+	if cfg.ProgressSocket != "" {
+		return events.Listen(cfg.ProgressSocket)
+	}
+	return nil
+}
+
+// Listen accepts clients on a unix socket; every client receives all events
+// emitted after it connects and may send commands like pause
+func (e *EventEmitter) Listen(path string) error {
+	// A socket file left behind by a crashed run would make Listen fail
+	if _, err := os.Stat(path); err == nil {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.listener = listener
+	e.socket = path
+	e.clients = make(map[net.Conn]struct{})
+	e.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			e.mu.Lock()
+			e.clients[conn] = struct{}{}
+			e.mu.Unlock()
+			go readCommands(conn)
+		}
+	}()
+	return nil
+}
+
+// Enabled reports whether anything consumes events
+func (e *EventEmitter) Enabled() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stdout != nil || e.listener != nil
+}
+
+// Emit writes an event to every sink, dropping socket clients that can't keep up
+func (e *EventEmitter) Emit(ev ProgressEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.stdout != nil {
+		e.stdout.Write(data)
+	}
+	for conn := range e.clients {
+		conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		if _, err := conn.Write(data); err != nil {
+			conn.Close()
+			delete(e.clients, conn)
+		}
+	}
+}
+
+// Close disconnects all clients and removes the socket file
+func (e *EventEmitter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.listener == nil {
+		return nil
+	}
+	for conn := range e.clients {
+		conn.Close()
+	}
+	e.clients = nil
+	err := e.listener.Close()
+	e.listener = nil
+	os.Remove(e.socket)
+	return err
+}
+
+// eventProgress wraps another reporter and emits events for every file of a show
+type eventProgress struct {
+	inner ProgressReporter
+	show  string
+	mu    sync.Mutex
+	files map[*eventFile]struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newEventProgress(inner ProgressReporter, show string) *eventProgress {
+	p := &eventProgress{
+		inner: inner,
+		show:  show,
+		files: make(map[*eventFile]struct{}),
+		done:  make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+// loop periodically reports the bytes transferred by every active file
+func (p *eventProgress) loop() {
+	ticker := time.NewTicker(eventProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			active := make([]*eventFile, 0, len(p.files))
+			for f := range p.files {
+				active = append(active, f)
+			}
+			p.mu.Unlock()
+
+			for _, f := range active {
+				events.Emit(ProgressEvent{Type: EventFileProgress, Show: p.show, File: f.name,
+					Bytes: f.read.Load(), Total: f.total})
+			}
+		}
+	}
+}
+
+func (p *eventProgress) Start(name string, total int64) FileProgress {
+	f := &eventFile{parent: p, inner: p.inner.Start(name, total), name: name, total: total}
+	p.mu.Lock()
+	p.files[f] = struct{}{}
+	p.mu.Unlock()
+
+	events.Emit(ProgressEvent{Type: EventFileStarted, Show: p.show, File: name, Total: total})
+	return f
+}
+
+func (p *eventProgress) Wait() {
+	p.inner.Wait()
+	p.once.Do(func() { close(p.done) })
+}
+
+func (p *eventProgress) remove(f *eventFile) {
+	p.mu.Lock()
+	delete(p.files, f)
+	p.mu.Unlock()
+}
+
+type eventFile struct {
+	parent *eventProgress
+	inner  FileProgress
+	name   string
+	total  int64
+	read   atomic.Int64
+}
+
+func (f *eventFile) ProxyReader(r io.Reader) io.Reader {
+	return f.inner.ProxyReader(&countingReader{r: r, count: &f.read})
+}
+
+func (f *eventFile) Done() {
+	f.inner.Done()
+	f.parent.remove(f)
+	events.Emit(ProgressEvent{Type: EventFileFinished, Show: f.parent.show, File: f.name,
+		Bytes: f.read.Load(), Total: f.total})
+}
+
+func (f *eventFile) Abort() {
+	f.inner.Abort()
+	f.parent.remove(f)
+	events.Emit(ProgressEvent{Type: EventFileFailed, Show: f.parent.show, File: f.name,
+		Bytes: f.read.Load(), Total: f.total})
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// File classes selectable with -include
+const (
+	ClassAudio     = "audio"
+	ClassArt       = "art"
+	ClassText      = "text"
+	ClassChecksums = "checksums"
+)
+
+// DefaultAudioExts are the extensions that count as audio unless -audio-exts
+// says otherwise
+const DefaultAudioExts = ".flac,.mp3,.ogg,.oga,.opus,.shn,.wav,.m4a,.wv,.ape,.aiff,.aif"
+
+// audioExts is the set of audio extensions in effect, set once at startup
+var audioExts = parseAudioExts(DefaultAudioExts)
+
+// videoExts are the extensions of the video files -include-video mirrors
+var videoExts = []string{".mp4", ".m4v", ".mpeg", ".mpg", ".avi", ".mkv", ".mov", ".ogv", ".webm", ".vob", ".ts"}
+
+// DefaultVideoFormats are the video extensions -include-video downloads
+// unless -video-format says otherwise; archive.org derives an MP4 from most
+// uploads, which plays nearly everywhere
+const DefaultVideoFormats = "mp4"
+
+// classExtensions maps the non-audio classes to the extensions they cover;
+// audio is decided by isAudioFile
+var classExtensions = map[string][]string{
+	ClassArt:       {".jpg", ".jpeg", ".png", ".gif", ".tif", ".tiff", ".bmp"},
+	ClassText:      {".txt", ".nfo", ".md", ".log", ".cue", ".pdf"},
+	ClassChecksums: {".md5", ".ffp", ".st5", ".sfv", ".sha1", ".sha256"},
+}
+
+// parseIncludes parses the -include list into a set of file classes
+func parseIncludes(include string) (map[string]bool, error) {
+	classes := make(map[string]bool)
+	for _, class := range strings.Split(include, ",") {
+		class = strings.ToLower(strings.TrimSpace(class))
+		if class == "" {
+			continue
+		}
+		if class != ClassAudio && classExtensions[class] == nil {
+			return nil, fmt.Errorf("unknown file class %q in -include (use %s)", class, strings.Join(knownClasses(), ", "))
+		}
+		classes[class] = true
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("-include must name at least one file class")
+	}
+	return classes, nil
+}
+
+// parseAudioExts parses an -audio-exts list into a set of lower-case
+// extensions with their leading dot
+func parseAudioExts(list string) map[string]bool {
+	exts := make(map[string]bool)
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts[ext] = true
+	}
+	return exts
+}
+
+// setAudioExts applies -audio-exts; an empty list, as in older state files,
+// keeps the defaults
+func setAudioExts(list string) error {
+	if list == "" {
+		list = DefaultAudioExts
+	}
+	exts := parseAudioExts(list)
+	if len(exts) == 0 {
+		return fmt.Errorf("-audio-exts must name at least one extension")
+	}
+	audioExts = exts
+	return nil
+}
+
+// isAudioFile reports whether a file name has one of the audio extensions
+func isAudioFile(filename string) bool {
+	return audioExts[strings.ToLower(filepath.Ext(filename))]
+}
+
+// isVideoFile reports whether a file name has a video extension
+func isVideoFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, videoExt := range videoExts {
+		if ext == videoExt {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVideoFormats parses -video-format into a set of extensions; "any"
+// allows every video extension
+func parseVideoFormats(list string) (map[string]bool, error) {
+	formats := make(map[string]bool)
+	for _, format := range strings.Split(list, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" {
+			continue
+		}
+		if format == "any" {
+			for _, ext := range videoExts {
+				formats[ext] = true
+			}
+			continue
+		}
+		ext := "." + strings.TrimPrefix(format, ".")
+		if !isVideoFile("video" + ext) {
+			return nil, fmt.Errorf("unknown video format %q in -video-format", format)
+		}
+		formats[ext] = true
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("-video-format must name at least one format")
+	}
+	return formats, nil
+}
+
+// selectVideoFiles picks the video files in the formats -video-format allows
+func selectVideoFiles(files []ArchiveFile, cfg *Config) []ArchiveFile {
+	formats, err := parseVideoFormats(cfg.VideoFormats)
+	if err != nil {
+		// Validated at startup
+		return nil
+	}
+	var selected []ArchiveFile
+	for _, file := range files {
+		if formats[strings.ToLower(filepath.Ext(file.Name))] {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
+
+// knownClasses lists every valid -include class
+func knownClasses() []string {
+	classes := []string{ClassAudio}
+	for class := range classExtensions {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes[1:])
+	return classes
+}
+
+// classifyFile returns the class of an archive file, or "" if it belongs to none
+func classifyFile(file ArchiveFile) string {
+	if isAudioFile(file.Name) {
+		return ClassAudio
+	}
+
+	ext := strings.ToLower(filepath.Ext(file.Name))
+	for class, exts := range classExtensions {
+		for _, classExt := range exts {
+			if ext == classExt {
+				return class
+			}
+		}
+	}
+	return ""
+}
+
+// isFlacFile reports whether an audio file is FLAC, by extension or format field
+func isFlacFile(file ArchiveFile) bool {
+	return strings.HasSuffix(strings.ToLower(file.Name), ".flac") ||
+		strings.Contains(strings.ToLower(file.Format), "flac")
+}
+
+// isMp3File reports whether an audio file is MP3, by extension or format field
+func isMp3File(file ArchiveFile) bool {
+	fileFormat := strings.ToLower(file.Format)
+	return strings.HasSuffix(strings.ToLower(file.Name), ".mp3") ||
+		strings.Contains(fileFormat, "mp3") ||
+		fileFormat == "vbr mp3"
+}
+
+// selectFiles picks the files of an archive item to download: audio files in
+// the requested format plus every other requested file class
+func selectFiles(files []ArchiveFile, cfg *Config) []ArchiveFile {
+	includes, err := parseIncludes(cfg.Include)
+	if err != nil {
+		// Validated at startup; older state files have no include list
+		includes = map[string]bool{ClassAudio: true}
+	}
+
+	if cfg.OriginalsOnly {
+		files = originalFiles(files)
+	}
+
+	var selected []ArchiveFile
+	if includes[ClassAudio] {
+		selected = selectAudioFiles(files, cfg.Format)
+	}
+
+	if cfg.IncludeVideo {
+		selected = append(selected, selectVideoFiles(files, cfg)...)
+	}
+
+	for _, file := range files {
+		if class := classifyFile(file); class != ClassAudio && includes[class] {
+			selected = append(selected, file)
+		} else if cfg.EtreeExtras && isEtreeExtra(file) {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
+
+// etreeExtraExts are the info and checksum files of an etree fileset
+var etreeExtraExts = []string{".txt", ".md5", ".ffp", ".st5"}
+
+// isEtreeExtra reports whether a file is part of the etree fileset that
+// -etree-extras mirrors verbatim
+func isEtreeExtra(file ArchiveFile) bool {
+	ext := strings.ToLower(filepath.Ext(file.Name))
+	for _, extraExt := range etreeExtraExts {
+		if ext == extraExt {
+			return true
+		}
+	}
+	return false
+}
+
+// originalFiles drops the derivatives archive.org generated from uploaded files
+func originalFiles(files []ArchiveFile) []ArchiveFile {
+	var originals []ArchiveFile
+	for _, file := range files {
+		if file.Source != "derivative" {
+			originals = append(originals, file)
+		}
+	}
+	return originals
+}
+
+// selectAudioFiles filters audio files by format, falling back to MP3 when
+// FLAC was requested but the item has none, and to its other audio files
+// when it has neither
+func selectAudioFiles(files []ArchiveFile, format string) []ArchiveFile {
+	var filesToDownload []ArchiveFile
+	wantFlac := format == "flac" || format == "both"
+	wantMp3 := format == "mp3" || format == "both"
+
+	for _, file := range files {
+		// Skip non-audio files
+		if !isAudioFile(file.Name) {
+			continue
+		}
+
+		if (wantFlac && isFlacFile(file)) || (wantMp3 && isMp3File(file)) {
+			filesToDownload = append(filesToDownload, file)
+		}
+	}
+
+	// If no files found and format was flac, try mp3 as fallback
+	if len(filesToDownload) == 0 && format == "flac" {
+		logger.Println("    - No FLAC files found, falling back to MP3...")
+
+		for _, file := range files {
+			if isAudioFile(file.Name) && isMp3File(file) {
+				filesToDownload = append(filesToDownload, file)
+			}
+		}
+	}
+
+	// Items with neither, e.g. only WavPack or APE uploads, aren't skipped.
+	// An item with FLAC but no MP3 still gets nothing with -format mp3.
+	if len(filesToDownload) == 0 && !hasFlacOrMp3(files) {
+		for _, file := range files {
+			if isAudioFile(file.Name) {
+				filesToDownload = append(filesToDownload, file)
+			}
+		}
+		if len(filesToDownload) > 0 {
+			logger.Printf("    - No FLAC or MP3 files found, downloading the %s files instead...\n", audioFormats(filesToDownload))
+		}
+	}
+
+	return filesToDownload
+}
+
+// hasFlacOrMp3 reports whether any of the audio files is FLAC or MP3
+func hasFlacOrMp3(files []ArchiveFile) bool {
+	for _, file := range files {
+		if isAudioFile(file.Name) && (isFlacFile(file) || isMp3File(file)) {
+			return true
+		}
+	}
+	return false
+}
+
+// audioFormats lists the extensions of files, e.g. "wv, ape"
+func audioFormats(files []ArchiveFile) string {
+	seen := make(map[string]bool)
+	var exts []string
+	for _, file := range files {
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Name)), ".")
+		if !seen[ext] {
+			seen[ext] = true
+			exts = append(exts, ext)
+		}
+	}
+	return strings.Join(exts, ", ")
+}
+
+// dropOversized removes files whose metadata size exceeds maxSize, logging and
+// recording each one. Files of unknown size, and files the limit doesn't
+// apply to, are kept. flagName names the limit in the log.
+func dropOversized(files []ArchiveFile, showDir string, maxSize ByteSize, flagName string, applies func(ArchiveFile) bool, summary *RunSummary) []ArchiveFile {
+	var kept []ArchiveFile
+	for _, file := range files {
+		size, err := parseFileSize(file.Size)
+		if err == nil && ByteSize(size) > maxSize && applies(file) {
+			logger.Printf("    - Skipping %s (%s is larger than %s %s)\n", file.Name, ByteSize(size), flagName, maxSize)
+			summary.AddSkippedFile(filepath.Join(showDir, file.Name), fmt.Sprintf("%s > %s", ByteSize(size), maxSize))
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
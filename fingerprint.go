@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// fpcalcResult is the output of `fpcalc -json`
+type fpcalcResult struct {
+	Duration    float64 `json:"duration"`
+	Fingerprint string  `json:"fingerprint"`
+}
+
+// checkFingerprinting makes sure fpcalc, Chromaprint's command line tool, is
+// installed when -fingerprint is set
+func checkFingerprinting(cfg *Config) error {
+	if !cfg.Fingerprint {
+		return nil
+	}
+	if _, err := exec.LookPath("fpcalc"); err != nil {
+		return fmt.Errorf("-fingerprint needs fpcalc from Chromaprint (https://acoustid.org/chromaprint): %w", err)
+	}
+	return nil
+}
+
+// fingerprintFile computes the AcoustID fingerprint of an audio file
+func fingerprintFile(path string) (*fpcalcResult, error) {
+	out, err := exec.Command("fpcalc", "-json", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("fpcalc: %w", err)
+	}
+	var result fpcalcResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("fpcalc output: %w", err)
+	}
+	return &result, nil
+}
+
+// fingerprintEntry adds the fingerprint of an audio file to its manifest
+// entry with -fingerprint, unless the entry has one already
+func fingerprintEntry(cfg *Config, m *Manifest, file ArchiveFile, filePath, fileName string) {
+	if !cfg.Fingerprint || classifyFile(file) != ClassAudio {
+		return
+	}
+	entry, ok := m.Lookup(fileName)
+	if !ok || entry.Fingerprint != "" {
+		return
+	}
+
+	result, err := fingerprintFile(filePath)
+	if err != nil {
+		logger.Warn("Failed to fingerprint %s: %v", fileName, err)
+		return
+	}
+	entry.Fingerprint = result.Fingerprint
+	entry.Duration = result.Duration
+	m.Record(entry)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Fixture is a recorded API response, stored without headers or cookies so
+// it can be committed as test data
+type Fixture struct {
+	URL         string          `json:"url"`
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	Text        string          `json:"text,omitempty"` // Bodies that aren't JSON
+	Data        []byte          `json:"data,omitempty"` // Binary bodies, base64-encoded
+}
+
+// maxFixtureFileSize is the largest file download recorded as a fixture;
+// larger files are downloaded without being recorded
+const maxFixtureFileSize = 1 << 20
+
+// sensitiveParams are query parameters dropped from recorded URLs
+var sensitiveParams = []string{"token", "key", "secret", "password", "auth", "signature"}
+
+// fixtureTransport records API responses and small file downloads into a
+// directory, or replays them from it, so runs can be repeated without the
+// network in integration tests
+type fixtureTransport struct {
+	dir    string
+	replay bool
+	next   http.RoundTripper
+}
+
+// networkTransport is the transport the fixture transports stand in for
+var networkTransport = httpTransport
+
+// setupFixtures installs the record or replay transport for -record-fixtures
+// and -replay-fixtures under the HTTP clients, or the network transport
+// without them. Replayed responses are never retried, since they can't
+// change.
+func setupFixtures(cfg *Config) error {
+	httpTransport = networkTransport
+	switch {
+	case cfg.RecordFixtures != "" && cfg.ReplayFixtures != "":
+		return fmt.Errorf("-record-fixtures and -replay-fixtures can't be used together")
+	case cfg.RecordFixtures != "":
+		httpTransport = &fixtureTransport{dir: cfg.RecordFixtures, next: networkTransport}
+	case cfg.ReplayFixtures != "":
+		httpTransport = &fixtureTransport{dir: cfg.ReplayFixtures, replay: true}
+		httpRetries = 0
+	}
+	return nil
+}
+
+// isAPIURL reports whether a URL is a metadata or search request rather than
+// a file download
+func isAPIURL(u *url.URL) bool {
+	return strings.HasPrefix(u.String(), RelistenAPIBase) || strings.HasPrefix(u.Path, "/metadata/") ||
+		u.Path == "/advancedsearch.php"
+}
+
+// sanitizeURL drops credentials and sensitive query parameters from a URL
+func sanitizeURL(u *url.URL) string {
+	clean := *u
+	clean.User = nil
+	query := clean.Query()
+	for name := range query {
+		for _, sensitive := range sensitiveParams {
+			if strings.Contains(strings.ToLower(name), sensitive) {
+				query.Del(name)
+			}
+		}
+	}
+	clean.RawQuery = query.Encode()
+	return clean.String()
+}
+
+// fixturePath maps a URL to a readable file path, e.g.
+// api.relisten.net/api/v2/artists/phish/years/1997.json
+func (t *fixtureTransport) fixturePath(u *url.URL) string {
+	name := u.Host + u.Path
+	if u.RawQuery != "" {
+		name += "_" + u.RawQuery
+	}
+	name = strings.NewReplacer("?", "_", "&", "_", "=", "-", ":", "_").Replace(strings.TrimSuffix(name, "/"))
+	return filepath.Join(t.dir, filepath.FromSlash(name)+".json")
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	clean, err := url.Parse(sanitizeURL(req.URL))
+	if err != nil {
+		return nil, err
+	}
+	path := t.fixturePath(clean)
+
+	if t.replay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("no fixture for %s: %w", clean, err)
+		}
+		var fixture Fixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+		}
+		body := []byte(fixture.Body)
+		if fixture.Text != "" {
+			body = []byte(fixture.Text)
+		} else if fixture.Data != nil {
+			body = fixture.Data
+		}
+		return &http.Response{
+			StatusCode:    fixture.Status,
+			Header:        http.Header{"Content-Type": {fixture.ContentType}},
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet {
+		// A HEAD request would record an empty body over the file's fixture
+		return resp, err
+	}
+	apiURL := isAPIURL(req.URL)
+	if !apiURL && (resp.StatusCode != http.StatusOK || resp.ContentLength < 0 || resp.ContentLength > maxFixtureFileSize) {
+		logger.Debug("Not recording a fixture for %s, which is too large or of unknown size", clean)
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	fixture := Fixture{URL: clean.String(), Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")}
+	var indented bytes.Buffer
+	switch {
+	case apiURL && json.Indent(&indented, data, "", "  ") == nil:
+		fixture.Body = indented.Bytes()
+	case utf8.Valid(data):
+		fixture.Text = string(data)
+	default:
+		fixture.Data = data
+	}
+	if err := writeFixture(path, fixture); err != nil {
+		logger.Warn("Failed to record fixture for %s: %v", clean, err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// writeFixture stores a fixture as indented JSON, so recordings diff well
+func writeFixture(path string, fixture Fixture) error {
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
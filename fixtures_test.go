@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReplayDownload plans and downloads a recorded show from
+// testdata/fixtures without the network
+func TestReplayDownload(t *testing.T) {
+	fixtures, err := filepath.Abs(filepath.Join("testdata", "fixtures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir()) // The run writes its log to ./logs
+	t.Cleanup(func() { httpTransport = networkTransport })
+
+	cfg := &Config{}
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	registerFlags(fs, cfg)
+	err = fs.Parse([]string{
+		"-band", "phish", "-year", "1997", "-highest-rated", "-yes",
+		"-output", "out", "-progress", "none", "-max-retries-per-file", "0",
+		"-replay-fixtures", fixtures,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	initLogger(io.Discard)
+	defer logger.Close()
+	validateConfig(cfg)
+
+	if err := startRun(cfg); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	manifest, err := loadManifest(filepath.Join("out", "phish", "1997", "1997-11-22"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Identifier != "ph1997-11-22.sbd.test" {
+		t.Errorf("manifest identifier = %q, want ph1997-11-22.sbd.test", manifest.Identifier)
+	}
+	// md5s of the recorded file bodies, as archive.org lists them
+	want := map[string]string{
+		"1 Mike's Song.mp3":  "2257bfd740f2e7d215364d3892040588",
+		"2 Bold As Love.mp3": "1ff7607e2a995e362631534572fa010d",
+	}
+	for name, md5 := range want {
+		entry, ok := manifest.Lookup(name)
+		if !ok {
+			t.Errorf("%s was not downloaded", name)
+		} else if entry.MD5 != md5 {
+			t.Errorf("%s has md5 %s, want %s", name, entry.MD5, md5)
+		}
+	}
+	if len(manifest.Files) != len(want) {
+		t.Errorf("manifest lists %d file(s), want %d", len(manifest.Files), len(want))
+	}
+
+	// Only the track of the encore set is grouped as an encore
+	for name, encore := range map[string]bool{"1 Mike's Song.mp3": false, "2 Bold As Love.mp3": true} {
+		f, err := os.Open(filepath.Join("out", "phish", "1997", "1997-11-22", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, frames, err := readID3(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := len(frames["TIT1"]) > 0 && len(frames["COMM"]) > 0; got != encore {
+			t.Errorf("%s has encore grouping and comment: %v, want %v", name, got, encore)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Cover art dimensions
+	_ "image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FLAC metadata block types
+const (
+	flacStreamInfo    = 0
+	flacPadding       = 1
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// flacBlock is a metadata block of a FLAC file
+type flacBlock struct {
+	Type byte
+	Data []byte
+}
+
+// vorbisComments maps the fields of a TrackTags to Vorbis comments
+var vorbisComments = []struct {
+	key   string
+	value func(t *TrackTags) string
+}{
+	{"TITLE", func(t *TrackTags) string { return t.Title }},
+	{"ARTIST", func(t *TrackTags) string { return t.Artist }},
+	{"ALBUMARTIST", func(t *TrackTags) string { return t.Artist }},
+	{"ALBUM", func(t *TrackTags) string { return t.Album }},
+	{"DATE", func(t *TrackTags) string { return t.Date }},
+	{"TRACKNUMBER", func(t *TrackTags) string { return positive(t.Track) }},
+	{"TRACKTOTAL", func(t *TrackTags) string { return positive(t.Tracks) }},
+	{"DISCNUMBER", func(t *TrackTags) string { return positive(t.Disc) }},
+	{"DISCTOTAL", func(t *TrackTags) string { return positive(t.Discs) }},
+	{"DISCSUBTITLE", func(t *TrackTags) string { return t.SetName }},
+	{"GROUPING", func(t *TrackTags) string { return t.grouping() }},
+	{"COMMENT", func(t *TrackTags) string { return t.grouping() }},
+}
+
+// positive formats n, or returns "" when it is unknown
+func positive(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// readFlacBlocks reads the metadata blocks of a FLAC file and returns them
+// with the offset the audio frames start at. An ID3v2 tag some tools put in
+// front of the fLaC marker is skipped.
+func readFlacBlocks(f io.ReaderAt) ([]flacBlock, int64, error) {
+	offset, _, err := readID3(f)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	marker := make([]byte, 4)
+	if _, err := f.ReadAt(marker, offset); err != nil || string(marker) != "fLaC" {
+		return nil, 0, fmt.Errorf("not a FLAC file")
+	}
+	offset += 4
+
+	var blocks []flacBlock
+	for {
+		header := make([]byte, 4)
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return nil, 0, fmt.Errorf("truncated metadata: %w", err)
+		}
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		data := make([]byte, length)
+		if _, err := f.ReadAt(data, offset+4); err != nil {
+			return nil, 0, fmt.Errorf("truncated metadata: %w", err)
+		}
+		offset += 4 + int64(length)
+
+		blocks = append(blocks, flacBlock{Type: header[0] & 0x7f, Data: data})
+		if header[0]&0x80 != 0 {
+			break
+		}
+	}
+	if len(blocks) == 0 || blocks[0].Type != flacStreamInfo {
+		return nil, 0, fmt.Errorf("missing STREAMINFO block")
+	}
+	return blocks, offset, nil
+}
+
+// vorbisComment builds a Vorbis comment block of the given tags, keeping the
+// vendor string and the comments of an existing block that the tags don't set
+func vorbisComment(old []byte, tags *TrackTags) []byte {
+	vendor := "dead-dl"
+	var kept []string
+	if len(old) >= 4 {
+		r := bytes.NewReader(old)
+		if s, ok := readVorbisString(r); ok {
+			vendor = s
+			var count uint32
+			if binary.Read(r, binary.LittleEndian, &count) == nil {
+				for ; count > 0; count-- {
+					comment, ok := readVorbisString(r)
+					if !ok {
+						break
+					}
+					kept = append(kept, comment)
+				}
+			}
+		}
+	}
+
+	var comments []string
+	set := make(map[string]bool)
+	for _, c := range vorbisComments {
+		if value := c.value(tags); value != "" {
+			comments = append(comments, c.key+"="+value)
+			set[c.key] = true
+		}
+	}
+	for _, extra := range tags.extras() {
+		comments = append(comments, extra[0]+"="+extra[1])
+		set[strings.ToUpper(extra[0])] = true
+	}
+	for _, comment := range kept {
+		key, _, _ := strings.Cut(comment, "=")
+		if !set[strings.ToUpper(key)] {
+			comments = append(comments, comment)
+		}
+	}
+
+	var b bytes.Buffer
+	writeVorbisString(&b, vendor)
+	binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+	for _, comment := range comments {
+		writeVorbisString(&b, comment)
+	}
+	return b.Bytes()
+}
+
+// readVorbisString reads a length-prefixed string of a Vorbis comment
+func readVorbisString(r *bytes.Reader) (string, bool) {
+	var length uint32
+	if binary.Read(r, binary.LittleEndian, &length) != nil || int64(length) > int64(r.Len()) {
+		return "", false
+	}
+	s := make([]byte, length)
+	r.Read(s)
+	return string(s), true
+}
+
+// writeVorbisString writes a length-prefixed string of a Vorbis comment
+func writeVorbisString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.LittleEndian, uint32(len(s)))
+	b.WriteString(s)
+}
+
+// flacPictureBlock builds a PICTURE block holding a front cover
+func flacPictureBlock(cover *CoverArt) []byte {
+	var width, height, depth uint32
+	if config, format, err := image.DecodeConfig(bytes.NewReader(cover.Data)); err == nil {
+		width, height = uint32(config.Width), uint32(config.Height)
+		depth = 24
+		if format == "png" {
+			depth = 32
+		}
+	}
+
+	var b bytes.Buffer
+	for _, v := range []uint32{3, uint32(len(cover.MIME))} { // Front cover
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	b.WriteString(cover.MIME)
+	for _, v := range []uint32{0, width, height, depth, 0, uint32(len(cover.Data))} { // No description or palette
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	b.Write(cover.Data)
+	return b.Bytes()
+}
+
+// isFrontCover reports whether a PICTURE block holds a front cover
+func isFrontCover(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data) == 3
+}
+
+// writeFlacTags merges the given tags into the Vorbis comment of a FLAC file
+// and replaces its front cover when there is a new one. Padding is dropped,
+// since the file is rewritten anyway; other blocks are kept in order.
+func writeFlacTags(path string, tags *TrackTags) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	blocks, audioOffset, err := readFlacBlocks(f)
+	if err != nil {
+		return err
+	}
+
+	var oldComment []byte
+	var kept []flacBlock
+	for _, block := range blocks {
+		switch {
+		case block.Type == flacVorbisComment:
+			oldComment = block.Data
+		case block.Type == flacPadding:
+		case block.Type == flacPicture && tags.Cover != nil && isFrontCover(block.Data):
+		default:
+			kept = append(kept, block)
+		}
+	}
+	// STREAMINFO must stay first
+	blocks = append([]flacBlock{kept[0], {Type: flacVorbisComment, Data: vorbisComment(oldComment, tags)}}, kept[1:]...)
+	if tags.Cover != nil {
+		blocks = append(blocks, flacBlock{Type: flacPicture, Data: flacPictureBlock(tags.Cover)})
+	}
+
+	var header bytes.Buffer
+	header.WriteString("fLaC")
+	for i, block := range blocks {
+		if len(block.Data) >= 1<<24 {
+			return fmt.Errorf("metadata block of %d bytes is too large", len(block.Data))
+		}
+		typ := block.Type
+		if i == len(blocks)-1 {
+			typ |= 0x80
+		}
+		header.Write([]byte{typ, byte(len(block.Data) >> 16), byte(len(block.Data) >> 8), byte(len(block.Data))})
+		header.Write(block.Data)
+	}
+
+	return rewriteFile(path, func(w io.Writer) error {
+		if _, err := w.Write(header.Bytes()); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, io.NewSectionReader(f, audioOffset, 1<<62))
+		return err
+	})
+}
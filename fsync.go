@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"runtime"
+)
+
+// syncDir flushes a directory entry to disk so that files created or renamed
+// in it survive a power loss. Windows can't sync directories, where it is a no-op.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
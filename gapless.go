@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// mp3DecoderDelay is the delay MP3 decoders add in front of the audio, which
+// iTunSMPB counts as part of the encoder delay
+const mp3DecoderDelay = 529
+
+// mp3Gapless is what players need to trim an MP3 to its audio: the samples
+// the encoder added before and after it, and the samples of every frame
+type mp3Gapless struct {
+	delay, padding int
+	samples        int64
+}
+
+// readMP3Gapless reads the encoder delay and padding from the LAME tag in the
+// Xing/Info header of the first frame, which LAME and ffmpeg's -write_xing
+// write, along with the number of frames
+func readMP3Gapless(f io.ReaderAt) (mp3Gapless, error) {
+	offset, _, err := readID3(f)
+	if err != nil {
+		return mp3Gapless{}, err
+	}
+	header := make([]byte, 4)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return mp3Gapless{}, err
+	}
+	if header[0] != 0xff || header[1]&0xe0 != 0xe0 {
+		return mp3Gapless{}, fmt.Errorf("no MP3 frame after the ID3 tag")
+	}
+
+	// The Xing header follows the side information, whose size depends on
+	// the MPEG version and whether the frame is mono
+	mpeg1, mono := header[1]>>3&3 == 3, header[3]>>6 == 3
+	sideInfo, frameSamples := 17, int64(576)
+	switch {
+	case mpeg1 && !mono:
+		sideInfo, frameSamples = 32, 1152
+	case mpeg1:
+		frameSamples = 1152
+	case mono:
+		sideInfo = 9
+	}
+
+	xing := make([]byte, 160)
+	if _, err := f.ReadAt(xing, offset+4+int64(sideInfo)); err != nil && err != io.EOF {
+		return mp3Gapless{}, err
+	}
+	if id := string(xing[:4]); id != "Xing" && id != "Info" {
+		return mp3Gapless{}, fmt.Errorf("no Xing/Info header")
+	}
+	flags := binary.BigEndian.Uint32(xing[4:8])
+	if flags&1 == 0 {
+		return mp3Gapless{}, fmt.Errorf("Xing header has no frame count")
+	}
+	frames := binary.BigEndian.Uint32(xing[8:12])
+	pos := 12
+	for _, field := range []struct {
+		flag uint32
+		size int
+	}{{2, 4}, {4, 100}, {8, 4}} { // Byte count, seek table, quality
+		if flags&field.flag != 0 {
+			pos += field.size
+		}
+	}
+
+	// The LAME tag starts with a 9-byte encoder name; delay and padding are
+	// 12 bits each, 21 bytes in
+	lame := xing[pos:]
+	if len(lame) < 24 {
+		return mp3Gapless{}, fmt.Errorf("no LAME tag")
+	}
+	delay := int(lame[21])<<4 | int(lame[22])>>4
+	padding := int(lame[22]&0x0f)<<8 | int(lame[23])
+	return mp3Gapless{delay: delay, padding: padding, samples: int64(frames) * frameSamples}, nil
+}
+
+// iTunSMPB formats the gapless information the way iTunes reads it from a
+// COMM frame: the delay and padding with the decoder's delay moved from
+// the padding to the delay, and the length of the audio, in hex
+func (g mp3Gapless) iTunSMPB() string {
+	delay, padding := g.delay+mp3DecoderDelay, max(g.padding-mp3DecoderDelay, 0)
+	length := max(g.samples-int64(g.delay)-int64(g.padding), 0)
+	return fmt.Sprintf(" 00000000 %08X %08X %016X 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000",
+		delay, padding, length)
+}
+
+// comment returns the content of the iTunSMPB COMM frame
+func (g mp3Gapless) comment() []byte {
+	// Latin-1, English, described as iTunSMPB
+	content := append([]byte{0, 'e', 'n', 'g'}, "iTunSMPB\x00"...)
+	return append(content, g.iTunSMPB()...)
+}
+
+// writeGapless adds an iTunSMPB comment with the encoder delay and padding of
+// an MP3, so iTunes and Apple devices play it gaplessly like LAME-aware players
+func writeGapless(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	gapless, err := readMP3Gapless(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return addID3Frame(path, "COMM", gapless.comment())
+}
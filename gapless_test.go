@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// wantSMPB is the iTunSMPB comment of testdata/gapless.mp3, the Info frame
+// and first audio frame of an MP3 that ffmpeg encoded with a LAME tag. Its
+// 757 frames of 1152 samples, 576 samples of encoder delay, and 1297 of
+// padding give 576+529 samples of delay, 1297-529 of padding, and
+// 757*1152-576-1297 of audio.
+const wantSMPB = " 00000000 00000451 00000300 00000000000D472F 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000"
+
+func TestReadMP3Gapless(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "gapless.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, err := readMP3Gapless(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.delay != 576 || g.padding != 1297 || g.samples != 757*1152 {
+		t.Errorf("delay, padding, samples = %d, %d, %d, want 576, 1297, %d", g.delay, g.padding, g.samples, 757*1152)
+	}
+	if got := g.iTunSMPB(); got != wantSMPB {
+		t.Errorf("iTunSMPB = %q, want %q", got, wantSMPB)
+	}
+}
+
+// TestWriteID3Gapless tags the fixture twice and checks that it carries one
+// iTunSMPB comment and the audio is untouched
+func TestWriteID3Gapless(t *testing.T) {
+	audio, err := os.ReadFile(filepath.Join("testdata", "gapless.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "track.mp3")
+	if err := os.WriteFile(path, audio, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := writeID3(path, &TrackTags{Disc: 2, Discs: 3, SetName: "Set 2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	length, frames, err := readID3(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comms := frames["COMM"]; len(comms) != 1 {
+		t.Errorf("%d COMM frames, want 1", len(comms))
+	} else if want := "\x00engiTunSMPB\x00" + wantSMPB; string(comms[0]) != want {
+		t.Errorf("COMM = %q, want %q", comms[0], want)
+	}
+	if tpos := frames["TPOS"]; len(tpos) != 1 || string(tpos[0]) != "\x032/3" {
+		t.Errorf("TPOS = %q, want 2/3", tpos)
+	}
+
+	tagged, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tagged[length:], audio) {
+		t.Error("audio changed by tagging")
+	}
+	if g, err := readMP3Gapless(f); err != nil || g.iTunSMPB() != wantSMPB {
+		t.Errorf("gapless info after tagging = %v, %v", g, err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// gcCandidate is a cataloged source that gc would remove
+type gcCandidate struct {
+	Source *CatalogSource
+	Reason string
+}
+
+// gcOptions select which sources gc removes besides superseded ones
+type gcOptions struct {
+	Band      string
+	Year      string
+	Format    string
+	MinRating float64
+	KeepBest  bool
+}
+
+// runGC handles `dead-dl gc`, which removes superseded, duplicate, and
+// filtered-out sources from disk and from the catalog
+func runGC(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	outputDir := fs.String("output", "./downloads", "Output directory to clean up")
+	catalogFlag := fs.String("catalog", "", "Catalog file or postgres:// URL to use instead of the catalog under -output")
+	trash := fs.String("trash", "", "Move removed sources into this directory instead of deleting them")
+	dryRun := fs.Bool("dry-run", false, "Only list what would be removed and how much space it would reclaim")
+	yes := fs.Bool("yes", false, "Don't ask for confirmation")
+	staleLock := fs.Duration("stale-lock", DefaultStaleLock, "Age after which another instance's show lock is considered stale")
+	var opts gcOptions
+	fs.StringVar(&opts.Band, "band", "", "Only clean up this band")
+	fs.StringVar(&opts.Year, "year", "", "Only clean up this year, range, or list of years")
+	fs.StringVar(&opts.Format, "format", "", "Also remove sources not in this format (flac or mp3)")
+	fs.Float64Var(&opts.MinRating, "min-rating", 0, "Also remove rated sources below this average rating")
+	fs.BoolVar(&opts.KeepBest, "keep-best", false, "Also remove duplicates, keeping only the best source of each show (soundboards first, then rating)")
+	fs.Parse(args)
+
+	initLogger(os.Stderr)
+	defer logger.Close()
+
+	store, err := openCatalog(&Config{OutputDir: *outputDir, Catalog: *catalogFlag})
+	if err != nil {
+		logger.Fatal("Failed to open catalog: %v", err)
+	}
+	defer store.Close()
+
+	catalog, err := store.Load()
+	if err != nil {
+		logger.Fatal("Failed to load catalog: %v", err)
+	}
+
+	candidates := findGarbage(catalog, opts)
+	if len(candidates) == 0 {
+		logger.Info("Nothing to clean up")
+		return
+	}
+
+	var total int64
+	logger.Printf("%d source(s) to remove:\n", len(candidates))
+	for _, c := range candidates {
+		logger.Printf("  %-50s %10s  %s\n", c.Source.ShowDir, ByteSize(c.Source.Bytes), c.Reason)
+		total += c.Source.Bytes
+	}
+	logger.Printf("Reclaimable: %s\n", ByteSize(total))
+
+	if *dryRun {
+		return
+	}
+	action := "Delete"
+	if *trash != "" {
+		action = "Move to " + *trash
+	}
+	if !*yes && !confirm(fmt.Sprintf("%s %d source(s)?", action, len(candidates))) {
+		logger.Info("gc cancelled")
+		return
+	}
+
+	removed := 0
+	for _, c := range candidates {
+		if err := removeSourceDir(*outputDir, c.Source.ShowDir, *trash, *staleLock); err != nil {
+			logger.Error("Failed to remove %s: %v", c.Source.ShowDir, err)
+			continue
+		}
+		if err := store.RemoveSource(c.Source.Identifier); err != nil {
+			logger.Warn("Failed to update catalog: %v", err)
+		}
+		removed++
+	}
+	logger.Info("Removed %d of %d source(s)", removed, len(candidates))
+}
+
+// findGarbage lists the sources to remove: those superseded by an upgrade
+// that is in the catalog, and those the options filter out
+func findGarbage(catalog *Catalog, opts gcOptions) []gcCandidate {
+	var candidates []gcCandidate
+	shows := make(map[string][]*CatalogSource)
+
+	for _, src := range catalog.Sources {
+		if opts.Band != "" && src.Band != opts.Band {
+			continue
+		}
+		if !matchesYears(opts.Year, src.Year) {
+			continue
+		}
+
+		switch {
+		case src.SupersededBy != "" && catalog.Sources[src.SupersededBy] != nil:
+			candidates = append(candidates, gcCandidate{src, "superseded by " + src.SupersededBy})
+		case opts.Format != "" && src.Format != opts.Format:
+			candidates = append(candidates, gcCandidate{src, "format " + src.Format})
+		case opts.MinRating > 0 && src.Rating > 0 && src.Rating < opts.MinRating:
+			candidates = append(candidates, gcCandidate{src, fmt.Sprintf("rated %.2f", src.Rating)})
+		default:
+			key := src.Band + "/" + src.Date
+			shows[key] = append(shows[key], src)
+		}
+	}
+
+	if opts.KeepBest {
+		for _, sources := range shows {
+			if len(sources) < 2 {
+				continue
+			}
+			sort.Slice(sources, func(i, j int) bool {
+				if sources[i].Soundboard != sources[j].Soundboard {
+					return sources[i].Soundboard
+				}
+				return sources[i].Rating > sources[j].Rating
+			})
+			for _, src := range sources[1:] {
+				candidates = append(candidates, gcCandidate{src, "duplicate of " + sources[0].Identifier})
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Source.ShowDir < candidates[j].Source.ShowDir
+	})
+	return candidates
+}
+
+// removeSourceDir deletes a show directory, relative to the output
+// directory, or moves it to the same relative path under trash
+func removeSourceDir(outputDir, showDir, trash string, staleLock time.Duration) error {
+	dir, err := safeJoin(outputDir, filepath.FromSlash(showDir))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		// Already gone, only the catalog entry is left
+		return nil
+	}
+
+	// Don't pull the directory away from an instance that is still writing to it
+	lock, err := acquireShowLock(dir, staleLock)
+	if err != nil {
+		return err
+	}
+
+	if trash == "" {
+		return os.RemoveAll(dir)
+	}
+
+	dest := filepath.Join(trash, filepath.FromSlash(showDir))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		lock.Release()
+		return err
+	}
+	if err := os.Rename(dir, dest); err != nil {
+		lock.Release()
+		return err
+	}
+	return os.Remove(filepath.Join(dest, LockFileName))
+}
@@ -2,7 +2,11 @@ module github.com/Hunter-Thompson/dead-dl
 
 go 1.24.8
 
-require github.com/schollz/progressbar/v3 v3.18.0
+require (
+	github.com/lib/pq v1.12.3
+	github.com/vbauerster/mpb/v8 v8.11.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/VividCortex/ewma v1.2.0 // indirect
@@ -10,9 +14,5 @@ require (
 	github.com/clipperhouse/stringish v0.1.1 // indirect
 	github.com/clipperhouse/uax29/v2 v2.3.0 // indirect
 	github.com/mattn/go-runewidth v0.0.19 // indirect
-	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
-	github.com/rivo/uniseg v0.4.7 // indirect
-	github.com/vbauerster/mpb/v8 v8.11.1 // indirect
 	golang.org/x/sys v0.37.0 // indirect
-	golang.org/x/term v0.28.0 // indirect
 )
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+)
+
+// HookData is what hook commands can refer to, e.g. {{.ShowDir}}
+type HookData struct {
+	Band       string
+	Year       string
+	Date       string // Show date
+	ShowDir    string
+	Identifier string
+	File       string // Local path of the file, for -on-file-complete
+	Files      int    // Files downloaded, for -on-run-complete
+	Bytes      int64  // Bytes transferred, for -on-run-complete
+	Status     string // complete, aborted, paused, or cancelled, for -on-run-complete
+}
+
+// runHook runs a hook command with the template fields filled in. The command
+// is split into arguments before the fields are filled in, so paths with
+// spaces stay one argument; use quotes to group words, or sh -c for shell
+// syntax. Failures are logged and never stop the run.
+func runHook(name, command string, data HookData) {
+	if command == "" {
+		return
+	}
+
+	args, err := splitArgs(command)
+	if err != nil {
+		logger.Warn("Invalid %s hook: %v", name, err)
+		return
+	}
+	for i, arg := range args {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			logger.Warn("Invalid %s hook: %v", name, err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			logger.Warn("Invalid %s hook: %v", name, err)
+			return
+		}
+		args[i] = buf.String()
+	}
+
+	logger.Debug("Running %s hook: %s", name, strings.Join(args, " "))
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		logger.Warn("%s hook failed: %v", name, err)
+	}
+}
+
+// FilterInput is what -filter-hook receives on stdin for every source
+type FilterInput struct {
+	Band   string `json:"band"`
+	Show   Show   `json:"show"`
+	Source Source `json:"source"`
+}
+
+// filterHookAccepts runs -filter-hook for a source and reports whether it
+// should be downloaded. The hook prints accept or reject; anything else,
+// or a failure, rejects the source, so a broken filter doesn't download
+// everything.
+func filterHookAccepts(command string, input FilterInput) bool {
+	args, err := splitArgs(command)
+	if err != nil {
+		logger.Warn("Invalid filter hook: %v", err)
+		return false
+	}
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		logger.Warn("Failed to encode filter input: %v", err)
+		return false
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		logger.Warn("Filter hook failed for %s, skipping it: %v", archiveIdentifier(input.Source), err)
+		return false
+	}
+
+	switch answer := strings.ToLower(strings.TrimSpace(string(out))); answer {
+	case "accept":
+		return true
+	case "reject":
+		return false
+	default:
+		logger.Warn("Filter hook answered %q for %s, skipping it (expected accept or reject)", answer, archiveIdentifier(input.Source))
+		return false
+	}
+}
+
+// splitArgs splits a command line into arguments on spaces outside single
+// or double quotes
+func splitArgs(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range command {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", command)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
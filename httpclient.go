@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultUserAgent identifies dead-dl to relisten.org and archive.org, which
+// ask heavy users to say who they are
+const DefaultUserAgent = "dead-dl (+https://github.com/Hunter-Thompson/dead-dl)"
+
+const (
+	// apiTimeout bounds a whole API request, body included; file downloads
+	// are bounded by stall detection and -file-timeout instead
+	apiTimeout = 2 * time.Minute
+
+	// httpRetryBase is the wait before the first retry of a request; it
+	// doubles with every attempt, up to httpRetryMax
+	httpRetryBase = time.Second
+	httpRetryMax  = time.Minute
+
+	// maxRetryAfter is the longest Retry-After dead-dl waits for; a server
+	// asking for more gets its error returned instead
+	maxRetryAfter = 5 * time.Minute
+)
+
+// userAgent and httpRetries are the process-wide -user-agent and -http-retries
+var (
+	userAgent   = DefaultUserAgent
+	httpRetries = 3
+)
+
+// httpTransport makes the requests of both clients. It honors HTTP_PROXY,
+// HTTPS_PROXY, and NO_PROXY; -record-fixtures and -replay-fixtures wrap or
+// replace it.
+var httpTransport http.RoundTripper = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   32,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   15 * time.Second,
+	ResponseHeaderTimeout: time.Minute,
+	ExpectContinueTimeout: time.Second,
+}
+
+var (
+	// apiClient fetches relisten.org and archive.org metadata
+	apiClient = &http.Client{Transport: retryTransport{}, Timeout: apiTimeout}
+	// downloadClient fetches files, which may take hours
+	downloadClient = &http.Client{Transport: retryTransport{}}
+)
+
+// retryTransport sets the User-Agent of every request and retries GET and
+// HEAD requests that failed to connect or were answered with 429 or a 5xx
+// status, waiting with exponential backoff and jitter, or as long as the
+// server's Retry-After says
+type retryTransport struct{}
+
+func (retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	retryable := (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Body == nil
+
+	for attempt := 0; ; attempt++ {
+		resp, err := httpTransport.RoundTrip(req)
+		if !retryable || attempt >= httpRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		delay := backoff(attempt, httpRetryBase, httpRetryMax)
+		if err == nil {
+			if !retryableStatus(resp.StatusCode) {
+				return resp, nil
+			}
+			if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				if after > maxRetryAfter {
+					return resp, nil
+				}
+				delay = after
+			}
+			// Drain a little of the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			logger.Debug("%s returned status %d, retrying in %s", req.URL.Redacted(), resp.StatusCode, delay.Round(time.Millisecond))
+		} else {
+			logger.Debug("%s failed: %v, retrying in %s", req.URL.Redacted(), err, delay.Round(time.Millisecond))
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryableStatus reports whether a response status may change on a retry
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// backoff returns the wait before retry attempt+1: base doubled attempt
+// times, capped at limit, with up to half of it randomized away so clients
+// that failed together don't retry together
+func backoff(attempt int, base, limit time.Duration) time.Duration {
+	delay := base << attempt
+	if delay > limit || delay <= 0 {
+		delay = limit
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// setHTTPOptions applies -user-agent and -http-retries. State files written
+// before they existed have neither, and get the defaults.
+func setHTTPOptions(cfg *Config) {
+	userAgent = cfg.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	httpRetries = cfg.HTTPRetries
+}
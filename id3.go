@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// id3v24Obsolete are ID3v2.3 frames that ID3v2.4 replaced; they are dropped
+// when an older tag is rewritten as v2.4
+var id3v24Obsolete = map[string]bool{
+	"TYER": true, "TDAT": true, "TIME": true, "TORY": true, "TRDA": true,
+	"TSIZ": true, "EQUA": true, "RVAD": true, "IPLS": true,
+}
+
+// id3Frames maps the fields of a TrackTags to ID3v2.4 text frames
+var id3Frames = []struct {
+	id    string
+	value func(t *TrackTags) string
+}{
+	{"TIT2", func(t *TrackTags) string { return t.Title }},
+	{"TPE1", func(t *TrackTags) string { return t.Artist }},
+	{"TPE2", func(t *TrackTags) string { return t.Artist }},
+	{"TALB", func(t *TrackTags) string { return t.Album }},
+	{"TDRC", func(t *TrackTags) string { return t.Date }},
+	{"TRCK", func(t *TrackTags) string { return numberOf(t.Track, t.Tracks) }},
+	{"TPOS", func(t *TrackTags) string { return numberOf(t.Disc, t.Discs) }},
+	{"TSST", func(t *TrackTags) string { return t.SetName }},
+	{"TIT1", func(t *TrackTags) string { return t.grouping() }},
+}
+
+// numberOf formats a number like 3/12, or "" when it is unknown
+func numberOf(n, total int) string {
+	switch {
+	case n <= 0:
+		return ""
+	case total <= 0:
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprintf("%d/%d", n, total)
+}
+
+// syncsafe encodes n in 4 bytes of 7 bits each
+func syncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// unsyncsafe decodes a 4-byte syncsafe integer
+func unsyncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// id3Frame encodes a single ID3v2.4 frame
+func id3Frame(id string, content []byte) []byte {
+	frame := append([]byte(id), syncsafe(len(content))...)
+	frame = append(frame, 0, 0)
+	return append(frame, content...)
+}
+
+// id3Text encodes the content of a UTF-8 text frame
+func id3Text(value string) []byte {
+	return append([]byte{3}, value...)
+}
+
+// readID3 reads the ID3v2 tag at the start of an MP3 file. It returns the
+// length of the tag, 0 if there is none, and the frames worth keeping when
+// the tag is replaced: those of a v2.3 or v2.4 tag that can be copied as they are.
+func readID3(f io.ReaderAt) (int64, map[string][][]byte, error) {
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err == io.EOF {
+		return 0, nil, nil
+	} else if err != nil {
+		return 0, nil, err
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil, nil
+	}
+
+	version, flags := header[3], header[5]
+	length := int64(10 + unsyncsafe(header[6:10]))
+	if version == 4 && flags&0x10 != 0 {
+		length += 10 // Footer
+	}
+
+	// Unsynchronised tags and extended headers aren't worth the trouble; their frames are dropped
+	frames := make(map[string][][]byte)
+	if (version != 3 && version != 4) || flags&0xc0 != 0 {
+		return length, frames, nil
+	}
+
+	data := make([]byte, unsyncsafe(header[6:10]))
+	if _, err := f.ReadAt(data, 10); err != nil {
+		return 0, nil, err
+	}
+	for pos := 0; pos+10 <= len(data) && data[pos] != 0; {
+		id := string(data[pos : pos+4])
+		var size int
+		if version == 4 {
+			size = unsyncsafe(data[pos+4 : pos+8])
+		} else {
+			size = int(data[pos+4])<<24 | int(data[pos+5])<<16 | int(data[pos+6])<<8 | int(data[pos+7])
+		}
+		formatFlags := data[pos+9]
+		start, end := pos+10, pos+10+size
+		if size < 0 || end > len(data) {
+			break
+		}
+		pos = end
+
+		// Compressed, encrypted, or grouped frames can't be copied as they are
+		if formatFlags != 0 || id3v24Obsolete[id] {
+			continue
+		}
+		frames[id] = append(frames[id], data[start:end])
+	}
+	return length, frames, nil
+}
+
+// writeID3 replaces the ID3v2 tag of an MP3 file with an ID3v2.4 tag of the
+// given tags. Frames of the old tag that the new one doesn't set are kept.
+// MP3s with a LAME tag also get an iTunSMPB comment of their encoder delay
+// and padding, which iTunes and Apple devices need to play them gaplessly.
+// The file is rewritten next to itself and renamed over the original.
+func writeID3(path string, tags *TrackTags) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	oldLength, oldFrames, err := readID3(f)
+	if err != nil {
+		return fmt.Errorf("failed to read ID3 tag: %w", err)
+	}
+
+	var frames bytes.Buffer
+	set := make(map[string]bool)
+	for _, frame := range id3Frames {
+		if value := frame.value(tags); value != "" {
+			frames.Write(id3Frame(frame.id, id3Text(value)))
+			set[frame.id] = true
+		}
+	}
+	for _, txxx := range tags.extras() {
+		frames.Write(id3Frame("TXXX", append(id3Text(txxx[0]), append([]byte{0}, txxx[1]...)...)))
+	}
+	comment := tags.grouping()
+	if comment != "" {
+		// UTF-8, English, no description
+		frames.Write(id3Frame("COMM", append([]byte{3, 'e', 'n', 'g', 0}, comment...)))
+	}
+	gapless, gaplessErr := readMP3Gapless(f)
+	if gaplessErr == nil {
+		frames.Write(id3Frame("COMM", gapless.comment()))
+	}
+	if tags.Cover != nil {
+		content := append([]byte{3}, tags.Cover.MIME...)
+		content = append(content, 0, 3, 0) // Front cover, empty description
+		frames.Write(id3Frame("APIC", append(content, tags.Cover.Data...)))
+		set["APIC"] = true
+	}
+	// Our TXXX and COMM frames replace those with the same description; others are kept
+	ids := make([]string, 0, len(oldFrames))
+	for id := range oldFrames {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if set[id] {
+			continue
+		}
+		for _, content := range oldFrames[id] {
+			if id == "TXXX" && tags.hasExtra(txxxDescription(content)) {
+				continue
+			}
+			if id == "COMM" {
+				desc := commDescription(content)
+				if (desc == "" && comment != "") || (desc == "iTunSMPB" && gaplessErr == nil) {
+					continue
+				}
+			}
+			frames.Write(id3Frame(id, content))
+		}
+	}
+
+	header := append([]byte("ID3"), 4, 0, 0)
+	header = append(header, syncsafe(frames.Len())...)
+
+	return rewriteFile(path, func(w io.Writer) error {
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(frames.Bytes()); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, io.NewSectionReader(f, oldLength, 1<<62))
+		return err
+	})
+}
+
+// addID3Frame adds a frame to the ID3v2.3 or v2.4 tag of an MP3 file,
+// keeping the version and the frames of the tag, or adds an ID3v2.3 tag of
+// just the frame to a file without one
+func addID3Frame(path, id string, content []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	version, oldLength := byte(3), int64(0)
+	var frames []byte
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err == nil && string(header[:3]) == "ID3" {
+		version, oldLength = header[3], int64(10+unsyncsafe(header[6:10]))
+		if (version != 3 && version != 4) || header[5] != 0 {
+			return fmt.Errorf("can't add to an ID3v2.%d tag with flags %#x", version, header[5])
+		}
+		frames = make([]byte, oldLength-10)
+		if _, err := f.ReadAt(frames, 10); err != nil {
+			return err
+		}
+		// Drop the padding after the last frame
+		pos := 0
+		for pos+10 <= len(frames) && frames[pos] != 0 {
+			size := int(frames[pos+4])<<24 | int(frames[pos+5])<<16 | int(frames[pos+6])<<8 | int(frames[pos+7])
+			if version == 4 {
+				size = unsyncsafe(frames[pos+4 : pos+8])
+			}
+			pos += 10 + size
+		}
+		frames = frames[:min(pos, len(frames))]
+	}
+
+	if version == 4 {
+		frames = append(frames, id3Frame(id, content)...)
+	} else {
+		frames = append(frames, id...)
+		frames = binary.BigEndian.AppendUint32(frames, uint32(len(content)))
+		frames = append(append(frames, 0, 0), content...)
+	}
+	header = append([]byte("ID3"), version, 0, 0)
+	header = append(header, syncsafe(len(frames))...)
+
+	return rewriteFile(path, func(w io.Writer) error {
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(frames); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, io.NewSectionReader(f, oldLength, 1<<62))
+		return err
+	})
+}
+
+// txxxDescription returns the description of a TXXX frame
+func txxxDescription(content []byte) string {
+	if len(content) == 0 {
+		return ""
+	}
+	// Only Latin-1 and UTF-8 descriptions are compared; UTF-16 ones never match ours
+	if content[0] != 0 && content[0] != 3 {
+		return ""
+	}
+	desc, _, _ := strings.Cut(string(content[1:]), "\x00")
+	return desc
+}
+
+// commDescription returns the description of a COMM frame, or "?" when it
+// can't be read
+func commDescription(content []byte) string {
+	// Only Latin-1 and UTF-8 descriptions are read; UTF-16 ones never match ours
+	if len(content) < 4 || (content[0] != 0 && content[0] != 3) {
+		return "?"
+	}
+	desc, _, _ := strings.Cut(string(content[4:]), "\x00")
+	return desc
+}
+
+// rewriteFile writes a new version of a file through write into a temporary
+// file next to it, and renames that over the original once it is complete
+func rewriteFile(path string, write func(w io.Writer) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tagging"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := write(out); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
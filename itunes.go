@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ITunesPlaylistName is the playlist an iTunes library export puts every
+// track in, since Apple Music only imports tracks through a playlist
+const ITunesPlaylistName = "dead-dl"
+
+// iTunesKinds are the audio formats Apple Music can play, by extension.
+// FLAC, Shorten, Ogg, WavPack, and APE files are left out of the export.
+var iTunesKinds = map[string]string{
+	".mp3":  "MPEG audio file",
+	".m4a":  "AAC audio file",
+	".wav":  "WAV audio file",
+	".aiff": "AIFF audio file",
+	".aif":  "AIFF audio file",
+}
+
+// iTunesTrack is a track of an iTunes library export
+type iTunesTrack struct {
+	ID          int
+	Name        string
+	Artist      string
+	Album       string
+	Year        string
+	TrackNumber int
+	Kind        string
+	Size        int64
+	TotalTime   int64 // Milliseconds, 0 if unknown
+	Comments    string
+	DateAdded   time.Time
+	Location    string
+}
+
+// exportITunes writes the cataloged shows under outputDir as an iTunes
+// library XML that Apple Music imports with File > Library > Import Playlist.
+// Tracks reference the downloaded files where they are, so the export must
+// be made with -output pointing at the collection as the Mac sees it.
+func exportITunes(store CatalogStore, outputDir, path string) error {
+	catalog, err := store.Load()
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+
+	// Superseded sources are left out, and a show with several sources
+	// gets one album per source
+	var sources []*CatalogSource
+	perShow := make(map[string]int)
+	for _, src := range catalog.Sources {
+		if src.SupersededBy != "" && catalog.Sources[src.SupersededBy] != nil {
+			continue
+		}
+		sources = append(sources, src)
+		perShow[src.Band+"/"+src.Date]++
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Date != sources[j].Date {
+			return sources[i].Date < sources[j].Date
+		}
+		return sources[i].Identifier < sources[j].Identifier
+	})
+
+	var tracks []iTunesTrack
+	skipped := 0
+	for _, src := range sources {
+		showDir := filepath.Join(root, filepath.FromSlash(src.ShowDir))
+		manifest, err := loadManifest(showDir)
+		if err != nil {
+			logger.Warn("Failed to load manifest of %s: %v", src.ShowDir, err)
+			continue
+		}
+
+		album := src.Date
+		if perShow[src.Band+"/"+src.Date] > 1 {
+			album += " [" + src.Identifier + "]"
+		}
+		// Tracks are numbered in manifest order, since local names number
+		// encores and sets separately
+		number := 0
+		for _, entry := range manifest.Files {
+			if !isAudioFile(entry.LocalName) {
+				continue
+			}
+			kind, ok := iTunesKinds[strings.ToLower(filepath.Ext(entry.LocalName))]
+			if !ok {
+				skipped++
+				continue
+			}
+			number++
+			tracks = append(tracks, iTunesTrack{
+				ID:          len(tracks) + 1,
+				Name:        trackTitle(entry.LocalName),
+				Artist:      bandDisplayName(src.Band),
+				Album:       album,
+				Year:        src.Year,
+				TrackNumber: number,
+				Kind:        kind,
+				Size:        entry.Size,
+				TotalTime:   int64(entry.Duration * 1000),
+				Comments:    src.Identifier,
+				DateAdded:   entry.DownloadedAt,
+				Location:    fileURL(filepath.Join(showDir, entry.LocalName)),
+			})
+		}
+	}
+	if skipped > 0 {
+		logger.Warn("Left out %d file(s) in formats Apple Music can't play, such as FLAC", skipped)
+	}
+
+	if path == "" {
+		return writeITunesLibrary(os.Stdout, root, tracks)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeITunesLibrary(f, root, tracks); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	logger.Info("Exported %d track(s) to %s", len(tracks), path)
+	return nil
+}
+
+// trackTitle returns the title in a local file name like
+// "Set 1/03 Scarlet Begonias.mp3", without the track number
+func trackTitle(localName string) string {
+	base := filepath.Base(localName)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+
+	// Encore tracks are named e01, e02, ... with -encore-names
+	prefix, rest, ok := strings.Cut(name, " ")
+	if _, err := strconv.Atoi(strings.TrimPrefix(prefix, "e")); !ok || err != nil {
+		return name
+	}
+	return rest
+}
+
+// bandDisplayName turns a band slug like grateful-dead into "Grateful Dead"
+func bandDisplayName(band string) string {
+	words := strings.Split(band, "-")
+	for i, w := range words {
+		if w != "" {
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// fileURL returns the file:// URL of an absolute path
+func fileURL(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
+
+// writeITunesLibrary writes tracks as an iTunes library property list with
+// one playlist holding all of them
+func writeITunesLibrary(w io.Writer, root string, tracks []iTunesTrack) error {
+	p := &plistWriter{w: bufio.NewWriter(w), indent: "\t"}
+	p.raw(xml.Header)
+	p.raw(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	p.raw("<plist version=\"1.0\">\n<dict>\n")
+	p.integer("Major Version", 1)
+	p.integer("Minor Version", 1)
+	p.date("Date", time.Now())
+	p.str("Application Version", "dead-dl")
+	p.str("Music Folder", fileURL(root)+"/")
+
+	p.raw("\t<key>Tracks</key>\n\t<dict>\n")
+	p.indent = "\t\t\t"
+	for _, t := range tracks {
+		p.raw(fmt.Sprintf("\t\t<key>%d</key>\n\t\t<dict>\n", t.ID))
+		p.integer("Track ID", int64(t.ID))
+		p.str("Name", t.Name)
+		p.str("Artist", t.Artist)
+		p.str("Album Artist", t.Artist)
+		p.str("Album", t.Album)
+		p.str("Kind", t.Kind)
+		p.integer("Size", t.Size)
+		if t.TotalTime > 0 {
+			p.integer("Total Time", t.TotalTime)
+		}
+		if t.TrackNumber > 0 {
+			p.integer("Track Number", int64(t.TrackNumber))
+		}
+		if year, err := strconv.Atoi(t.Year); err == nil {
+			p.integer("Year", int64(year))
+		}
+		if !t.DateAdded.IsZero() {
+			p.date("Date Added", t.DateAdded)
+		}
+		p.str("Comments", t.Comments)
+		p.str("Location", t.Location)
+		p.raw("\t\t</dict>\n")
+	}
+	p.raw("\t</dict>\n")
+
+	p.raw("\t<key>Playlists</key>\n\t<array>\n\t\t<dict>\n")
+	p.str("Name", ITunesPlaylistName)
+	p.integer("Playlist ID", 1)
+	p.raw("\t\t\t<key>All Items</key><true/>\n\t\t\t<key>Playlist Items</key>\n\t\t\t<array>\n")
+	for _, t := range tracks {
+		p.raw(fmt.Sprintf("\t\t\t\t<dict><key>Track ID</key><integer>%d</integer></dict>\n", t.ID))
+	}
+	p.raw("\t\t\t</array>\n\t\t</dict>\n\t</array>\n</dict>\n</plist>\n")
+
+	if p.err != nil {
+		return p.err
+	}
+	return p.w.Flush()
+}
+
+// plistWriter writes the key/value pairs of a property list dict, keeping
+// the first write error
+type plistWriter struct {
+	w      *bufio.Writer
+	indent string
+	err    error
+}
+
+func (p *plistWriter) raw(s string) {
+	if p.err == nil {
+		_, p.err = p.w.WriteString(s)
+	}
+}
+
+func (p *plistWriter) escaped(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (p *plistWriter) str(key, value string) {
+	p.raw(fmt.Sprintf("%s<key>%s</key><string>%s</string>\n", p.indent, p.escaped(key), p.escaped(value)))
+}
+
+func (p *plistWriter) integer(key string, value int64) {
+	p.raw(fmt.Sprintf("%s<key>%s</key><integer>%d</integer>\n", p.indent, p.escaped(key), value))
+}
+
+func (p *plistWriter) date(key string, value time.Time) {
+	p.raw(fmt.Sprintf("%s<key>%s</key><date>%s</date>\n", p.indent, p.escaped(key), value.UTC().Format(time.RFC3339)))
+}
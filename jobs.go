@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// JobFile is a batch of download runs, e.g.
+//
+//	parallel: 2
+//	defaults:
+//	  output: /srv/music
+//	  format: flac
+//	jobs:
+//	  - band: grateful-dead
+//	    year: 1972-1977
+//	    month: 05
+//	  - band: phish
+//	    year: [1995, 1997]
+//
+// Job keys are the download flags without the dash; list values are joined
+// with commas.
+type JobFile struct {
+	Parallel int                      `yaml:"parallel"`
+	Defaults map[string]interface{}   `yaml:"defaults"`
+	Jobs     []map[string]interface{} `yaml:"jobs"`
+}
+
+// Job is a parsed entry of a job file
+type Job struct {
+	Name string
+	Cfg  *Config
+}
+
+// runJobs handles `dead-dl run jobs.yaml`, which runs the jobs of a job file
+// one after another, or several at a time with parallel
+func runJobs(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	parallel := fs.Int("parallel", 0, "Number of jobs to run at the same time (default: the file's parallel, or 1)")
+	yes := fs.Bool("yes", false, "Don't ask for confirmation before running the jobs")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dead-dl run [-parallel N] [-yes] <jobs.yaml>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	file, err := loadJobFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load %s: %v\n", fs.Arg(0), err)
+		os.Exit(1)
+	}
+	jobs, err := file.parse()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid job file %s: %v\n", fs.Arg(0), err)
+		os.Exit(1)
+	}
+	if *parallel <= 0 {
+		*parallel = max(file.Parallel, 1)
+	}
+	// Rate limits, HTTP options, the metadata cache, the quota, and the like
+	// are shared by the whole process, so jobs that set them differently take
+	// turns
+	mixed := false
+	for _, job := range jobs[1:] {
+		mixed = mixed || processSettings(job.Cfg) != processSettings(jobs[0].Cfg)
+	}
+	serialized := mixed && *parallel > 1
+	if serialized {
+		*parallel = 1
+	}
+
+	// Progress bars of jobs running side by side would overwrite each other
+	base := jobs[0].Cfg
+	if *parallel > 1 {
+		for _, job := range jobs {
+			if job.Cfg.Progress != ProgressJSON {
+				job.Cfg.Progress = ProgressPlain
+			}
+		}
+	}
+
+	initRunLogger(base)
+	defer logger.Close()
+	if serialized {
+		logger.Warn("Jobs set different rates, HTTP options, metadata caches, fixtures, audio extensions, or quotas, so they run one at a time")
+	}
+
+	for _, job := range jobs {
+		if !hasRunTarget(job.Cfg) {
+			logger.Fatal("%s has no year, date, tour, or era", job.Name)
+		}
+		validateConfig(job.Cfg)
+	}
+
+	if err := setupEvents(base); err != nil {
+		logger.Fatal("Failed to set up progress events: %v", err)
+	}
+	defer events.Close()
+
+	logger.Info("=== Dead-DL Started: %d job(s), %d at a time ===", len(jobs), *parallel)
+	for _, job := range jobs {
+		logger.Info("  %s: band=%s, target=%s, format=%s, output=%s",
+			job.Name, job.Cfg.Band, runLabel(job.Cfg), job.Cfg.Format, job.Cfg.OutputDir)
+	}
+	if !*yes && !confirm(fmt.Sprintf("Run %d job(s) without asking per job?", len(jobs))) {
+		logger.Info("Download cancelled")
+		return
+	}
+
+	// A job that fails doesn't stop the others
+	semaphore := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []string
+	for i, job := range jobs {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, job Job) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			logger.Printf("\n=== Job [%d/%d] %s ===\n", i+1, len(jobs), job.Name)
+			job.Cfg.Yes = true
+			applyProcessSettings(job.Cfg)
+			if err := startRun(job.Cfg); err != nil {
+				logger.Error("Job %s failed: %v", job.Name, err)
+				mu.Lock()
+				failed = append(failed, job.Name)
+				mu.Unlock()
+			}
+		}(i, job)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		logger.Fatal("%d of %d job(s) failed: %s", len(failed), len(jobs), strings.Join(failed, ", "))
+	}
+	logger.Info("All %d job(s) finished", len(jobs))
+}
+
+// loadJobFile reads a job file
+func loadJobFile(path string) (*JobFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file JobFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("no jobs")
+	}
+	return &file, nil
+}
+
+// parse turns every job, on top of the defaults, into a Config by feeding
+// its keys to the download flags
+func (f *JobFile) parse() ([]Job, error) {
+	var jobs []Job
+	for i, entry := range f.Jobs {
+		name := fmt.Sprintf("job %d", i+1)
+		if n, ok := entry["name"]; ok {
+			name = fmt.Sprint(n)
+		}
+
+		var args []string
+		for _, values := range []map[string]interface{}{f.Defaults, entry} {
+			keys := make([]string, 0, len(values))
+			for key := range values {
+				if key != "name" {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				args = append(args, fmt.Sprintf("-%s=%s", key, jobValue(values[key])))
+			}
+		}
+
+		cfg := &Config{}
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		registerFlags(fs, cfg)
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		jobs = append(jobs, Job{Name: name, Cfg: cfg})
+	}
+	return jobs, nil
+}
+
+// jobValue formats a job file value as a flag value
+func jobValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		parts := make([]string, len(list))
+		for i, v := range list {
+			parts[i] = fmt.Sprint(v)
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LatestDirName is the folder under the output directory with symlinks to
+// the most recently completed shows
+const LatestDirName = "_latest"
+
+// latestMu serializes updates of the _latest/ folder
+var latestMu sync.Mutex
+
+// updateLatest links a completed show directory into _latest/ and removes
+// the oldest links beyond -latest, as well as links whose show is gone
+func updateLatest(cfg *Config, showDir string) {
+	if cfg.Latest <= 0 {
+		return
+	}
+	// Shows finishing at the same time with -parallel-shows would race on the links
+	latestMu.Lock()
+	defer latestMu.Unlock()
+
+	latestDir := filepath.Join(cfg.OutputDir, LatestDirName)
+	if err := os.MkdirAll(latestDir, 0755); err != nil {
+		logger.Warn("Failed to create %s: %v", latestDir, err)
+		return
+	}
+
+	// Relative targets keep working when the collection is mounted elsewhere
+	target, err := filepath.Rel(latestDir, showDir)
+	if err != nil {
+		target, _ = filepath.Abs(showDir)
+	}
+	rel, err := filepath.Rel(cfg.OutputDir, showDir)
+	if err != nil {
+		rel = filepath.Base(showDir)
+	}
+	link := filepath.Join(latestDir, strings.ReplaceAll(filepath.ToSlash(rel), "/", " - "))
+
+	// Replace an older link to the same show, so it moves to the front
+	os.Remove(link)
+	if err := os.Symlink(target, link); err != nil {
+		logger.Warn("Failed to link %s into %s: %v", showDir, LatestDirName, err)
+		return
+	}
+	pruneLatest(latestDir, cfg.Latest)
+}
+
+// pruneLatest keeps the keep newest links of the _latest/ folder
+func pruneLatest(latestDir string, keep int) {
+	entries, err := os.ReadDir(latestDir)
+	if err != nil {
+		logger.Warn("Failed to read %s: %v", latestDir, err)
+		return
+	}
+
+	type latestLink struct {
+		path string
+		info os.FileInfo
+	}
+	var links []latestLink
+	for _, entry := range entries {
+		path := filepath.Join(latestDir, entry.Name())
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			// The show was removed or moved
+			os.Remove(path)
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		links = append(links, latestLink{path, info})
+	}
+
+	sort.Slice(links, func(i, j int) bool { return links[i].info.ModTime().After(links[j].info.ModTime()) })
+	for _, link := range links[min(keep, len(links)):] {
+		if err := os.Remove(link.path); err != nil {
+			logger.Warn("Failed to remove %s: %v", link.path, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mediaTypes are the content types of the audio files served, by extension
+var mediaTypes = map[string]string{
+	".flac": "audio/flac",
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+	".shn":  "audio/x-shorten",
+	".wav":  "audio/wav",
+	".m4a":  "audio/mp4",
+	".oga":  "audio/ogg",
+	".opus": "audio/opus",
+	".wv":   "audio/x-wavpack",
+	".ape":  "audio/x-ape",
+	".aiff": "audio/aiff",
+	".aif":  "audio/aiff",
+}
+
+// loadMediaLibrary loads a fresh view of the catalog, so shows downloaded
+// while a server runs appear without a restart
+func loadMediaLibrary(store CatalogStore, root, name string) (*mediaLibrary, error) {
+	catalog, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return newMediaLibrary(catalog, root, name), nil
+}
+
+// mediaObject is a container (band, year, or show) or an item (track) of
+// the served collection
+type mediaObject struct {
+	ID       string
+	ParentID string
+	Title    string
+	Class    string
+	Children int
+
+	// Shows and tracks
+	Artist string
+	Date   string
+
+	// Tracks
+	Album       string
+	TrackNumber int
+	Path        string
+	URLPath     string
+	MIME        string
+	Size        int64
+	Duration    float64
+}
+
+func (o mediaObject) isItem() bool {
+	return o.URLPath != ""
+}
+
+// mediaLibrary arranges the cataloged sources as bands, years, and shows for
+// the serve modes. Object IDs are "0" for the root, "band:<band>",
+// "year:<band>/<year>", "show:<identifier>", and "track:<identifier>/<n>".
+type mediaLibrary struct {
+	root    string
+	name    string
+	bands   []string
+	years   map[string][]string         // Years of each band
+	shows   map[string][]*CatalogSource // Sources of each band/year, by date
+	sources map[string]*CatalogSource
+	perShow map[string]int // Sources of each band/date
+}
+
+func newMediaLibrary(catalog *Catalog, root, name string) *mediaLibrary {
+	lib := &mediaLibrary{
+		root:    root,
+		name:    name,
+		years:   make(map[string][]string),
+		shows:   make(map[string][]*CatalogSource),
+		sources: make(map[string]*CatalogSource),
+		perShow: make(map[string]int),
+	}
+	for id, src := range catalog.Sources {
+		if src.SupersededBy != "" && catalog.Sources[src.SupersededBy] != nil {
+			continue
+		}
+		lib.sources[id] = src
+		key := src.Band + "/" + src.Year
+		if len(lib.shows[key]) == 0 {
+			if len(lib.years[src.Band]) == 0 {
+				lib.bands = append(lib.bands, src.Band)
+			}
+			lib.years[src.Band] = append(lib.years[src.Band], src.Year)
+		}
+		lib.shows[key] = append(lib.shows[key], src)
+		lib.perShow[src.Band+"/"+src.Date]++
+	}
+
+	sort.Strings(lib.bands)
+	for _, years := range lib.years {
+		sort.Strings(years)
+	}
+	for _, sources := range lib.shows {
+		sort.Slice(sources, func(i, j int) bool {
+			if sources[i].Date != sources[j].Date {
+				return sources[i].Date < sources[j].Date
+			}
+			return sources[i].Identifier < sources[j].Identifier
+		})
+	}
+	return lib
+}
+
+// showTitle names a show by its date, adding the identifier when the show
+// has several sources
+func (l *mediaLibrary) showTitle(src *CatalogSource) string {
+	if l.perShow[src.Band+"/"+src.Date] > 1 {
+		return src.Date + " [" + src.Identifier + "]"
+	}
+	return src.Date
+}
+
+// tracks lists the audio files of a source from its manifest. A source kept
+// in several formats is listed in its cataloged format only.
+func (l *mediaLibrary) tracks(src *CatalogSource) []mediaObject {
+	showDir, err := safeJoin(l.root, filepath.FromSlash(src.ShowDir))
+	if err != nil {
+		return nil
+	}
+	manifest, err := loadManifest(showDir)
+	if err != nil {
+		logger.Warn("Failed to load manifest of %s: %v", src.ShowDir, err)
+		return nil
+	}
+
+	var entries []ManifestEntry
+	var inFormat []ManifestEntry
+	for _, entry := range manifest.Files {
+		ext := strings.ToLower(filepath.Ext(entry.LocalName))
+		if _, ok := mediaTypes[ext]; !ok {
+			continue
+		}
+		entries = append(entries, entry)
+		if ext == "."+src.Format {
+			inFormat = append(inFormat, entry)
+		}
+	}
+	if len(inFormat) > 0 {
+		entries = inFormat
+	}
+
+	var tracks []mediaObject
+	for i, entry := range entries {
+		path, err := safeJoin(showDir, entry.LocalName)
+		if err != nil {
+			continue
+		}
+		n := strconv.Itoa(i + 1)
+		tracks = append(tracks, mediaObject{
+			ID:          "track:" + src.Identifier + "/" + n,
+			ParentID:    "show:" + src.Identifier,
+			Title:       trackTitle(entry.LocalName),
+			Class:       "object.item.audioItem.musicTrack",
+			Artist:      bandDisplayName(src.Band),
+			Date:        src.Date,
+			Album:       l.showTitle(src),
+			TrackNumber: i + 1,
+			Path:        path,
+			URLPath:     "/media/" + src.Identifier + "/" + n,
+			MIME:        mediaTypes[strings.ToLower(filepath.Ext(entry.LocalName))],
+			Size:        entry.Size,
+			Duration:    entry.Duration,
+		})
+	}
+	return tracks
+}
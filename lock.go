@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockFileName is created inside a show directory while an instance is downloading it
+const LockFileName = ".dead-dl.lock"
+
+// DefaultStaleLock is how old a lock file must be before it is assumed to belong to a crashed instance
+const DefaultStaleLock = 24 * time.Hour
+
+// ErrShowLocked is returned when another instance holds the lock for a show directory
+var ErrShowLocked = errors.New("show is locked by another instance")
+
+// ErrCatalogLocked is returned when another instance keeps the catalog locked for too long
+var ErrCatalogLocked = errors.New("catalog is locked by another instance")
+
+// lockInfo is the content of a lock file, identifying the instance holding it
+type lockInfo struct {
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FileLock is a held lock file
+type FileLock struct {
+	path string
+	data []byte // What we wrote into it, to tell it from a lock that replaced it
+}
+
+// acquireShowLock creates the lock file for a show directory
+func acquireShowLock(showDir string, staleAfter time.Duration) (*FileLock, error) {
+	return acquireFileLock(filepath.Join(showDir, LockFileName), staleAfter, ErrShowLocked)
+}
+
+// acquireFileLock creates a lock file. Lock files are created atomically
+// with their content, so that two processes (or two machines sharing the
+// directory over NFS) can't both own one and nobody sees one half-written.
+// Locks older than staleAfter are assumed to belong to a crashed instance
+// and are taken over; so are unreadable ones, but only once their mtime is
+// that old. A live lock is reported as errLocked.
+func acquireFileLock(path string, staleAfter time.Duration, errLocked error) (*FileLock, error) {
+	host, _ := os.Hostname()
+	data, err := json.Marshal(lockInfo{Host: host, PID: os.Getpid(), CreatedAt: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err := createLockFile(path, data)
+		if err == nil {
+			return &FileLock{path: path, data: data}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		stat, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue // Released in the meantime
+		} else if err != nil {
+			return nil, err
+		}
+		existing, readErr := readLockInfo(path)
+		if readErr == nil && time.Since(existing.CreatedAt) < staleAfter {
+			return nil, fmt.Errorf("%w (%s, pid %d, since %s)", errLocked,
+				existing.Host, existing.PID, existing.CreatedAt.Format(time.RFC3339))
+		}
+		if readErr != nil && time.Since(stat.ModTime()) < staleAfter {
+			return nil, fmt.Errorf("%w (unreadable lock file, modified %s)", errLocked,
+				stat.ModTime().Format(time.RFC3339))
+		}
+
+		logger.Warn("Removing stale lock file %s", path)
+		if err := removeStaleLock(path, stat); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, errLocked
+}
+
+// createLockFile creates a lock file holding data. The data goes into a
+// temporary file first, which is then hard-linked to path, failing if path
+// exists. Filesystems without hard links get an O_EXCL create and write.
+func createLockFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, writeErr := tmp.Write(data)
+	closeErr := tmp.Close()
+	if writeErr != nil {
+		return writeErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	err = os.Link(tmp.Name(), path)
+	if err == nil || os.IsExist(err) {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, writeErr = f.Write(data)
+	closeErr = f.Close()
+	if writeErr != nil {
+		os.Remove(path)
+		return writeErr
+	}
+	if closeErr != nil {
+		os.Remove(path)
+		return closeErr
+	}
+	return nil
+}
+
+// removeStaleLock deletes the stale lock file found at path. It is renamed
+// aside first and compared with the file found stale: another instance may
+// have replaced it with a fresh lock in the meantime, which is put back.
+func removeStaleLock(path string, stale os.FileInfo) error {
+	aside := fmt.Sprintf("%s.%d-%d.stale", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); os.IsNotExist(err) {
+		return nil // Someone else removed it
+	} else if err != nil {
+		return err
+	}
+	defer os.Remove(aside)
+
+	moved, err := os.Stat(aside)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(moved, stale) {
+		// A link fails rather than replace a lock created since
+		if err := os.Link(aside, path); err != nil && !os.IsExist(err) {
+			return os.Rename(aside, path)
+		}
+	}
+	return nil
+}
+
+// readLockInfo parses an existing lock file
+func readLockInfo(path string) (lockInfo, error) {
+	var info lockInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// Held reports whether the lock file is still ours, rather than taken over
+// as stale by another instance
+func (l *FileLock) Held() bool {
+	data, err := os.ReadFile(l.path)
+	return err == nil && bytes.Equal(data, l.data)
+}
+
+// Release removes the lock file, unless another instance took it over as
+// stale and it is no longer ours
+func (l *FileLock) Release() error {
+	data, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, l.data) {
+		return fmt.Errorf("lock file %s was taken over by another instance", l.path)
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
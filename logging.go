@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels for -log-level, from the most to the least verbose
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Formats for -log-format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogsDir is where every run writes its log file
+const LogsDir = "./logs"
+
+// Defaults of -log-max-size and -log-keep
+const (
+	DefaultLogMaxSize = 100 << 20
+	DefaultLogKeep    = 30
+)
+
+var logLevels = []string{LevelDebug, LevelInfo, LevelWarn, LevelError}
+
+// levelPrefixes are written before the messages of each level in text logs
+var levelPrefixes = map[string]string{
+	LevelDebug: "[DEBUG] ",
+	LevelInfo:  "[INFO]  ",
+	LevelWarn:  "[WARN]  ",
+	LevelError: "[ERROR] ",
+}
+
+// Logger writes leveled messages to the console and a log file in LogsDir.
+// Printf and Println write the status lines of a run at the info level.
+type Logger struct {
+	mu      sync.Mutex
+	level   int  // Index in logLevels of the least severe level written
+	json    bool // One JSON object per message instead of text lines
+	quiet   bool // Only errors reach the console, next to the progress bars
+	ascii   bool
+	console io.Writer
+	file    *os.File
+	size    int64 // Bytes written to file
+	maxSize int64 // Size at which the log file is rotated; 0 never rotates
+	keep    int   // Log files kept in LogsDir; 0 keeps all
+	partial string
+}
+
+// NewLogger creates a logger writing to console and a new time-based log
+// file, at the info level
+func NewLogger(console io.Writer) (*Logger, error) {
+	l := &Logger{level: 1, console: console, maxSize: DefaultLogMaxSize, keep: DefaultLogKeep}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// openFile starts a new log file, named after the current time, and drops
+// the oldest log files beyond the retention
+func (l *Logger) openFile() error {
+	if err := os.MkdirAll(LogsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create logs directory: %w", err)
+	}
+
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	logFilePath := filepath.Join(LogsDir, fmt.Sprintf("dead-dl_%s.log", timestamp))
+	// A rotation within the same second gets a numbered name
+	for n := 2; ; n++ {
+		if _, err := os.Stat(logFilePath); os.IsNotExist(err) {
+			break
+		}
+		logFilePath = filepath.Join(LogsDir, fmt.Sprintf("dead-dl_%s_%d.log", timestamp, n))
+	}
+
+	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file, l.size = logFile, 0
+	pruneLogs(l.keep, logFilePath)
+	return nil
+}
+
+// pruneLogs removes the oldest log files in LogsDir so at most keep are
+// left, never removing current
+func pruneLogs(keep int, current string) {
+	if keep <= 0 {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(LogsDir, "dead-dl_*.log"))
+	if err != nil || len(paths) <= keep {
+		return
+	}
+	modTimes := make(map[string]time.Time)
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if !modTimes[paths[i]].Equal(modTimes[paths[j]]) {
+			return modTimes[paths[i]].Before(modTimes[paths[j]])
+		}
+		return paths[i] < paths[j]
+	})
+	for _, path := range paths[:len(paths)-keep] {
+		if filepath.Clean(path) != filepath.Clean(current) {
+			os.Remove(path)
+		}
+	}
+}
+
+// LogOptions are the -log-level, -log-format, -quiet, -log-max-size, and
+// -log-keep settings of the logger
+type LogOptions struct {
+	Level   string
+	Format  string
+	Quiet   bool
+	MaxSize ByteSize
+	Keep    int
+}
+
+// Configure applies options to the logger, leaving it unchanged if one is
+// invalid
+func (l *Logger) Configure(opts LogOptions) error {
+	level := 1
+	if opts.Level != "" {
+		level = -1
+		for i, name := range logLevels {
+			if strings.EqualFold(opts.Level, name) {
+				level = i
+			}
+		}
+		if level < 0 {
+			return fmt.Errorf("unknown -log-level %q (use %s)", opts.Level, strings.Join(logLevels, ", "))
+		}
+	}
+	switch opts.Format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("unknown -log-format %q (use %s or %s)", opts.Format, LogFormatText, LogFormatJSON)
+	}
+	if opts.MaxSize < 0 || opts.Keep < 0 {
+		return fmt.Errorf("-log-max-size and -log-keep can't be negative")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+	l.json = opts.Format == LogFormatJSON
+	l.quiet = opts.Quiet
+	l.maxSize = int64(opts.MaxSize)
+	l.keep = opts.Keep
+	if l.file != nil {
+		pruneLogs(l.keep, l.file.Name())
+	}
+	return nil
+}
+
+// Close closes the log file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.json && l.partial != "" {
+		l.writeLine(LevelInfo, l.partial)
+		l.partial = ""
+	}
+	if l.file != nil {
+		return l.file.Close()
+	}
+	return nil
+}
+
+// Debug logs debug messages
+func (l *Logger) Debug(format string, v ...interface{}) {
+	l.log(LevelDebug, fmt.Sprintf(format, v...))
+}
+
+// Info logs info messages
+func (l *Logger) Info(format string, v ...interface{}) {
+	l.log(LevelInfo, fmt.Sprintf(format, v...))
+}
+
+// Warn logs warning messages
+func (l *Logger) Warn(format string, v ...interface{}) {
+	l.log(LevelWarn, fmt.Sprintf(format, v...))
+}
+
+// Error logs error messages
+func (l *Logger) Error(format string, v ...interface{}) {
+	l.log(LevelError, fmt.Sprintf(format, v...))
+}
+
+// Fatal logs error messages and exits
+func (l *Logger) Fatal(format string, v ...interface{}) {
+	l.log(LevelError, fmt.Sprintf(format, v...))
+	l.Close()
+	os.Exit(1)
+}
+
+// SetASCII makes Printf and Println spell out status symbols like ✓ for -ascii
+func (l *Logger) SetASCII(ascii bool) {
+	l.mu.Lock()
+	l.ascii = ascii
+	l.mu.Unlock()
+}
+
+// Printf logs a formatted status message at the info level, as is in text
+// logs and one message per line in JSON logs
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.print(fmt.Sprintf(format, v...))
+}
+
+// Println logs a status message with newline, like Printf
+func (l *Logger) Println(format string, v ...interface{}) {
+	l.print(fmt.Sprintf(format, v...) + "\n")
+}
+
+// enabled reports whether messages of a level are written
+func (l *Logger) enabled(level string) bool {
+	for i, name := range logLevels {
+		if name == level {
+			return i >= l.level
+		}
+	}
+	return false
+}
+
+// log writes a message of a level with a timestamp
+func (l *Logger) log(level, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.enabled(level) {
+		return
+	}
+	if l.json {
+		l.writeLine(level, msg)
+		return
+	}
+	layout := "2006/01/02 15:04:05"
+	if level == LevelDebug {
+		layout = "2006/01/02 15:04:05.000000"
+	}
+	l.write(level, levelPrefixes[level]+time.Now().Format(layout)+" "+msg+"\n")
+}
+
+// print writes status output, which has no level prefix or timestamp in
+// text logs
+func (l *Logger) print(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	if l.ascii {
+		msg = asciiMarks.Replace(msg)
+	}
+	if !l.json {
+		l.write(LevelInfo, msg)
+		return
+	}
+
+	// Messages may be printed a piece at a time; each complete line is one entry
+	lines := strings.Split(l.partial+msg, "\n")
+	l.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if line = strings.TrimSpace(line); line != "" {
+			l.writeLine(LevelInfo, line)
+		}
+	}
+}
+
+// writeLine writes a message as a JSON object
+func (l *Logger) writeLine(level, msg string) {
+	data, _ := json.Marshal(struct {
+		Time  time.Time `json:"time"`
+		Level string    `json:"level"`
+		Msg   string    `json:"msg"`
+	}{time.Now(), level, msg})
+	l.write(level, string(data)+"\n")
+}
+
+// write sends text to the console, unless -quiet holds it back, and to the
+// log file, which is rotated once it reaches -log-max-size
+func (l *Logger) write(level, text string) {
+	if !l.quiet || level == LevelError {
+		io.WriteString(l.console, text)
+	}
+	if l.file == nil {
+		return
+	}
+	n, _ := l.file.WriteString(text)
+	l.size += int64(n)
+	if l.maxSize > 0 && l.size >= l.maxSize && strings.HasSuffix(text, "\n") {
+		if err := l.openFile(); err != nil {
+			fmt.Fprintf(l.console, "Failed to rotate log file: %v\n", err)
+		}
+	}
+}
+
+var logger *Logger
+
+// initLogger sets up the package logger with a time-based log file
+func initLogger(console io.Writer) {
+	var err error
+	logger, err = NewLogger(console)
+	if err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+}
+
+// initRunLogger sets up the package logger of a download run with its
+// -log-* options
+func initRunLogger(cfg *Config) {
+	initLogger(consoleWriter(cfg.Progress))
+	opts := LogOptions{Level: cfg.LogLevel, Format: cfg.LogFormat, Quiet: cfg.Quiet, MaxSize: cfg.LogMaxSize, Keep: cfg.LogKeep}
+	if err := logger.Configure(opts); err != nil {
+		logger.Fatal("%v", err)
+	}
+}
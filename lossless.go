@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What to do with MP3 downloads once FLAC becomes available for the same source
+const (
+	LosslessUpgradeOff     = "off"
+	LosslessUpgradeAugment = "augment"
+	LosslessUpgradeReplace = "replace"
+)
+
+// parseLosslessUpgrade validates -lossless-upgrade
+func parseLosslessUpgrade(mode string) error {
+	switch mode {
+	case LosslessUpgradeOff, LosslessUpgradeAugment, LosslessUpgradeReplace:
+		return nil
+	}
+	return fmt.Errorf("unknown -lossless-upgrade %q (use %s, %s, or %s)", mode,
+		LosslessUpgradeOff, LosslessUpgradeAugment, LosslessUpgradeReplace)
+}
+
+// awaitsLosslessUpgrade reports whether a cataloged source is an MP3
+// download that a run with these options upgrades once FLAC is available
+func awaitsLosslessUpgrade(cfg *Config, src *CatalogSource) bool {
+	return src.Format == "mp3" && cfg.LosslessUpgrade != LosslessUpgradeOff && cfg.Format != "mp3"
+}
+
+// isLosslessUpgrade reports whether a source the catalog records as an MP3
+// download (e.g. from the fallback when archive.org had no FLAC yet) now has
+// FLAC files planned, so they should be fetched even if another machine
+// downloaded the MP3s
+func isLosslessUpgrade(cfg *Config, store CatalogStore, sp *SourcePlan) bool {
+	if cfg.LosslessUpgrade == LosslessUpgradeOff || cfg.Format == "mp3" {
+		return false
+	}
+
+	src, ok, err := store.LookupSource(sp.Identifier)
+	if err != nil || !ok || !awaitsLosslessUpgrade(cfg, src) {
+		return false
+	}
+	for _, file := range sp.Files {
+		if classifyFile(file) == ClassAudio && isFlacFile(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// finishLosslessUpgrade removes the MP3 files of a source whose FLAC files
+// have all been downloaded, when -lossless-upgrade is replace
+func finishLosslessUpgrade(cfg *Config, sp *SourcePlan) {
+	if cfg.LosslessUpgrade != LosslessUpgradeReplace || cfg.Format != "flac" {
+		return
+	}
+
+	manifest, err := loadManifest(sp.ShowDir)
+	if err != nil {
+		logger.Warn("Failed to load manifest of %s: %v", sp.ShowDir, err)
+		return
+	}
+
+	// Only replace once every planned file is in
+	planned := make(map[string]bool, len(sp.Files))
+	for _, file := range sp.Files {
+		fileName, _ := localFileNames(file, cfg)
+		if _, ok := manifest.Lookup(fileName); !ok {
+			logger.Warn("Keeping the MP3 files of %s, the FLAC download is incomplete", sp.ShowDir)
+			return
+		}
+		planned[fileName] = true
+	}
+
+	removed := 0
+	for _, entry := range manifest.Entries() {
+		if planned[entry.LocalName] || !strings.EqualFold(filepath.Ext(entry.LocalName), ".mp3") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(sp.ShowDir, entry.LocalName)); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove %s: %v", entry.LocalName, err)
+			continue
+		}
+		manifest.Remove(entry.LocalName)
+		removed++
+	}
+
+	if removed > 0 {
+		if err := manifest.Save(); err != nil {
+			logger.Warn("Failed to save manifest: %v", err)
+		}
+		logger.Printf("    ✓ Replaced %d MP3 file(s) with FLAC\n", removed)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,107 +13,12 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/vbauerster/mpb/v8"
-	"github.com/vbauerster/mpb/v8/decor"
 )
 
-// Logger wraps multiple log.Logger instances for different log levels
-type Logger struct {
-	debug *log.Logger
-	info  *log.Logger
-	warn  *log.Logger
-	error *log.Logger
-	file  *os.File
-}
-
-// NewLogger creates a new logger with time-based log file
-func NewLogger() (*Logger, error) {
-	// Create logs directory if it doesn't exist
-	logsDir := "./logs"
-	if err := os.MkdirAll(logsDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create logs directory: %w", err)
-	}
-
-	// Generate time-based log filename
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	logFilePath := filepath.Join(logsDir, fmt.Sprintf("dead-dl_%s.log", timestamp))
-
-	// Create or open log file
-	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %w", err)
-	}
-
-	// Create multi-writer for both stdout and file
-	multiWriter := io.MultiWriter(os.Stdout, logFile)
-
-	return &Logger{
-		debug: log.New(multiWriter, "[DEBUG] ", log.Ldate|log.Ltime|log.Lmicroseconds),
-		info:  log.New(multiWriter, "[INFO]  ", log.Ldate|log.Ltime),
-		warn:  log.New(multiWriter, "[WARN]  ", log.Ldate|log.Ltime),
-		error: log.New(multiWriter, "[ERROR] ", log.Ldate|log.Ltime|log.Lshortfile),
-		file:  logFile,
-	}, nil
-}
-
-// Close closes the log file
-func (l *Logger) Close() error {
-	if l.file != nil {
-		return l.file.Close()
-	}
-	return nil
-}
-
-// Debug logs debug messages
-func (l *Logger) Debug(format string, v ...interface{}) {
-	l.debug.Printf(format, v...)
-}
-
-// Info logs info messages
-func (l *Logger) Info(format string, v ...interface{}) {
-	l.info.Printf(format, v...)
-}
-
-// Warn logs warning messages
-func (l *Logger) Warn(format string, v ...interface{}) {
-	l.warn.Printf(format, v...)
-}
-
-// Error logs error messages
-func (l *Logger) Error(format string, v ...interface{}) {
-	l.error.Printf(format, v...)
-}
-
-// Fatal logs error messages and exits
-func (l *Logger) Fatal(format string, v ...interface{}) {
-	l.error.Printf(format, v...)
-	os.Exit(1)
-}
-
-// Printf logs a formatted message to both console and file
-func (l *Logger) Printf(format string, v ...interface{}) {
-	msg := fmt.Sprintf(format, v...)
-	fmt.Print(msg)
-	if l.file != nil {
-		l.file.WriteString(msg)
-	}
-}
-
-// Println logs a message with newline to both console and file
-func (l *Logger) Println(format string, v ...interface{}) {
-	msg := fmt.Sprintf(format, v...)
-	fmt.Println(msg)
-	if l.file != nil {
-		l.file.WriteString(msg + "\n")
-	}
-}
-
-var logger *Logger
-
 const (
 	RelistenAPIBase = "https://api.relisten.net/api/v2"
 	ArchiveAPIBase  = "https://archive.org"
@@ -119,8 +28,15 @@ type Show struct {
 	Date        string   `json:"date"`
 	DisplayDate string   `json:"display_date"`
 	UUID        string   `json:"uuid"`
+	EraID       int      `json:"era_id,omitempty"`
 	Venue       Venue    `json:"venue"`
 	Sources     []Source `json:"sources,omitempty"`
+	Provider    string   `json:"provider,omitempty"` // Who served the show's sources: relisten, cache, or archive.org
+
+	SourceUpdatedAt string  `json:"most_recent_source_updated_at,omitempty"`
+	SourceCount     int     `json:"source_count,omitempty"`
+	HasSoundboard   bool    `json:"has_soundboard_source,omitempty"`
+	AvgRating       float64 `json:"avg_rating,omitempty"`
 }
 
 type Venue struct {
@@ -132,6 +48,14 @@ type ShowsResponse struct {
 	Shows []Show `json:"shows"`
 }
 
+// ArtistYear is an entry of a band's year listing
+type ArtistYear struct {
+	Year        string  `json:"year"`
+	ShowCount   int     `json:"show_count"`
+	SourceCount int     `json:"source_count"`
+	AvgRating   float64 `json:"avg_rating"`
+}
+
 type Source struct {
 	ReviewCount        int64       `json:"review_count"`
 	Sets               []Set       `json:"sets"`
@@ -212,361 +136,1151 @@ type Link struct {
 type ShowDetail struct {
 	DisplayDate string   `json:"display_date"`
 	Sources     []Source `json:"sources"`
+	Provider    string   `json:"-"`
 }
 
 type ArchiveMetadata struct {
 	Metadata struct {
-		Identifier string `json:"identifier"`
+		Identifier string       `json:"identifier"`
+		Title      searchString `json:"title"`
+		Date       string       `json:"date"`
+		Venue      searchString `json:"venue"`
+		Coverage   searchString `json:"coverage"`
 	} `json:"metadata"`
 	Files []ArchiveFile `json:"files"`
 }
 
 type ArchiveFile struct {
-	Name   string `json:"name"`
-	Format string `json:"format"`
-	Size   string `json:"size"`
-	Title  string `json:"title"`
-	Track  string `json:"track"`
+	Name     string `json:"name"`
+	Format   string `json:"format"`
+	Size     string `json:"size"`
+	Title    string `json:"title"`
+	Track    string `json:"track"`
+	MD5      string `json:"md5"`
+	Source   string `json:"source"`   // "original", "derivative", or "metadata"
+	Original string `json:"original"` // For derivatives, the file they were generated from
+
+	// Not archive.org fields: where the file goes in the set list, kept in the run plan
+	SetDir      string `json:"dead_dl_set_dir,omitempty"`      // Set folder for -split-sets
+	EncoreTrack int    `json:"dead_dl_encore_track,omitempty"` // Encore number for -encore-names
+	NameSuffix  int    `json:"dead_dl_name_suffix,omitempty"`  // Number that sets the file apart from another with the same local name
+	URL         string `json:"dead_dl_url,omitempty"`          // relisten.org track URL the file is downloaded from instead of archive.org
+}
+
+// Config holds the options for a download run
+type Config struct {
+	Band             string        `json:"band"`
+	Year             string        `json:"year"`
+	Month            string        `json:"month,omitempty"`
+	From             string        `json:"from,omitempty"`
+	To               string        `json:"to,omitempty"`
+	Show             string        `json:"show,omitempty"`
+	Tour             string        `json:"tour,omitempty"`
+	Venue            string        `json:"venue,omitempty"`
+	Era              string        `json:"era,omitempty"`
+	MinRating        float64       `json:"min_rating,omitempty"`
+	SoundboardOnly   bool          `json:"sbd_only,omitempty"`
+	PreferSBD        bool          `json:"prefer_sbd,omitempty"`
+	MinReviews       int           `json:"min_reviews,omitempty"`
+	WeightedRating   bool          `json:"weighted_rating,omitempty"`
+	Taper            string        `json:"taper,omitempty"`
+	ExcludeLineage   string        `json:"exclude_lineage,omitempty"`
+	APIRate          float64       `json:"api_rate,omitempty"`
+	Rate             ByteSize      `json:"rate,omitempty"`
+	PerFileRate      ByteSize      `json:"per_file_rate,omitempty"`
+	PauseBetween     time.Duration `json:"pause_between_shows,omitempty"`
+	OnlyBetween      string        `json:"only_between,omitempty"`
+	FilterHook       string        `json:"filter_hook,omitempty"`
+	OnFileComplete   string        `json:"on_file_complete,omitempty"`
+	OnShowComplete   string        `json:"on_show_complete,omitempty"`
+	OnRunComplete    string        `json:"on_run_complete,omitempty"`
+	PushgatewayURL   string        `json:"pushgateway_url,omitempty"`
+	MetadataCache    string        `json:"metadata_cache,omitempty"`
+	Collection       string        `json:"archive_collection,omitempty"`
+	Offline          bool          `json:"-"`
+	DryRun           bool          `json:"-"`
+	Sync             bool          `json:"sync,omitempty"` // Set by dead-dl sync
+	Fingerprint      bool          `json:"fingerprint,omitempty"`
+	NoTag            bool          `json:"no_tag,omitempty"`
+	CoverArt         bool          `json:"cover_art,omitempty"`
+	TrimSilence      bool          `json:"trim_silence,omitempty"`
+	Transcode        string        `json:"transcode,omitempty"`
+	TranscodeDir     string        `json:"transcode_dir,omitempty"`
+	TranscodeJobs    int           `json:"transcode_jobs,omitempty"`
+	Latest           int           `json:"latest,omitempty"`
+	SilenceThreshold string        `json:"silence_threshold,omitempty"`
+	SilenceMin       time.Duration `json:"silence_min,omitempty"`
+	RecordFixtures   string        `json:"-"`
+	ReplayFixtures   string        `json:"-"`
+	OutputDir        string        `json:"output_dir"`
+	OutputTemplate   string        `json:"output_template,omitempty"`
+	NoPlaylist       bool          `json:"no_playlist,omitempty"`
+	NoInfo           bool          `json:"no_info,omitempty"`
+	Format           string        `json:"format"`
+	FileSource       string        `json:"file_source,omitempty"`
+	HighestRated     bool          `json:"highest_rated"`
+	Concurrency      int           `json:"concurrency"`
+	ParallelShows    int           `json:"parallel_shows,omitempty"`
+	StateFile        string        `json:"state_file"`
+	StaleLock        time.Duration `json:"stale_lock"`
+	MaxRetries       int           `json:"max_retries_per_file"`
+	MaxFailures      int           `json:"max_failures"`
+	MinSpeed         ByteSize      `json:"min_speed"`
+	StallTime        time.Duration `json:"stall_time"`
+	FileTimeout      time.Duration `json:"file_timeout"`
+	UserAgent        string        `json:"user_agent,omitempty"`
+	HTTPRetries      int           `json:"http_retries"`
+	Progress         string        `json:"progress"`
+	ProgressSocket   string        `json:"progress_socket"`
+	ASCII            bool          `json:"ascii,omitempty"`
+	LogLevel         string        `json:"log_level,omitempty"`
+	LogFormat        string        `json:"log_format,omitempty"`
+	Quiet            bool          `json:"quiet,omitempty"`
+	LogMaxSize       ByteSize      `json:"log_max_size,omitempty"`
+	LogKeep          int           `json:"log_keep,omitempty"`
+	Preallocate      bool          `json:"preallocate"`
+	Fsync            bool          `json:"fsync"`
+	PreserveDirs     bool          `json:"preserve_structure"`
+	Include          string        `json:"include"`
+	AudioExts        string        `json:"audio_exts,omitempty"`
+	EtreeExtras      bool          `json:"etree_extras"`
+	OriginalsOnly    bool          `json:"originals_only"`
+	MaxFileSize      ByteSize      `json:"max_file_size"`
+	IncludeVideo     bool          `json:"include_video,omitempty"`
+	VideoFormats     string        `json:"video_formats,omitempty"`
+	MaxVideoSize     ByteSize      `json:"max_video_size,omitempty"`
+	Quota            ByteSize      `json:"quota_per_month"`
+	Catalog          string        `json:"catalog"`
+	LosslessUpgrade  string        `json:"lossless_upgrade"`
+	SoundboardPolicy string        `json:"sbd_policy"`
+	SplitSets        bool          `json:"split_sets"`
+	EncoreNames      bool          `json:"encore_names"`
+	Yes              bool          `json:"-"`
 }
 
 func main() {
-	band := flag.String("band", "grateful-dead", "Band slug (e.g., grateful-dead)")
-	year := flag.String("year", "", "Year to download (required)")
-	outputDir := flag.String("output", "./downloads", "Output directory for downloads")
-	format := flag.String("format", "mp3", "Preferred format: flac, mp3, or both")
-	highestRated := flag.Bool("highest-rated", false, "Download only the highest rated source per show")
-	concurrency := flag.Int("concurrency", 10, "Number of concurrent downloads")
-	flag.Parse()
-
-	// Initialize logger with time-based log file
-	var err error
-	logger, err = NewLogger()
-	if err != nil {
-		log.Fatalf("Failed to initialize logger: %v", err)
+	// Subcommands are checked before flag parsing so they can take their own arguments
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "resume":
+			runResume(os.Args[2:])
+			return
+		case "top":
+			runTop(os.Args[2:])
+			return
+		case "service":
+			runService(os.Args[2:])
+			return
+		case "stats":
+			runStats(os.Args[2:])
+			return
+		case "catalog":
+			runCatalog(os.Args[2:])
+			return
+		case "upgrade":
+			runUpgrade(os.Args[2:])
+			return
+		case "gc":
+			runGC(os.Args[2:])
+			return
+		case "dedup":
+			runDedup(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "today":
+			runToday(os.Args[2:])
+			return
+		case "sync":
+			runSync(os.Args[2:])
+			return
+		case "run":
+			runJobs(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "artists":
+			runArtists(os.Args[2:])
+			return
+		case "years":
+			runYears(os.Args[2:])
+			return
+		case "shows":
+			runShows(os.Args[2:])
+			return
+		case "sources":
+			runSources(os.Args[2:])
+			return
+		case "download":
+			runDownloadCommand(os.Args[2:])
+			return
+		}
 	}
+
+	// Without a subcommand, the flags are those of download
+	runDownloadCommand(os.Args[1:])
+}
+
+// runDownloadCommand handles `dead-dl download`, which downloads the shows
+// its flags select
+func runDownloadCommand(args []string) {
+	cfg := &Config{}
+	registerFlags(flag.CommandLine, cfg)
+	flag.CommandLine.Usage = usage
+	flag.CommandLine.Parse(args)
+
+	initRunLogger(cfg)
 	defer logger.Close()
 
 	logger.Info("=== Dead-DL Started ===")
 	logger.Info("Configuration: band=%s, year=%s, format=%s, output=%s, highest-rated=%v",
-		*band, *year, *format, *outputDir, *highestRated)
+		cfg.Band, cfg.Year, cfg.Format, cfg.OutputDir, cfg.HighestRated)
+
+	if !hasRunTarget(cfg) {
+		logger.Fatal("Year is required. Use -year, -date, -from, -to, -show, -tour, or -era flag")
+	}
+
+	validateConfig(cfg)
 
-	if *year == "" {
-		logger.Fatal("Year is required. Use -year flag")
+	if err := setupEvents(cfg); err != nil {
+		logger.Fatal("Failed to set up progress events: %v", err)
 	}
+	defer events.Close()
+
+	if err := startRun(cfg); err != nil {
+		logger.Fatal("%v", err)
+	}
+}
+
+// usage prints the commands of dead-dl and the flags of download
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: dead-dl [download] -band <band> -year <year> [flags]
+       dead-dl <command> [flags] [arguments]
+
+Browsing relisten.org:
+  artists                   List the artists
+  years <band>              List the years a band has shows in
+  shows <band> <year>       List the shows of a year
+  sources <band> <date>     List the sources of a show
+  today                     List or download the shows played on this day
+
+Downloading:
+  download                  Download shows (the default command)
+  sync                      Download sources added or updated since the last sync
+  resume <statefile>        Continue an interrupted run
+  run <jobs.yaml>           Run a batch of downloads
+  upgrade                   Download better sources of downloaded shows
+
+Managing downloads:
+  verify, gc, dedup, catalog, stats, serve, service, top
+
+Run dead-dl <command> -h for the flags of a command. Flags of download:
+`)
+	flag.CommandLine.PrintDefaults()
+}
 
-	logger.Debug("Creating output directory: %s", *outputDir)
-	if err := os.MkdirAll(*outputDir, 0755); err != nil {
-		logger.Fatal("Failed to create output directory %s: %v", *outputDir, err)
+// startRun fetches the shows a run covers, writes its state file, and
+// downloads them. It returns an error if the run couldn't start.
+func startRun(cfg *Config) error {
+	if cfg.Band == AllArtists {
+		return runAllArtists(cfg)
 	}
 
-	logger.Info("Fetching shows for %s in %s...", *band, *year)
-	shows, err := fetchShows(*band, *year)
+	shows, err := fetchRunShows(cfg)
 	if err != nil {
-		logger.Fatal("Failed to fetch shows: %v", err)
+		return fmt.Errorf("failed to fetch shows: %w", err)
 	}
+	logger.Info("Found %d shows for %s in %s", len(shows), cfg.Band, runLabel(cfg))
 
-	logger.Info("Found %d shows for %s in %s", len(shows), *band, *year)
-	logger.Println("") // Blank line for readability
+	if cfg.StateFile == "" {
+		cfg.StateFile = defaultStateFile(cfg)
+	}
+
+	logger.Debug("Creating output directory: %s", cfg.OutputDir)
+	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", cfg.OutputDir, err)
+	}
+
+	state := newRunState(cfg.StateFile, *cfg, shows)
+	if err := state.Save(); err != nil {
+		logger.Warn("Failed to write state file %s: %v", cfg.StateFile, err)
+	}
 
-	for i, show := range shows {
-		logger.Printf("[%d/%d] Processing show: %s at %s, %s\n",
-			i+1, len(shows), show.DisplayDate, show.Venue.Name, show.Venue.Location)
+	return runDownload(cfg, state)
+}
 
-		// Fetch full show details which includes sources
-		showDetail, err := fetchShowDetail(*band, show.DisplayDate)
+// hasRunTarget reports whether a run says what to download
+func hasRunTarget(cfg *Config) bool {
+	return cfg.Year != "" || cfg.From != "" || cfg.To != "" || cfg.Show != "" ||
+		cfg.Tour != "" || cfg.Era != "" || cfg.Band == AllArtists
+}
+
+// fetchRunShows lists the shows a run covers: the -show show, those of
+// -tour, or of the -year years, narrowed down by -era, -month, -from, -to,
+// and -venue
+func fetchRunShows(cfg *Config) ([]Show, error) {
+	if cfg.Show != "" {
+		show, err := findShow(cfg)
 		if err != nil {
-			logger.Error("Failed to fetch show details for %s: %v", show.DisplayDate, err)
-			continue
+			return nil, err
 		}
+		return []Show{*show}, nil
+	}
 
-		if len(showDetail.Sources) == 0 {
-			logger.Printf("  No sources found for this show\n")
-			continue
+	var era *Era
+	if cfg.Era != "" {
+		var err error
+		if era, err = findEra(cfg.Band, cfg.Era); err != nil {
+			return nil, err
 		}
+		cfg.Era = era.Name
+	}
 
-		if len(showDetail.Sources) > 1 && *highestRated {
-			// Select highest rated source
-			bestSource := fetchHighestRatedSource(showDetail.Sources)
-			if bestSource == nil {
-				logger.Printf("  No valid sources found for this show\n")
-				continue
+	var shows []Show
+	if cfg.Tour != "" {
+		tour, err := findTour(cfg.Band, cfg.Tour)
+		if err != nil {
+			return nil, err
+		}
+		// The resolved name is kept, so resumed runs and directories use it
+		cfg.Tour = tour.Name
+		logger.Info("Fetching shows of %s tour %s...", cfg.Band, tour.Name)
+		if shows, err = fetchTourShows(cfg.Band, tour.Slug); err != nil {
+			return nil, err
+		}
+		if cfg.Year != "" {
+			var kept []Show
+			for _, show := range shows {
+				if matchesYears(cfg.Year, dateYear(show.DisplayDate)) {
+					kept = append(kept, show)
+				}
 			}
-			showDetail.Sources = []Source{*bestSource}
-			logger.Printf("  Selected highest rated source with avg rating %.2f\n", bestSource.AvgRating)
+			shows = kept
 		}
-
-		for j, source := range showDetail.Sources {
-			logger.Printf("  Source [%d/%d]: ", j+1, len(showDetail.Sources))
-
-			// Find archive.org link
-			var archiveURL string
-			for _, link := range source.Links {
-				if strings.Contains(link.URL, "archive.org") {
-					archiveURL = link.URL
-					break
+	} else {
+		years, _ := parseYears(cfg.Year)
+		if cfg.Year == "" {
+			// An era's shows can be in any year, -from and -to can span years,
+			// and -band all covers every year
+			all, err := fetchYears(cfg.Band)
+			if err != nil {
+				return nil, err
+			}
+			for _, year := range all {
+				if yearInRange(year.Year, cfg.From, cfg.To) {
+					years = append(years, year.Year)
 				}
 			}
-
-			if archiveURL == "" {
-				logger.Println("No archive.org link found")
-				continue
+		}
+		for _, year := range years {
+			logger.Info("Fetching shows for %s in %s...", cfg.Band, year)
+			yearShows, err := fetchShows(cfg.Band, year)
+			if err != nil {
+				return nil, err
 			}
+			shows = append(shows, yearShows...)
+		}
+	}
 
-			// Extract identifier from URL
-			parts := strings.Split(archiveURL, "/")
-			identifier := parts[len(parts)-1]
-			logger.Printf("archive.org identifier: %s\n", identifier)
+	if era != nil {
+		shows = filterEra(shows, era.ID)
+	}
 
-			// Create show directory
-			showDir := filepath.Join(*outputDir, *band, *year, show.DisplayDate)
-			if j > 0 {
-				showDir = fmt.Sprintf("%s-source%d", showDir, j+1)
-			}
-			if err := os.MkdirAll(showDir, 0755); err != nil {
-				logger.Error("Failed to create show directory: %v", err)
-				continue
-			}
+	shows = filterMonth(shows, cfg.Month)
+	shows = filterDates(shows, cfg.From, cfg.To)
+	return filterVenue(shows, cfg.Venue), nil
+}
 
-			// Download files
-			if err := downloadArchiveFiles(identifier, showDir, *format, *concurrency); err != nil {
-				logger.Error("Failed to download files: %v", err)
-				continue
+// registerFlags registers the download options on fs; the main command and
+// subcommands that download share them
+func registerFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Band, "band", "grateful-dead", "Band slug (e.g., grateful-dead), or all for every relisten.org artist")
+	fs.StringVar(&cfg.Year, "year", "", "Year to download (required); also a range like 1972-1977 or a list like 1972,1974")
+	fs.Func("date", "Show date as YYYY-MM-DD, e.g. 1977-05-08, or month as YYYY-MM, e.g. 1972-08 (sets -year and -month)", func(date string) error {
+		if showDatePattern.MatchString(date) {
+			if err := checkShowDate("-date", date); err != nil {
+				return err
 			}
+			cfg.Year, cfg.From, cfg.To = date[:4], date, date
+			return nil
+		}
+		year, month, err := parseMonthDate(date)
+		if err != nil {
+			return err
+		}
+		cfg.Year, cfg.Month = year, month
+		return nil
+	})
+	fs.StringVar(&cfg.Month, "month", "", "Only download shows from this month of the year, e.g. 08")
+	fs.StringVar(&cfg.From, "from", "", "Only download shows on or after this date, YYYY-MM-DD")
+	fs.StringVar(&cfg.To, "to", "", "Only download shows on or before this date, YYYY-MM-DD")
+	fs.StringVar(&cfg.Show, "show", "", "Download a single show by archive.org identifier (only that source) or relisten.org show UUID")
+	fs.StringVar(&cfg.Tour, "tour", "", "Download a whole tour by name or slug, e.g. \"Europe '72\", into a folder named after it")
+	fs.StringVar(&cfg.Era, "era", "", "Only download shows from this era of the band, as defined by relisten.org, e.g. \"Wall of Sound\"")
+	fs.StringVar(&cfg.Venue, "venue", "", "Only download shows at venues whose name contains this, e.g. Winterland for a venue run")
+	fs.StringVar(&cfg.OutputDir, "output", "./downloads", "Output directory for downloads")
+	fs.StringVar(&cfg.OutputTemplate, "output-template", "", "Layout of show directories and audio files under -output, e.g. \"{band}/{year}/{date} - {venue} - {city}/{set}/{track_num} {title}\" (default: {band}/{year}/{date})")
+	fs.BoolVar(&cfg.NoPlaylist, "no-playlist", false, "Don't write an .m3u8 playlist of the audio files into each show directory")
+	fs.BoolVar(&cfg.NoInfo, "no-info", false, "Don't write an info.txt with the source's description, taper notes, and lineage into each show directory")
+	fs.StringVar(&cfg.Format, "format", "mp3", "Preferred format: flac, mp3, or both")
+	fs.StringVar(&cfg.FileSource, "source", FileSourceAuto, "Where files are downloaded from: auto (archive.org, or relisten.org's track URLs for sources not on archive.org), archive, or relisten")
+	fs.BoolVar(&cfg.HighestRated, "highest-rated", false, "Download only the highest rated source per show")
+	fs.Float64Var(&cfg.MinRating, "min-rating", 0, "Skip sources with an average rating below this (unrated sources are kept)")
+	fs.BoolVar(&cfg.SoundboardOnly, "sbd-only", false, "Only download soundboard sources")
+	fs.BoolVar(&cfg.PreferSBD, "prefer-sbd", false, "Pick a soundboard over any audience recording, rated or not (implies -highest-rated)")
+	fs.IntVar(&cfg.MinReviews, "min-reviews", 0, "Skip sources with fewer reviews than this")
+	fs.BoolVar(&cfg.WeightedRating, "use-weighted-rating", false, "Rank sources by a rating that trusts few reviews less, so a 5-star source with two reviews doesn't beat a well-reviewed one (implies -highest-rated)")
+	fs.StringVar(&cfg.Taper, "taper", "", "Only download sources whose taper or transferrer contains this, e.g. Miller")
+	fs.StringVar(&cfg.ExcludeLineage, "exclude-lineage", "", "Skip sources whose lineage or source description matches this regular expression, e.g. \"cassette|mp3\"")
+	fs.Float64Var(&cfg.APIRate, "api-rate", 0, "Maximum relisten.org and archive.org API requests per second (0 = no limit; -band all defaults to 2)")
+	fs.StringVar(&cfg.Collection, "archive-collection", "", "archive.org collection searched for shows while relisten.org is down (default: the band in CamelCase, e.g. GratefulDead)")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 10, "Number of concurrent downloads per show")
+	fs.IntVar(&cfg.ParallelShows, "parallel-shows", 1, "Number of shows downloaded at the same time, each with -concurrency transfers")
+	fs.StringVar(&cfg.StateFile, "state-file", "", "Path of the run-state file used by resume (default: <output>/.dead-dl-<band>-<year>.state.json)")
+	fs.DurationVar(&cfg.StaleLock, "stale-lock", DefaultStaleLock, "Age after which another instance's show lock is considered stale")
+	fs.IntVar(&cfg.MaxRetries, "max-retries-per-file", 2, "Number of times a failed file download is retried")
+	fs.IntVar(&cfg.MaxFailures, "max-failures", 0, "Abort the run after this many failed files (0 = never abort)")
+	cfg.MinSpeed = 10 << 10
+	fs.Var(&cfg.MinSpeed, "min-speed", "Minimum transfer speed (e.g. 10K); slower transfers are aborted and retried (0 = disabled)")
+	fs.Var(&cfg.Rate, "rate", "Overall download speed limit across all transfers, e.g. 5M (0 = no limit)")
+	fs.Var(&cfg.Rate, "limit-rate", "Same as -rate")
+	fs.DurationVar(&cfg.PauseBetween, "pause-between-shows", 0, "Time to wait after each show that downloaded something before starting the next, e.g. 5m")
+	fs.StringVar(&cfg.OnlyBetween, "only-between", "", "Only start downloads between these local times, e.g. 01:00-07:00 or 22:00-06:00; outside them the run waits")
+	fs.Var(&cfg.PerFileRate, "per-file-rate", "Download speed limit of each transfer, e.g. 1M, so one large file can't take all the bandwidth (0 = no limit)")
+	fs.StringVar(&cfg.UserAgent, "user-agent", DefaultUserAgent, "User-Agent sent with every request to relisten.org and archive.org")
+	fs.IntVar(&cfg.HTTPRetries, "http-retries", 3, "Times a request answered with 429 or a server error, or that failed to connect, is retried with backoff before it counts as failed")
+	fs.DurationVar(&cfg.StallTime, "stall-time", 60*time.Second, "How long a transfer may stay below -min-speed before it is aborted")
+	fs.DurationVar(&cfg.FileTimeout, "file-timeout", 0, "Maximum time a single file download may take, e.g. 30m (0 = no limit)")
+	fs.StringVar(&cfg.Progress, "progress", ProgressAuto, "Progress output: bar, plain, or none (default: bar on a terminal, plain otherwise)")
+	fs.StringVar(&cfg.ProgressSocket, "progress-socket", "", "Unix socket path on which JSON progress events are streamed to connected clients")
+	fs.BoolVar(&cfg.ASCII, "ascii", false, "Print plain ASCII status lines without symbols or redraws, for screen readers and simple terminals (implies -progress plain)")
+	fs.StringVar(&cfg.LogLevel, "log-level", LevelInfo, "Least severe messages logged: debug, info, warn, or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", LogFormatText, "Log format of the console and log file: text, or json for one JSON object per message")
+	fs.BoolVar(&cfg.Quiet, "quiet", false, "Only print progress bars and errors on the console; the log file is written as usual")
+	cfg.LogMaxSize = DefaultLogMaxSize
+	fs.Var(&cfg.LogMaxSize, "log-max-size", "Size at which the log file is rotated into a new one, e.g. 50M (0 = never)")
+	fs.IntVar(&cfg.LogKeep, "log-keep", DefaultLogKeep, "Number of log files kept in ./logs; older ones are removed (0 = keep all)")
+	fs.BoolVar(&cfg.Preallocate, "preallocate", true, "Preallocate files when the remote size is known to reduce fragmentation and fail fast when the disk is full")
+	fs.BoolVar(&cfg.Fsync, "fsync", false, "Sync each completed file and its directory to disk before recording it as done")
+	fs.BoolVar(&cfg.PreserveDirs, "preserve-structure", false, "Recreate the archive item's subdirectories (e.g. per-disc folders) instead of flattening files")
+	fs.StringVar(&cfg.Include, "include", ClassAudio, "Comma-separated file classes to download: audio, art, text, checksums")
+	fs.StringVar(&cfg.AudioExts, "audio-exts", DefaultAudioExts, "Comma-separated file extensions that count as audio")
+	fs.BoolVar(&cfg.EtreeExtras, "etree-extras", false, "Mirror the item's original .txt, .md5, .ffp, and .st5 files with their names untouched")
+	fs.BoolVar(&cfg.OriginalsOnly, "originals-only", false, "Skip files archive.org derived from other files (e.g. MP3s generated from a FLAC master)")
+	fs.Var(&cfg.MaxFileSize, "max-file-size", "Skip files larger than this size, e.g. 500M (0 = no limit)")
+	fs.BoolVar(&cfg.IncludeVideo, "include-video", false, "Also download video of the show, for items that include footage")
+	fs.StringVar(&cfg.VideoFormats, "video-format", DefaultVideoFormats, "Comma-separated video formats -include-video downloads, e.g. mp4,mpeg, or any")
+	fs.Var(&cfg.MaxVideoSize, "max-video-size", "Skip video files larger than this size, e.g. 2G (0 = no limit)")
+	fs.BoolVar(&cfg.SplitSets, "split-sets", false, "Organize each show's audio into Set 1/, Set 2/, Encore/ subfolders using Relisten's set list")
+	fs.BoolVar(&cfg.EncoreNames, "encore-names", false, "Number encore tracks e01, e02, ... in file names, as in etree naming")
+	fs.BoolVar(&cfg.NoTag, "no-tag", false, "Leave downloaded MP3 and FLAC files untagged instead of writing artist, album, track, set, and date tags")
+	fs.BoolVar(&cfg.CoverArt, "cover-art", false, "Embed the archive.org item's cover image into tagged tracks")
+	fs.BoolVar(&cfg.Fingerprint, "fingerprint", false, "Compute AcoustID fingerprints of audio files with fpcalc and keep them in the manifest and catalog")
+	fs.IntVar(&cfg.Latest, "latest", 0, "Keep symlinks to this many most recently completed shows in <output>/_latest/ (0 = off)")
+	fs.StringVar(&cfg.Transcode, "transcode", "", "Convert downloaded lossless files, e.g. opus:128, mp3:v0, or mp3:320, into a parallel tree while the next shows download (needs ffmpeg)")
+	fs.StringVar(&cfg.TranscodeDir, "transcode-dir", "", "Root of the tree -transcode writes to (default: the output directory with the codec appended, e.g. ./downloads-opus)")
+	fs.IntVar(&cfg.TranscodeJobs, "transcode-jobs", max(runtime.NumCPU()/2, 1), "Number of files -transcode converts at the same time")
+	fs.BoolVar(&cfg.TrimSilence, "trim-silence", false, "Write listening copies with long leading and trailing silence trimmed into each show's trimmed/ folder (needs ffmpeg)")
+	fs.StringVar(&cfg.SilenceThreshold, "silence-threshold", "-50dB", "Level below which audio counts as silence for -trim-silence")
+	fs.DurationVar(&cfg.SilenceMin, "silence-min", 2*time.Second, "Shortest silence -trim-silence removes")
+	fs.StringVar(&cfg.MetadataCache, "metadata-cache", "", "Directory API responses are cached in for -offline (default <output>/.dead-dl/cache)")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "List the shows, sources, and files the run would download with their estimated size, without downloading or writing anything")
+	fs.BoolVar(&cfg.Offline, "offline", false, "Plan the run from the metadata cache and catalog only, without internet, and write the plan to the state file for resume")
+	fs.StringVar(&cfg.RecordFixtures, "record-fixtures", "", "Record sanitized API responses into this directory, e.g. testdata/fixtures")
+	fs.StringVar(&cfg.ReplayFixtures, "replay-fixtures", "", "Serve every HTTP request from fixtures in this directory instead of the network")
+	fs.StringVar(&cfg.Catalog, "catalog", "", "Catalog file, e.g. on a network share used by several machines, or a postgres:// URL (default <output>/.dead-dl/catalog.json)")
+	fs.StringVar(&cfg.LosslessUpgrade, "lossless-upgrade", LosslessUpgradeAugment, "When FLAC appears for a source downloaded as MP3: augment adds the FLAC files, replace also deletes the MP3s (with -format flac), off ignores it")
+	fs.StringVar(&cfg.SoundboardPolicy, "sbd-policy", SoundboardOff, "When a soundboard appears for a show we only have audience recordings of: download it, archive-aud to also move the audience recordings into an aud/ folder, or off")
+	fs.StringVar(&cfg.FilterHook, "filter-hook", "", "Command that gets each source as JSON on stdin and prints accept or reject")
+	fs.StringVar(&cfg.OnFileComplete, "on-file-complete", "", "Command to run after each downloaded file, e.g. \"notify.sh {{.File}}\"")
+	fs.StringVar(&cfg.OnShowComplete, "on-show-complete", "", "Command to run after each show directory is complete, e.g. \"/path/script.sh {{.ShowDir}}\"")
+	fs.StringVar(&cfg.OnRunComplete, "on-run-complete", "", "Command to run when the run ends, e.g. \"curl -X POST http://plex:32400/library/sections/1/refresh\"")
+	fs.StringVar(&cfg.PushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway URL the run pushes its final metrics to, e.g. http://pushgateway:9091")
+	fs.Var(&cfg.Quota, "quota", "Monthly download quota, e.g. 300G; new downloads pause once it is used up (0 = no limit)")
+	fs.BoolVar(&cfg.Yes, "yes", false, "Don't ask for confirmation before downloading")
+}
 
-			logger.Printf("    ✓ Downloaded to %s\n", showDir)
+// validateConfig exits on option values that can't work and applies the
+// process-wide ones
+func validateConfig(cfg *Config) {
+	if cfg.APIRate < 0 {
+		logger.Fatal("-api-rate can't be negative")
+	}
+	if cfg.PerFileRate > 0 && cfg.PerFileRate < cfg.MinSpeed {
+		logger.Fatal("-per-file-rate %s is below -min-speed %s, every transfer would count as stalled", cfg.PerFileRate, cfg.MinSpeed)
+	}
+	if cfg.Concurrency < 1 {
+		logger.Fatal("-concurrency must be at least 1")
+	}
+	if cfg.ParallelShows < 1 {
+		logger.Fatal("-parallel-shows must be at least 1")
+	}
+	if transfers := cfg.Concurrency * max(cfg.ParallelShows, 1); cfg.Rate > 0 && cfg.Rate/ByteSize(transfers) < cfg.MinSpeed {
+		logger.Warn("-rate %s shared by %d transfers may fall below -min-speed %s and look like stalls", cfg.Rate, transfers, cfg.MinSpeed)
+	}
+	if cfg.PauseBetween < 0 {
+		logger.Fatal("-pause-between-shows can't be negative")
+	}
+	if _, err := parseTimeWindow(cfg.OnlyBetween); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if cfg.HTTPRetries < 0 {
+		logger.Fatal("-http-retries can't be negative")
+	}
+	if cfg.RecordFixtures != "" && cfg.ReplayFixtures != "" {
+		logger.Fatal("-record-fixtures and -replay-fixtures can't be used together")
+	}
+	if err := checkFingerprinting(cfg); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if err := checkTrimming(cfg); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if err := checkTranscoding(cfg); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if cfg.Year != "" {
+		if _, err := parseYears(cfg.Year); err != nil {
+			logger.Fatal("%v", err)
+		}
+	}
+	if err := checkDateRange(cfg.From, cfg.To); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if cfg.MinReviews < 0 {
+		logger.Fatal("-min-reviews can't be negative")
+	}
+	if _, err := compileLineage(cfg.ExcludeLineage); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if _, err := parseOutputTemplate(cfg.OutputTemplate); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if cfg.PreferSBD || cfg.WeightedRating {
+		cfg.HighestRated = true
+	}
+	if cfg.Month != "" {
+		month, err := parseMonth(cfg.Month)
+		if err != nil {
+			logger.Fatal("%v", err)
 		}
+		cfg.Month = month
+	}
+	if _, err := resolveProgressMode(cfg.Progress); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if cfg.ASCII {
+		switch cfg.Progress {
+		case "", ProgressAuto:
+			cfg.Progress = ProgressPlain
+		case ProgressBar:
+			logger.Fatal("-ascii can't be used with -progress bar, which redraws its lines")
+		}
+	}
+	if _, err := parseIncludes(cfg.Include); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if err := setAudioExts(cfg.AudioExts); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if cfg.IncludeVideo {
+		if _, err := parseVideoFormats(cfg.VideoFormats); err != nil {
+			logger.Fatal("%v", err)
+		}
+	}
+	if err := parseLosslessUpgrade(cfg.LosslessUpgrade); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if err := parseFileSource(cfg.FileSource); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if err := parseSoundboardPolicy(cfg.SoundboardPolicy); err != nil {
+		logger.Fatal("%v", err)
+	}
+	if err := checkPushgatewayURL(cfg.PushgatewayURL); err != nil {
+		logger.Fatal("%v", err)
 	}
 
-	logger.Println("\nDownload complete!")
+	applyProcessSettings(cfg)
 }
 
-func fetchShows(band, year string) ([]Show, error) {
-	url := fmt.Sprintf("%s/artists/%s/years/%s", RelistenAPIBase, band, year)
-	resp, err := http.Get(url)
+// applyProcessSettings applies the options of a validated config that are
+// shared by everything the process downloads: the rate limits, HTTP client
+// options, metadata cache, fixtures, and audio extensions
+func applyProcessSettings(cfg *Config) {
+	apiLimiter.SetRate(cfg.APIRate)
+	downloadLimiter.SetRate(cfg.Rate)
+	setHTTPOptions(cfg)
+	apiCache = &metadataCache{dir: metadataCacheDir(cfg), offline: cfg.Offline, readOnly: cfg.DryRun}
+	archiveCollection = cfg.Collection
+	setupFixtures(cfg)          // Checked by validateConfig
+	setAudioExts(cfg.AudioExts) // Checked by validateConfig
+	logger.SetASCII(cfg.ASCII)
+}
+
+// processSettings identifies the options applyProcessSettings applies, and
+// the monthly quota setupQuota shares, so configs that would apply different
+// ones can be told apart
+func processSettings(cfg *Config) string {
+	quota := ""
+	if cfg.Quota > 0 {
+		quota = fmt.Sprint(cfg.Quota, catalogFile(cfg)) // Counted against its catalog
+	}
+	return fmt.Sprint(cfg.APIRate, cfg.Rate, cfg.UserAgent, cfg.HTTPRetries, metadataCacheDir(cfg), cfg.Offline,
+		cfg.DryRun, cfg.Collection, cfg.RecordFixtures, cfg.ReplayFixtures, cfg.AudioExts, cfg.ASCII, quota)
+}
+
+// runResume continues a run from a state file written by a previous, interrupted run
+func runResume(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: dead-dl resume <statefile>")
+		os.Exit(2)
+	}
+
+	state, err := loadRunState(args[0])
 	if err != nil {
-		return nil, err
+		log.Fatalf("Failed to load state file %s: %v", args[0], err)
 	}
-	defer resp.Body.Close()
+	cfg := state.Config
+	cfg.StateFile = args[0]
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	initRunLogger(&cfg)
+	defer logger.Close()
+
+	if err := setupEvents(&cfg); err != nil {
+		logger.Fatal("Failed to set up progress events: %v", err)
+	}
+	defer events.Close()
+	if cfg.StaleLock == 0 {
+		cfg.StaleLock = DefaultStaleLock
+	}
+	// Process-wide settings; the rest of validateConfig may reject older state files
+	apiLimiter.SetRate(cfg.APIRate)
+	downloadLimiter.SetRate(cfg.Rate)
+	setHTTPOptions(&cfg)
+	archiveCollection = cfg.Collection
+	logger.SetASCII(cfg.ASCII)
+	if err := setAudioExts(cfg.AudioExts); err != nil {
+		logger.Fatal("%v", err)
 	}
 
-	var showsResp ShowsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&showsResp); err != nil {
-		return nil, err
+	logger.Info("=== Dead-DL Resumed ===")
+	logger.Info("Configuration: band=%s, year=%s, format=%s, output=%s, highest-rated=%v",
+		cfg.Band, cfg.Year, cfg.Format, cfg.OutputDir, cfg.HighestRated)
+	logger.Info("Resuming with %d of %d shows already processed", len(state.Processed), len(state.Shows))
+
+	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+		logger.Fatal("Failed to create output directory %s: %v", cfg.OutputDir, err)
 	}
 
-	return showsResp.Shows, nil
+	if err := runDownload(&cfg, state); err != nil {
+		logger.Fatal("%v", err)
+	}
 }
 
-func fetchShowDetail(band, date string) (*ShowDetail, error) {
-	url := fmt.Sprintf("%s/artists/%s/shows/%s", RelistenAPIBase, band, date)
-	resp, err := http.Get(url)
+// runDownload plans every show in state that has not been processed yet,
+// asks for confirmation, and downloads the planned files. It returns an
+// error if the catalog can't be opened or the run hit -max-failures.
+func runDownload(cfg *Config, state *RunState) error {
+	summary := &RunSummary{}
+	started := time.Now()
+
+	store, err := openCatalog(cfg)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to open catalog: %w", err)
 	}
-	defer resp.Body.Close()
+	defer store.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	if !cfg.Offline && !cfg.DryRun && setupQuota(cfg, store, summary) {
+		return nil
 	}
 
-	var showDetail ShowDetail
-	if err := json.NewDecoder(resp.Body).Decode(&showDetail); err != nil {
-		return nil, err
+	// Plans are kept in the state file, so a resumed run doesn't fetch metadata again
+	if state.Plans == nil {
+		state.Plans = planShows(cfg, state, store, summary)
+		if err := state.Save(); err != nil {
+			logger.Warn("Failed to write state file %s: %v", cfg.StateFile, err)
+		}
+
+		estimate := estimatePlans(cfg, state.Plans)
+		if cfg.DryRun {
+			printPlans(state.Plans)
+			estimate.Print()
+			return nil
+		}
+		estimate.Print()
+		if cfg.Offline {
+			printOfflinePlan(cfg, state, store)
+			return nil
+		}
+		if !cfg.Yes && !confirm("Continue with the download?") {
+			logger.Info("Download cancelled")
+			if err := state.Remove(); err != nil {
+				logger.Warn("Failed to remove state file %s: %v", cfg.StateFile, err)
+			}
+			return nil
+		}
 	}
 
-	return &showDetail, nil
-}
+	plans := state.Plans
+	logger.Println("") // Blank line for readability
+
+	if mode, _ := resolveProgressMode(cfg.Progress); cfg.ParallelShows > 1 && mode == ProgressBar {
+		// The shows downloading at the same time share one block of bars
+		sharedBars = newSharedBars()
+		defer func() {
+			sharedBars.Wait()
+			sharedBars = nil
+		}()
+	}
 
-func fetchHighestRatedSource(sources []Source) *Source {
-	var bestSource *Source
-	highestRating := 0.0
-	for i, source := range sources {
-		if source.AvgRating > highestRating {
-			highestRating = source.AvgRating
-			bestSource = &sources[i]
+	// Converted files are written while the next shows download
+	transcodes := startTranscoder(cfg)
+
+	// Shows are handed to -parallel-shows workers in order
+	stopped := func() bool { return summary.Aborted() || summary.QuotaReached() || control.Cancelled() }
+	queue := make(chan int)
+	var workers sync.WaitGroup
+	for w := 0; w < max(cfg.ParallelShows, 1); w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range queue {
+				// The run may have stopped while the show was queued
+				if stopped() {
+					continue
+				}
+				plan := plans[i]
+				show := plan.Show
+				logger.Printf("[%d/%d] Processing show: %s at %s, %s\n",
+					i+1, len(plans), show.DisplayDate, show.Venue.Name, show.Venue.Location)
+				events.Emit(ProgressEvent{Type: EventShowStarted, Show: show.DisplayDate, Position: i + 1, Shows: len(plans)})
+
+				before := summary.Downloaded()
+				downloadShow(cfg, plan, store, summary, transcodes)
+
+				if stopped() {
+					// Leave the show unprocessed so resume picks it up again
+					continue
+				}
+
+				if err := state.MarkProcessed(show); err != nil {
+					logger.Warn("Failed to update state file %s: %v", cfg.StateFile, err)
+				}
+				pauseBetweenShows(cfg, summary.Downloaded() > before)
+			}
+		}()
+	}
+
+	for i, plan := range plans {
+		if stopped() || !control.Wait() || !waitForWindow(cfg) {
+			break
 		}
+		if state.IsProcessed(plan.Show) {
+			logger.Debug("Skipping show %s (already processed in a previous run)", plan.Show.DisplayDate)
+			continue
+		}
+		queue <- i
+	}
+	close(queue)
+	workers.Wait()
+	transcodes.Wait()
+
+	transferred := recordRun(cfg, store, started, summary)
+
+	status := "complete"
+	if control.Cancelled() {
+		status = "cancelled"
+	} else if summary.QuotaReached() {
+		status = "paused"
+	} else if summary.Aborted() {
+		status = "aborted"
+	}
+	runHook("on-run-complete", cfg.OnRunComplete, HookData{
+		Band: cfg.Band, Year: cfg.Year, Files: summary.Downloaded(), Bytes: transferred, Status: status,
+	})
+	pushMetrics(cfg, RunMetrics{
+		Started: started, Files: summary.Downloaded(), Failed: summary.Failures(), Bytes: transferred, Status: status,
+	})
+
+	if control.Cancelled() {
+		summary.Print()
+		logger.Println("")
+		logger.Info("Run cancelled; continue it with: dead-dl resume %s", cfg.StateFile)
+		return nil
 	}
-	return bestSource
-}
 
-func downloadArchiveFiles(identifier, outputDir, format string, concurrency int) error {
-	// Fetch metadata
-	url := fmt.Sprintf("%s/metadata/%s", ArchiveAPIBase, identifier)
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
+	if summary.QuotaReached() {
+		summary.Print()
+		logger.Println("")
+		logger.Info("Paused by the monthly quota; continue next month with: dead-dl resume %s", cfg.StateFile)
+		return nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("archive.org API returned status %d", resp.StatusCode)
+	if summary.Aborted() {
+		summary.Print()
+		return fmt.Errorf("run aborted after %d failed file(s); resume with: dead-dl resume %s",
+			summary.Failures(), cfg.StateFile)
 	}
 
-	var metadata ArchiveMetadata
-	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
-		return err
+	// The run finished, so there is nothing left to resume
+	if err := state.Remove(); err != nil {
+		logger.Warn("Failed to remove state file %s: %v", cfg.StateFile, err)
+	}
+
+	summary.Print()
+	logger.Println("\nDownload complete!")
+	return nil
+}
+
+// downloadShow downloads every planned source of a single show
+func downloadShow(cfg *Config, plan *ShowPlan, store CatalogStore, summary *RunSummary, transcodes *transcoder) {
+	if plan.Note != "" {
+		logger.Printf("  %s\n", plan.Note)
 	}
 
-	// Filter files by format
-	var filesToDownload []ArchiveFile
-	wantFlac := format == "flac" || format == "both"
-	wantMp3 := format == "mp3" || format == "both"
+	for j, sp := range plan.Sources {
+		// One call per line, so lines of -parallel-shows don't get mixed up
+		if sp.Identifier == "" {
+			logger.Printf("  Source [%d/%d]: No archive.org link or track URLs found\n", j+1, len(plan.Sources))
+			continue
+		}
+		if sp.TrackURLs {
+			logger.Printf("  Source [%d/%d]: %s, from relisten.org track URLs\n", j+1, len(plan.Sources), sp.Identifier)
+		} else {
+			logger.Printf("  Source [%d/%d]: archive.org identifier: %s\n", j+1, len(plan.Sources), sp.Identifier)
+		}
 
-	for _, file := range metadata.Files {
-		// Skip non-audio files
-		if !isAudioFile(file.Name) {
+		// Another machine sharing the catalog may have fetched this source
+		// already, unless it only got MP3s and FLAC is available now
+		losslessUpgrade := isLosslessUpgrade(cfg, store, sp)
+		if losslessUpgrade {
+			logger.Printf("    - FLAC is now available for this MP3 download\n")
+		} else if src, ok := catalogedElsewhere(store, sp.Identifier); ok {
+			logger.Printf("    - Skipping, already downloaded by %s on %s\n",
+				src.Host, src.DownloadedAt.Format("2006-01-02"))
 			continue
 		}
 
-		fileNameLower := strings.ToLower(file.Name)
-		fileFormat := strings.ToLower(file.Format)
+		// Planning may have failed on a transient error, so try again now
+		if sp.Files == nil {
+			if err := sp.selectFiles(cfg, summary); err != nil {
+				logger.Error("Failed to download files: %v", err)
+				continue
+			}
+		}
 
-		// Check if file matches requested format by extension (more reliable)
-		isFlac := strings.HasSuffix(fileNameLower, ".flac")
-		isMp3 := strings.HasSuffix(fileNameLower, ".mp3") ||
-			strings.Contains(fileFormat, "mp3") ||
-			fileFormat == "vbr mp3"
+		// Create show directory
+		showDir := sp.ShowDir
+		if err := os.MkdirAll(showDir, 0755); err != nil {
+			logger.Error("Failed to create show directory: %v", err)
+			continue
+		}
+
+		// Make sure no other instance is downloading this show
+		lock, err := acquireShowLock(showDir, cfg.StaleLock)
+		if errors.Is(err, ErrShowLocked) {
+			logger.Printf("    - Skipping %s (%v)\n", showDir, err)
+			summary.AddLockedShow(showDir)
+			continue
+		} else if err != nil {
+			logger.Error("Failed to lock show directory: %v", err)
+			continue
+		}
 
-		// Also check format field for FLAC
-		if !isFlac && strings.Contains(fileFormat, "flac") {
-			isFlac = true
+		// Download files, and tag them before anything copies or catalogs them
+		err = downloadArchiveFiles(sp.Identifier, showDir, sp.Files, cfg, summary)
+		if err == nil {
+			tagShow(cfg, plan.Show, sp)
+		}
+		if releaseErr := lock.Release(); releaseErr != nil {
+			logger.Warn("Failed to release lock for %s: %v", showDir, releaseErr)
 		}
 
-		if (wantFlac && isFlac) || (wantMp3 && isMp3) {
-			filesToDownload = append(filesToDownload, file)
+		finished := ProgressEvent{Type: EventShowFinished, Show: showDir}
+		if err != nil {
+			finished.Error = err.Error()
 		}
+		events.Emit(finished)
+
+		if err != nil {
+			logger.Error("Failed to download files: %v", err)
+			continue
+		}
+
+		logger.Printf("    ✓ Downloaded to %s\n", showDir)
+		for _, m := range sp.Mismatches {
+			logger.Warn("Track list mismatch in %s: %s", showDir, m)
+			summary.AddTrackMismatch(showDir, m)
+		}
+		recordTrackMismatches(showDir, sp.Mismatches)
+		if losslessUpgrade {
+			finishLosslessUpgrade(cfg, sp)
+		}
+		writeShowFiles(cfg, plan.Show, sp)
+		transcodes.Queue(showDir)
+		trimShow(cfg, showDir)
+		catalogSource(cfg, store, plan.Show, sp)
+		if len(sp.Supersedes) > 0 {
+			supersedeAudience(cfg, store, sp)
+		}
+		updateLatest(cfg, showDir)
+		runHook("on-show-complete", cfg.OnShowComplete, HookData{
+			Band: cfg.Band, Year: dateYear(plan.Show.DisplayDate), Date: plan.Show.DisplayDate,
+			ShowDir: showDir, Identifier: sp.Identifier,
+		})
 	}
+}
 
-	// If no files found and format was flac, try mp3 as fallback
-	if len(filesToDownload) == 0 && format == "flac" {
-		logger.Println("    - No FLAC files found, falling back to MP3...")
-		wantMp3 = true
-		wantFlac = false
+// fetchShows lists a band's shows of a year. While relisten.org is down
+// and hasn't been cached, the shows are found by searching archive.org.
+func fetchShows(band, year string) ([]Show, error) {
+	url := fmt.Sprintf("%s/artists/%s/years/%s", RelistenAPIBase, band, year)
+	var showsResp ShowsResponse
+	provider, err := getRelisten(url, &showsResp)
+	if errors.Is(err, errRelistenDown) {
+		logger.Warn("%v, searching archive.org collection %s for %s", err, bandCollection(band), year)
+		return searchArchiveYear(band, year)
+	} else if err != nil {
+		return nil, err
+	}
 
-		for _, file := range metadata.Files {
-			if !isAudioFile(file.Name) {
-				continue
-			}
+	for i := range showsResp.Shows {
+		showsResp.Shows[i].Provider = provider
+	}
+	return showsResp.Shows, nil
+}
 
-			fileNameLower := strings.ToLower(file.Name)
-			fileFormat := strings.ToLower(file.Format)
+// fetchYears lists the years a band has shows in
+func fetchYears(band string) ([]ArtistYear, error) {
+	url := fmt.Sprintf("%s/artists/%s/years", RelistenAPIBase, band)
+	var years []ArtistYear
+	if _, err := getRelisten(url, &years); err != nil {
+		return nil, err
+	}
+	return years, nil
+}
 
-			isMp3 := strings.HasSuffix(fileNameLower, ".mp3") ||
-				strings.Contains(fileFormat, "mp3") ||
-				fileFormat == "vbr mp3"
+// fetchShowDetail fetches the sources of a show, from archive.org while
+// relisten.org is down and hasn't been cached
+func fetchShowDetail(band, date string) (*ShowDetail, error) {
+	url := fmt.Sprintf("%s/artists/%s/shows/%s", RelistenAPIBase, band, date)
+	var showDetail ShowDetail
+	provider, err := getRelisten(url, &showDetail)
+	if errors.Is(err, errRelistenDown) {
+		logger.Warn("%v, searching archive.org collection %s for %s", err, bandCollection(band), date)
+		return searchArchiveShow(band, date)
+	} else if err != nil {
+		return nil, err
+	}
 
-			if isMp3 {
-				filesToDownload = append(filesToDownload, file)
-			}
-		}
+	showDetail.Provider = provider
+	return &showDetail, nil
+}
+
+// fetchArchiveMetadata fetches the file listing of an archive.org item
+func fetchArchiveMetadata(identifier string) (*ArchiveMetadata, error) {
+	url := fmt.Sprintf("%s/metadata/%s", ArchiveAPIBase, identifier)
+	resp, err := apiGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("archive.org API returned status %d", resp.StatusCode)
 	}
 
-	if len(filesToDownload) == 0 {
-		return fmt.Errorf("no audio files found in requested format")
+	var metadata ArchiveMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, err
 	}
 
+	return &metadata, nil
+}
+
+func downloadArchiveFiles(identifier, outputDir string, filesToDownload []ArchiveFile, cfg *Config, summary *RunSummary) error {
+	manifest, err := loadManifest(outputDir)
+	if err != nil {
+		return err
+	}
+	manifest.Identifier = identifier
+
 	// Download each file with concurrency
 	downloadErrors := []string{}
 	successCount := 0
-	var mu sync.Mutex // Protect shared variables
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, concurrency) // Limit concurrent downloads
-
-	// Create progress container for multiple progress bars
-	progress := mpb.New(mpb.WithWaitGroup(&wg))
-
-	for _, file := range filesToDownload {
-		wg.Add(1)
-		go func(file ArchiveFile) {
-			defer wg.Done()
-
-			// Acquire semaphore
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }() // Release semaphore
-
-			fileURL := fmt.Sprintf("%s/download/%s/%s", ArchiveAPIBase, identifier, file.Name)
-
-			// Use title for filename if available, otherwise use original name
-			fileName := fmt.Sprintf("%s %s", file.Track, file.Name)
-			oldFileName := file.Name // Old filename without track prefix
-			if file.Title != "" {
-				// Get extension from original filename
-				ext := filepath.Ext(file.Name)
-				// Sanitize title and use it as filename
-				sanitizedTitle := sanitizeFilename(file.Title)
-				oldFileName = sanitizedTitle + ext // Old filename with title but no track
-				fileName = fmt.Sprintf("%s %s", file.Track, sanitizedTitle+ext)
-			}
+	var mu sync.Mutex                                 // Protect shared variables
+	semaphore := make(chan struct{}, cfg.Concurrency) // Limit concurrent downloads
+
+	// Files that time out are queued and retried once the rest of the show has finished
+	var retryQueue []ArchiveFile
+	for pass := 0; len(filesToDownload) > 0; pass++ {
+		lastPass := pass > 0
+		var wg sync.WaitGroup
+
+		// Create progress reporter for this batch of downloads
+		progress := newProgressReporter(cfg.Progress, outputDir, &wg)
+
+		for _, file := range filesToDownload {
+			wg.Add(1)
+			go func(file ArchiveFile) {
+				defer wg.Done()
+
+				// Acquire semaphore
+				semaphore <- struct{}{}
+				defer func() { <-semaphore }() // Release semaphore
+
+				// Don't start new downloads once the run has hit its failure limit,
+				// and hold them back while a socket client has paused the run or
+				// outside -only-between
+				if summary.Aborted() || !control.Wait() || !waitForWindow(cfg) {
+					return
+				}
+
+				fileURL := downloadURL(identifier, file)
+
+				fileName, oldFileName := localFileNames(file, cfg)
 
-			filePath := filepath.Join(outputDir, fileName)
-			oldFilePath := filepath.Join(outputDir, oldFileName)
-
-			// Check if file already exists and verify size
-			if fileInfo, err := os.Stat(filePath); err == nil {
-				// File exists, check if size matches
-				localSize := fileInfo.Size()
-				remoteSize, parseErr := parseFileSize(file.Size)
-
-				if parseErr != nil {
-					// Can't parse remote size, log warning and re-download
-					logger.Printf("    - Re-downloading %s (unable to verify size: %v)\n", fileName, parseErr)
-				} else if localSize == remoteSize {
-					// Sizes match, skip download
-					logger.Printf("    - Skipping %s (already exists, size: %d bytes)\n", fileName, localSize)
+				filePath, pathErr := safeJoin(outputDir, fileName)
+				if pathErr == nil {
+					_, pathErr = safeJoin(outputDir, oldFileName)
+				}
+				if pathErr != nil {
+					logger.Warn("Skipping %s: %v", file.Name, pathErr)
 					mu.Lock()
-					successCount++
+					downloadErrors = append(downloadErrors, fmt.Sprintf("%s: %v", file.Name, pathErr))
 					mu.Unlock()
 					return
-				} else {
-					// Sizes don't match, re-download
-					logger.Printf("    - Re-downloading %s (size mismatch: local=%d, remote=%d)\n", fileName, localSize, remoteSize)
 				}
-			} else if oldFilePath != filePath {
-				// Check if file exists with old naming scheme (without track prefix)
-				if _, oldErr := os.Stat(oldFilePath); oldErr == nil {
-					// Old file exists, rename it to new filename
-					renameErr := os.Rename(oldFilePath, filePath)
-					if renameErr != nil {
-						logger.Printf("    - Failed to rename %s to %s: %v\n", oldFileName, fileName, renameErr)
-					} else {
-						logger.Printf("    - Renamed %s to %s\n", oldFileName, fileName)
+				oldFilePath := filepath.Join(outputDir, oldFileName)
+
+				// With -preserve-structure the file may live in a subdirectory of the show
+				if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+					logger.Error("Failed to create directory for %s: %v", fileName, err)
+					mu.Lock()
+					downloadErrors = append(downloadErrors, fmt.Sprintf("%s: %v", fileName, err))
+					mu.Unlock()
+					return
+				}
+
+				// Check if file already exists and verify size
+				if fileInfo, err := os.Stat(filePath); err == nil {
+					// File exists, check if size matches
+					localSize := fileInfo.Size()
+					remoteSize, parseErr := parseFileSize(file.Size)
+					changed := md5Changed(manifest, file, fileName)
+
+					if changed != "" {
+						// archive.org replaced the file with one of the same size
+						logger.Printf("    - Re-downloading %s (%s)\n", fileName, changed)
+						if _, err := quarantineFile(cfg, filePath, filePath, fileURL, changed); err != nil {
+							logger.Warn("Failed to quarantine %s: %v", fileName, err)
+						}
+					} else if parseErr != nil && !matchesManifest(manifest, fileName, filePath, localSize) {
+						// Unknown remote size (a track URL without a size) and no manifest entry to vouch for the file
+						logger.Printf("    - Re-downloading %s (unable to verify size: %v)\n", fileName, parseErr)
+					} else if parseErr != nil || localSize == remoteSize || isTaggedCopy(manifest, fileName, localSize) {
+						// Sizes match, the file grew by the tags we wrote into it, or it is
+						// the file its manifest entry recorded; skip download
+						logger.Printf("    - Skipping %s (already exists, size: %d bytes)\n", fileName, localSize)
+						recordManifestEntry(manifest, file, fileURL, filePath, fileName, false)
+						fingerprintEntry(cfg, manifest, file, filePath, fileName)
 						mu.Lock()
 						successCount++
 						mu.Unlock()
 						return
+					} else {
+						// Sizes don't match; keep the file for inspection and re-download
+						logger.Printf("    - Re-downloading %s (size mismatch: local=%d, remote=%d)\n", fileName, localSize, remoteSize)
+						reason := fmt.Sprintf("size mismatch: local=%d, remote=%d", localSize, remoteSize)
+						if _, err := quarantineFile(cfg, filePath, filePath, fileURL, reason); err != nil {
+							logger.Warn("Failed to quarantine %s: %v", fileName, err)
+						}
+					}
+				} else if oldFilePath != filePath {
+					// Check if file exists with old naming scheme (without track prefix)
+					if _, oldErr := os.Stat(oldFilePath); oldErr == nil {
+						// Old file exists, rename it to new filename
+						renameErr := os.Rename(oldFilePath, filePath)
+						if renameErr != nil {
+							logger.Warn("Failed to rename %s to %s: %v", oldFileName, fileName, renameErr)
+						} else {
+							logger.Printf("    - Renamed %s to %s\n", oldFileName, fileName)
+							recordManifestEntry(manifest, file, fileURL, filePath, fileName, false)
+							fingerprintEntry(cfg, manifest, file, filePath, fileName)
+							mu.Lock()
+							successCount++
+							mu.Unlock()
+							return
+						}
 					}
 				}
-			}
 
-			if err := downloadFile(fileURL, filePath, fileName, progress); err != nil {
-				// Handle specific HTTP error codes
-				mu.Lock()
-				if strings.Contains(err.Error(), "status 401") {
-					logger.Printf("    - ⚠ Skipping %s (restricted/requires authentication)\n", fileName)
-					downloadErrors = append(downloadErrors, fmt.Sprintf("%s: restricted", fileName))
-				} else if strings.Contains(err.Error(), "status 403") {
-					logger.Printf("    - ⚠ Skipping %s (forbidden/restricted)\n", fileName)
-					downloadErrors = append(downloadErrors, fmt.Sprintf("%s: forbidden", fileName))
-				} else {
-					logger.Printf("    - ✗ Failed to download %s: %v\n", fileName, err)
-					downloadErrors = append(downloadErrors, fmt.Sprintf("%s: %v", fileName, err))
+				expectedSize, sizeErr := parseFileSize(file.Size)
+				if sizeErr != nil {
+					expectedSize = -1
+				}
+
+				// Files already on disk were handled above; only new transfers count against the quota
+				if checkQuota(summary) {
+					return
+				}
+
+				if err := downloadWithRetries(fileURL, filePath, fileName, expectedSize, file.MD5, progress, cfg); err != nil {
+					// Handle specific HTTP error codes
+					mu.Lock()
+					if errors.Is(err, errRunCancelled) {
+						// Not a failure; resume downloads the file again
+					} else if errors.Is(err, errFileTimeout) && !lastPass {
+						logger.Warn("%s timed out after %s, queued for retry", fileName, cfg.FileTimeout)
+						retryQueue = append(retryQueue, file)
+					} else if strings.Contains(err.Error(), "status 401") {
+						logger.Warn("Skipping %s (restricted/requires authentication)", fileName)
+						downloadErrors = append(downloadErrors, fmt.Sprintf("%s: restricted", fileName))
+					} else if strings.Contains(err.Error(), "status 403") {
+						logger.Warn("Skipping %s (forbidden/restricted)", fileName)
+						downloadErrors = append(downloadErrors, fmt.Sprintf("%s: forbidden", fileName))
+					} else {
+						logger.Error("Failed to download %s: %v", fileName, err)
+						downloadErrors = append(downloadErrors, fmt.Sprintf("%s: %v", fileName, err))
+						if summary.AddFailure(cfg.MaxFailures) {
+							logger.Error("Reached the limit of %d failed file(s), aborting run", cfg.MaxFailures)
+						}
+					}
+					mu.Unlock()
+					return
 				}
+
+				recordManifestEntry(manifest, file, fileURL, filePath, fileName, true)
+				fingerprintEntry(cfg, manifest, file, filePath, fileName)
+				runHook("on-file-complete", cfg.OnFileComplete, HookData{
+					Band: cfg.Band, ShowDir: outputDir, Identifier: identifier, File: filePath,
+				})
+				summary.AddDownloaded()
+
+				mu.Lock()
+				successCount++
 				mu.Unlock()
-				return
-			}
+				time.Sleep(100 * time.Millisecond) // Be nice to the server
+			}(file)
+		}
+
+		// Wait for all downloads to complete and progress bars to finish
+		progress.Wait()
 
-			mu.Lock()
-			successCount++
-			mu.Unlock()
-			time.Sleep(100 * time.Millisecond) // Be nice to the server
-		}(file)
+		filesToDownload, retryQueue = retryQueue, nil
+		if len(filesToDownload) > 0 {
+			logger.Printf("    - Retrying %d timed-out file(s)...\n", len(filesToDownload))
+		}
 	}
 
-	// Wait for all downloads to complete and progress bars to finish
-	progress.Wait()
+	if successCount > 0 {
+		if err := manifest.Save(); err != nil {
+			logger.Warn("Failed to write manifest for %s: %v", outputDir, err)
+		}
+	}
 
 	// Return error only if all downloads failed
 	if successCount == 0 && len(downloadErrors) > 0 {
@@ -575,23 +1289,12 @@ func downloadArchiveFiles(identifier, outputDir, format string, concurrency int)
 
 	// Log warnings if some downloads failed
 	if len(downloadErrors) > 0 && successCount > 0 {
-		logger.Printf("    - ⚠ %d file(s) failed to download (see above)\n", len(downloadErrors))
+		logger.Warn("%d file(s) of %s failed to download (see above)", len(downloadErrors), outputDir)
 	}
 
 	return nil
 }
 
-func isAudioFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	audioExts := []string{".flac", ".mp3", ".ogg", ".shn", ".wav", ".m4a"}
-	for _, ext2 := range audioExts {
-		if ext == ext2 {
-			return true
-		}
-	}
-	return false
-}
-
 // parseFileSize converts the archive.org size string to int64
 // The size field is typically a string representation of bytes
 func parseFileSize(sizeStr string) (int64, error) {
@@ -633,15 +1336,35 @@ func sanitizeFilename(name string) string {
 	return result
 }
 
-func downloadFile(url, filepath, displayName string, progress *mpb.Progress) error {
+// downloadFile downloads url to filePath. expectedSize is the size from the
+// archive metadata, or -1 when it is unknown; expectedMD5 is the md5 from the
+// metadata, or "" when it is unknown.
+func downloadFile(url, filePath, displayName string, expectedSize int64, expectedMD5 string, progress ProgressReporter, cfg *Config) (err error) {
+	// A cancel command stops the transfer through the run control's context
+	ctx, cancel := context.WithCancelCause(control.Context())
+	defer cancel(nil)
+
+	if cfg.FileTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, cfg.FileTimeout, errFileTimeout)
+		defer cancelTimeout()
+	}
+
+	// Report why the transfer was cancelled (e.g. a stall) instead of a bare "context canceled"
+	defer func() {
+		if err != nil && context.Cause(ctx) != nil {
+			err = context.Cause(ctx)
+		}
+	}()
+
 	// Create HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
 
 	// Make the request
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := downloadClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -651,62 +1374,94 @@ func downloadFile(url, filepath, displayName string, progress *mpb.Progress) err
 		return fmt.Errorf("download returned status %d", resp.StatusCode)
 	}
 
-	// Create output file
-	out, err := os.Create(filepath)
+	// Write to a temporary file that is only renamed into place once complete,
+	// so an interrupted or preallocated file is never mistaken for a finished one
+	partPath := filePath + ".part"
+	out, err := os.Create(partPath)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-
-	// Get content length for progress bar
-	contentLength := resp.ContentLength
-
-	// Truncate display name if too long
-	maxNameLen := 40
-	truncatedName := displayName
-	if len(displayName) > maxNameLen {
-		truncatedName = displayName[:maxNameLen-3] + "..."
-	}
-
-	// Create progress bar for this download
-	var bar *mpb.Bar
-	if contentLength > 0 {
-		// Content length is known, show byte progress with speed
-		bar = progress.AddBar(contentLength,
-			mpb.PrependDecorators(
-				decor.Name(truncatedName, decor.WCSyncWidth),
-			),
-			mpb.AppendDecorators(
-				decor.CountersKibiByte("% .2f / % .2f"),
-				decor.Percentage(decor.WCSyncSpace),
-				decor.Name(" | "),
-				decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 30),
-			),
-		)
-	} else {
-		// Content length unknown, show indeterminate progress with speed
-		bar = progress.AddBar(0,
-			mpb.BarFillerClearOnComplete(),
-			mpb.PrependDecorators(
-				decor.Name(truncatedName, decor.WCSyncWidth),
-			),
-			mpb.AppendDecorators(
-				decor.CountersKibiByte("% .2f"),
-				decor.Name(" | "),
-				decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 30),
-			),
-		)
-	}
-
-	// Create proxy reader that updates progress bar
-	proxyReader := bar.ProxyReader(resp.Body)
-	defer proxyReader.Close()
-
-	// Copy data to file
-	_, err = io.Copy(out, proxyReader)
+	defer func() {
+		if out != nil {
+			out.Close()
+		}
+		if err != nil {
+			os.Remove(partPath)
+		}
+	}()
+
+	if cfg.Preallocate && resp.ContentLength > 0 {
+		if err := preallocate(out, resp.ContentLength); err != nil {
+			return fmt.Errorf("failed to preallocate %d bytes: %w", resp.ContentLength, err)
+		}
+	}
+
+	// Start tracking progress for this download
+	fileProgress := progress.Start(displayName, resp.ContentLength)
+	defer func() {
+		if err != nil {
+			fileProgress.Abort()
+		}
+	}()
+
+	// Abort the transfer if it stalls
+	body := limitReader(resp.Body, downloadLimiter, &byteLimiter{rate: cfg.PerFileRate})
+	counter := &stallReader{r: bandwidth.Reader(body)}
+	stopWatch := watchStall(counter, cancel, cfg.MinSpeed, cfg.StallTime)
+	defer stopWatch()
+
+	// Copy data to file, hashing it on the way
+	hash := md5.New()
+	written, err := io.Copy(io.MultiWriter(out, hash), fileProgress.ProxyReader(counter))
 	if err != nil {
 		return err
 	}
 
+	// A connection closed early can end the body without an error, so make sure
+	// we got everything the server and the metadata promised
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return fmt.Errorf("%w: received %d of %d bytes (Content-Length)", errSizeMismatch, written, resp.ContentLength)
+	}
+	if expectedSize >= 0 && written != expectedSize {
+		return fmt.Errorf("%w: received %d bytes, metadata lists %d", errSizeMismatch, written, expectedSize)
+	}
+
+	// Drop any preallocated space that wasn't written
+	if err := out.Truncate(written); err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); expectedMD5 != "" && !strings.EqualFold(sum, expectedMD5) {
+		out.Close()
+		out = nil
+		err = fmt.Errorf("%w: got %s, metadata lists %s", errChecksumMismatch, sum, expectedMD5)
+		if _, qErr := quarantineFile(cfg, partPath, filePath, url, err.Error()); qErr != nil {
+			logger.Warn("Failed to quarantine %s: %v", displayName, qErr)
+		}
+		return err
+	}
+	if cfg.Fsync {
+		if err := out.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		out = nil
+		return err
+	}
+	out = nil
+
+	if err := os.Rename(partPath, filePath); err != nil {
+		return err
+	}
+	if cfg.Fsync {
+		// Persist the rename itself, otherwise a power loss can still lose the file
+		if err := syncDir(filepath.Dir(filePath)); err != nil {
+			return err
+		}
+	}
+
+	fileProgress.Done()
+
 	return nil
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestFileName is the name of the per-show manifest written into every show directory
+const ManifestFileName = "manifest.json"
+
+// ManifestEntry describes a single downloaded file
+type ManifestEntry struct {
+	RemoteURL    string    `json:"remote_url"`
+	Size         int64     `json:"size"`
+	MD5          string    `json:"md5"`
+	LocalName    string    `json:"local_name"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	Fingerprint  string    `json:"fingerprint,omitempty"` // AcoustID fingerprint, with -fingerprint
+	Duration     float64   `json:"duration,omitempty"`    // Seconds, as measured by fpcalc
+	Tagged       bool      `json:"tagged,omitempty"`      // Tags were written into the file; Size is its size since
+	TaggedMD5    string    `json:"tagged_md5,omitempty"`  // md5 of the file since it was tagged
+}
+
+// Manifest lists every file of a show directory along with where it came from,
+// making the directory self-describing
+type Manifest struct {
+	Identifier      string          `json:"identifier"`
+	Files           []ManifestEntry `json:"files"`
+	TrackMismatches []TrackMismatch `json:"track_mismatches,omitempty"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// loadManifest reads the manifest of a show directory, returning an empty
+// manifest if none has been written yet
+func loadManifest(showDir string) (*Manifest, error) {
+	m := &Manifest{path: filepath.Join(showDir, ManifestFileName)}
+
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", m.path, err)
+	}
+	return m, nil
+}
+
+// Lookup returns the entry for a local file name, if any
+func (m *Manifest) Lookup(localName string) (ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, entry := range m.Files {
+		if entry.LocalName == localName {
+			return entry, true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+// Record adds or replaces the entry for a local file name
+func (m *Manifest) Record(entry ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.Files {
+		if existing.LocalName == entry.LocalName {
+			m.Files[i] = entry
+			return
+		}
+	}
+	m.Files = append(m.Files, entry)
+}
+
+// Entries returns a copy of the manifest entries
+func (m *Manifest) Entries() []ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ManifestEntry(nil), m.Files...)
+}
+
+// Remove drops the entry for a local file name
+func (m *Manifest) Remove(localName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, entry := range m.Files {
+		if entry.LocalName == localName {
+			m.Files = append(m.Files[:i], m.Files[i+1:]...)
+			return
+		}
+	}
+}
+
+// Save writes the manifest sorted by local name
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].LocalName < m.Files[j].LocalName
+	})
+	m.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := m.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.path)
+}
+
+// isTaggedCopy reports whether a local file of this size is a download
+// that tags were written into, rather than a truncated or corrupt one
+func isTaggedCopy(m *Manifest, fileName string, size int64) bool {
+	entry, ok := m.Lookup(fileName)
+	return ok && entry.Tagged && entry.Size == size
+}
+
+// diskMD5 returns the md5 of the file as it is on disk: archive.org's, or
+// the one recorded when it was tagged. It is empty if neither is known.
+func (e ManifestEntry) diskMD5() string {
+	if e.Tagged {
+		return strings.ToLower(e.TaggedMD5)
+	}
+	return strings.ToLower(e.MD5)
+}
+
+// matchesManifest reports whether a local file is the one its manifest entry
+// recorded: the same size and, when the entry has one, the same md5. It
+// stands in for the remote size of files whose size is unknown.
+func matchesManifest(m *Manifest, fileName, filePath string, size int64) bool {
+	entry, ok := m.Lookup(fileName)
+	if !ok || entry.Size != size {
+		return false
+	}
+	want := entry.diskMD5()
+	if want == "" {
+		return true
+	}
+	sum, err := fileMD5(filePath)
+	return err == nil && strings.EqualFold(sum, want)
+}
+
+// md5Changed describes how archive.org's md5 of a file differs from the one
+// its manifest entry recorded when it was downloaded, or returns "" when it
+// doesn't or either is unknown
+func md5Changed(m *Manifest, file ArchiveFile, fileName string) string {
+	entry, ok := m.Lookup(fileName)
+	if !ok || entry.MD5 == "" || file.MD5 == "" || strings.EqualFold(entry.MD5, file.MD5) {
+		return ""
+	}
+	return fmt.Sprintf("md5 changed on archive.org: was %s, now %s", entry.MD5, file.MD5)
+}
+
+// fileMD5 returns the hex MD5 of a file
+func fileMD5(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// recordManifestEntry stats a local file and records it in the manifest.
+// Files that were already listed keep their original download timestamp.
+func recordManifestEntry(m *Manifest, file ArchiveFile, fileURL, filePath, fileName string, downloaded bool) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		logger.Warn("Failed to stat %s for manifest: %v", filePath, err)
+		return
+	}
+
+	entry := ManifestEntry{
+		RemoteURL:    fileURL,
+		Size:         info.Size(),
+		MD5:          file.MD5,
+		LocalName:    fileName,
+		DownloadedAt: info.ModTime(),
+	}
+	if existing, ok := m.Lookup(fileName); ok && !downloaded {
+		entry.DownloadedAt = existing.DownloadedAt
+		entry.Fingerprint, entry.Duration = existing.Fingerprint, existing.Duration
+		if existing.Tagged && existing.Size == entry.Size {
+			entry.Tagged, entry.TaggedMD5 = true, existing.TaggedMD5
+		}
+	} else if downloaded {
+		entry.DownloadedAt = time.Now()
+	}
+
+	m.Record(entry)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// safeJoin joins a file name onto dir and verifies the result stays inside
+// dir. Names come from archive.org metadata and must never be able to
+// escape the show directory.
+func safeJoin(dir, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("unsafe file name %q", name)
+	}
+
+	joined := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, joined)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file name %q escapes %s", name, dir)
+	}
+	return joined, nil
+}
+
+// archiveFileURL builds the download URL of a file in an archive.org item,
+// escaping each path segment of the file name
+func archiveFileURL(identifier, name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return fmt.Sprintf("%s/download/%s/%s", ArchiveAPIBase, url.PathEscape(identifier), strings.Join(segments, "/"))
+}
+
+// localFileNames returns the name a file is saved under, relative to the show
+// directory, and the name older versions used (without the track prefix) so
+// existing downloads can be renamed
+func localFileNames(file ArchiveFile, cfg *Config) (fileName, oldFileName string) {
+	// etree info and checksum files are mirrored bit-exact, names included;
+	// safeJoin still rejects names that would leave the show directory
+	if cfg.EtreeExtras && isEtreeExtra(file) {
+		name := filepath.FromSlash(file.Name)
+		return name, name
+	}
+
+	// With file names in -output-template, audio files saved under the usual
+	// names are renamed into it
+	if t, _ := parseOutputTemplate(cfg.OutputTemplate); t != nil && t.file != nil && classifyFile(file) == ClassAudio {
+		usual := *cfg
+		usual.OutputTemplate = ""
+		plain := file
+		plain.NameSuffix = 0
+		if !cfg.SplitSets {
+			plain.SetDir = ""
+		}
+		oldFileName, _ = localFileNames(plain, &usual)
+
+		fileName = t.fileName(file)
+		if file.NameSuffix > 0 {
+			fileName = withNameSuffix(fileName, file.NameSuffix)
+			oldFileName = fileName
+		}
+		return fileName, oldFileName
+	}
+
+	// Archive names may contain "/" and ".." components, so only their sanitized base is used
+	baseName := sanitizeFilename(path.Base(file.Name))
+
+	// Use title for filename if available, otherwise use original name.
+	// Non-audio files (artwork, text, checksums) always keep their original name.
+	fileName = fmt.Sprintf("%s %s", file.Track, baseName)
+	oldFileName = baseName // Old filename without track prefix
+	if classifyFile(file) != ClassAudio {
+		fileName = baseName
+	} else if file.Title != "" {
+		// Get extension from original filename
+		ext := filepath.Ext(file.Name)
+		// Sanitize title and use it as filename
+		sanitizedTitle := sanitizeFilename(file.Title)
+		oldFileName = sanitizedTitle + ext // Old filename with title but no track
+		fileName = fmt.Sprintf("%s %s", file.Track, sanitizedTitle+ext)
+	}
+
+	// Encore tracks are numbered e01, e02, ... as in etree file names with
+	// -encore-names; files saved under their plain track number are renamed
+	if file.EncoreTrack > 0 && classifyFile(file) == ClassAudio {
+		oldFileName = fileName
+		fileName = fmt.Sprintf("e%02d", file.EncoreTrack) + strings.TrimPrefix(fileName, file.Track)
+	}
+
+	if cfg.PreserveDirs {
+		if subdir := archiveSubdir(file.Name); subdir != "" {
+			fileName = filepath.Join(subdir, fileName)
+			oldFileName = filepath.Join(subdir, oldFileName)
+		}
+	}
+
+	// The set folder is only assigned with -split-sets. A file downloaded
+	// before sets were split is moved into its set folder.
+	if file.SetDir != "" {
+		if file.EncoreTrack == 0 {
+			oldFileName = fileName
+		}
+		fileName = filepath.Join(sanitizeFilename(file.SetDir), fileName)
+	}
+
+	// A file renamed to avoid a collision has no older name; the name it
+	// would have had belongs to the other file
+	if file.NameSuffix > 0 {
+		fileName = withNameSuffix(fileName, file.NameSuffix)
+		oldFileName = fileName
+	}
+	return fileName, oldFileName
+}
+
+// withNameSuffix inserts " (n)" before the extension of a file name
+func withNameSuffix(name string, n int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// resolveNameCollisions numbers files that would be saved under the same
+// local name as an earlier file, e.g. two tracks with the same title and
+// track number, so that neither overwrites the other. Names are compared
+// case-insensitively, as macOS and Windows file systems do.
+func resolveNameCollisions(files []ArchiveFile, cfg *Config) {
+	taken := make(map[string]bool)
+	for _, file := range files {
+		name, _ := localFileNames(file, cfg)
+		taken[strings.ToLower(name)] = true
+	}
+
+	used := make(map[string]bool)
+	for i := range files {
+		files[i].NameSuffix = 0
+		name, _ := localFileNames(files[i], cfg)
+		if !used[strings.ToLower(name)] {
+			used[strings.ToLower(name)] = true
+			continue
+		}
+
+		// Skip numbers that give the name of another file
+		for n := 2; ; n++ {
+			suffixed := strings.ToLower(withNameSuffix(name, n))
+			if !taken[suffixed] && !used[suffixed] {
+				files[i].NameSuffix = n
+				used[suffixed] = true
+				break
+			}
+		}
+		newName, _ := localFileNames(files[i], cfg)
+		logger.Info("%s has the same local name as another file, saving it as %s", files[i].Name, newName)
+	}
+}
+
+// archiveSubdir returns the sanitized directory part of an archive file name,
+// dropping empty, "." and ".." components
+func archiveSubdir(name string) string {
+	dir := path.Dir(name)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+
+	var parts []string
+	for _, part := range strings.Split(dir, "/") {
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		if sanitized := sanitizeFilename(part); sanitized != "" {
+			parts = append(parts, sanitized)
+		}
+	}
+	return filepath.Join(parts...)
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ShowPlan is a show with the sources and files selected for download
+type ShowPlan struct {
+	Show    Show          `json:"show"`
+	Note    string        `json:"note,omitempty"`
+	Sources []*SourcePlan `json:"sources"`
+}
+
+// SourcePlan is a source of a show together with the archive.org files to fetch
+type SourcePlan struct {
+	Identifier string          `json:"identifier"`
+	ShowDir    string          `json:"show_dir"`
+	Source     Source          `json:"source"`
+	Files      []ArchiveFile   `json:"files"`
+	Supersedes []string        `json:"supersedes,omitempty"` // Audience recordings this soundboard replaces
+	Mismatches []TrackMismatch `json:"track_mismatches,omitempty"`
+	Cover      *ArchiveFile    `json:"cover,omitempty"`      // Image embedded into the tracks with -cover-art
+	TrackURLs  bool            `json:"track_urls,omitempty"` // Files come from relisten.org's track URLs, see -source
+}
+
+// PlanEstimate summarizes the size of a planned run
+type PlanEstimate struct {
+	Shows         int
+	Files         int
+	Bytes         int64
+	ExistingBytes int64
+	UnknownSizes  int
+}
+
+// planShows fetches show details and archive metadata for every unprocessed show
+func planShows(cfg *Config, state *RunState, store CatalogStore, summary *RunSummary) []*ShowPlan {
+	logger.Info("Planning %d shows...", len(state.Shows))
+
+	// The catalog is only needed to find soundboards for audience-only shows,
+	// and the sources a sync has downloaded already
+	var catalog *Catalog
+	if cfg.Sync || (cfg.SoundboardPolicy != "" && cfg.SoundboardPolicy != SoundboardOff) {
+		var err error
+		if catalog, err = store.Load(); err != nil {
+			logger.Warn("Failed to load catalog, not checking for soundboards or downloaded sources: %v", err)
+		}
+	}
+
+	plans := make([]*ShowPlan, 0, len(state.Shows))
+	for i, show := range state.Shows {
+		if state.IsProcessed(show) {
+			plans = append(plans, &ShowPlan{Show: show})
+			continue
+		}
+
+		plan := planShow(cfg, show, catalog, summary)
+		files := 0
+		for _, sp := range plan.Sources {
+			files += len(sp.Files)
+		}
+		if provider := plan.Show.Provider; provider != "" && provider != ProviderRelisten {
+			logger.Info("  [%d/%d] %s: %d source(s), %d file(s), from %s", i+1, len(state.Shows), show.DisplayDate, len(plan.Sources), files, provider)
+		} else {
+			logger.Info("  [%d/%d] %s: %d source(s), %d file(s)", i+1, len(state.Shows), show.DisplayDate, len(plan.Sources), files)
+		}
+		plans = append(plans, plan)
+	}
+	return plans
+}
+
+// planShow selects the sources of a show and the files of each source
+func planShow(cfg *Config, show Show, catalog *Catalog, summary *RunSummary) *ShowPlan {
+	plan := &ShowPlan{Show: show}
+
+	// Fetch full show details which includes sources, unless the show was
+	// found on archive.org, which listed its sources already
+	showDetail := &ShowDetail{DisplayDate: show.DisplayDate, Sources: show.Sources, Provider: show.Provider}
+	if show.Provider != ProviderArchive {
+		var err error
+		if showDetail, err = fetchShowDetail(cfg.Band, show.DisplayDate); err != nil {
+			logger.Error("Failed to fetch show details for %s: %v", show.DisplayDate, err)
+			plan.Note = fmt.Sprintf("Failed to fetch show details: %v", err)
+			return plan
+		}
+	}
+	plan.Show.Provider = showDetail.Provider
+
+	if len(showDetail.Sources) == 0 {
+		plan.Note = "No sources found for this show"
+		return plan
+	}
+
+	allSources := showDetail.Sources
+	if showDetail.Sources = filterShowSources(showDetail.Sources, cfg.Show); len(showDetail.Sources) == 0 {
+		plan.Note = fmt.Sprintf("No source of -show %s found", cfg.Show)
+		return plan
+	}
+	showDetail.Sources = filterSources(showDetail.Sources, cfg)
+	if len(showDetail.Sources) == 0 {
+		plan.Note = "No sources match -min-rating, -sbd-only, -min-reviews, -taper, or -exclude-lineage"
+		return plan
+	}
+	if cfg.FilterHook != "" {
+		var accepted []Source
+		for _, source := range showDetail.Sources {
+			if filterHookAccepts(cfg.FilterHook, FilterInput{Band: cfg.Band, Show: show, Source: source}) {
+				accepted = append(accepted, source)
+			}
+		}
+		if len(accepted) == 0 {
+			plan.Note = "The filter hook rejected every source"
+			return plan
+		}
+		showDetail.Sources = accepted
+	}
+	if len(showDetail.Sources) > 1 && cfg.HighestRated {
+		// Select highest rated source
+		best, reason := bestSource(showDetail.Sources, cfg)
+		if best == nil {
+			plan.Note = "No valid sources found for this show"
+			return plan
+		}
+		showDetail.Sources = []Source{*best}
+		plan.Note = reason
+	}
+	// A sync skips sources it has, keeping the others' directory numbers
+	var synced map[string]bool
+	if cfg.Sync && catalog != nil {
+		if synced = syncedSources(cfg, catalog, showDetail.Sources); len(synced) == len(showDetail.Sources) {
+			plan.Note = "No new or changed sources"
+			return plan
+		}
+	}
+
+	for j, source := range showDetail.Sources {
+		if synced[sourceIdentifier(source)] {
+			continue
+		}
+		sp := &SourcePlan{Source: source}
+		plan.Sources = append(plan.Sources, sp)
+
+		sp.Identifier = archiveIdentifier(source)
+		if sp.TrackURLs = usesTrackURLs(cfg, source); sp.TrackURLs {
+			sp.Identifier = sourceIdentifier(source)
+		}
+		if sp.Identifier == "" {
+			continue
+		}
+
+		suffix := ""
+		if j > 0 {
+			suffix = fmt.Sprintf("-source%d", j+1)
+		}
+		sp.ShowDir = sourceShowDir(cfg, show, sp.Identifier, suffix)
+
+		if err := sp.selectFiles(cfg, summary); err != nil {
+			logger.Warn("Failed to plan files for %s: %v", sp.Identifier, err)
+		}
+	}
+
+	if catalog != nil && cfg.SoundboardPolicy != "" && cfg.SoundboardPolicy != SoundboardOff {
+		planSoundboard(cfg, catalog, plan, allSources, summary)
+	}
+
+	return plan
+}
+
+// filterSources drops the sources -min-rating, -sbd-only, -min-reviews,
+// -taper, and -exclude-lineage rule out
+func filterSources(sources []Source, cfg *Config) []Source {
+	if cfg.MinRating <= 0 && !cfg.SoundboardOnly && cfg.MinReviews <= 0 && cfg.Taper == "" && cfg.ExcludeLineage == "" {
+		return sources
+	}
+	// Validated at startup
+	lineage, _ := compileLineage(cfg.ExcludeLineage)
+
+	var kept []Source
+	for _, source := range sources {
+		if cfg.SoundboardOnly && !source.IsSoundboard {
+			continue
+		}
+		if cfg.MinRating > 0 && source.AvgRating > 0 && source.AvgRating < cfg.MinRating {
+			continue
+		}
+		if cfg.MinReviews > 0 && sourceReviews(source) < int64(cfg.MinReviews) {
+			continue
+		}
+		if cfg.Taper != "" && !matchesTaper(source, cfg.Taper) {
+			continue
+		}
+		if lineage != nil && (lineage.MatchString(source.Lineage) || lineage.MatchString(source.Source)) {
+			continue
+		}
+		kept = append(kept, source)
+	}
+	return kept
+}
+
+// archiveIdentifier returns the archive.org identifier of a source, or "" if
+// it has no archive.org link
+func archiveIdentifier(source Source) string {
+	for _, link := range source.Links {
+		if strings.Contains(link.URL, "archive.org") {
+			// The identifier is the last path component of the details URL
+			parts := strings.Split(strings.TrimRight(link.URL, "/"), "/")
+			return parts[len(parts)-1]
+		}
+	}
+	return ""
+}
+
+// selectFiles fetches the archive metadata of the source and picks the files
+// to download, or picks them from relisten.org's track URLs
+func (sp *SourcePlan) selectFiles(cfg *Config, summary *RunSummary) error {
+	if sp.TrackURLs {
+		return sp.selectTrackFiles(cfg, summary)
+	}
+	metadata, err := fetchArchiveMetadata(sp.Identifier)
+	if err != nil {
+		return err
+	}
+
+	files := selectFiles(metadata.Files, cfg)
+	if len(files) == 0 {
+		return fmt.Errorf("no files found in requested format")
+	}
+	// Before dropping oversized files, which would show up as missing tracks
+	sp.Mismatches = checkTrackList(files, sp.Source.Sets)
+
+	if cfg.MaxVideoSize > 0 {
+		files = dropOversized(files, sp.ShowDir, cfg.MaxVideoSize, "-max-video-size", func(file ArchiveFile) bool { return isVideoFile(file.Name) }, summary)
+		if len(files) == 0 {
+			return fmt.Errorf("every file is larger than -max-video-size %s", cfg.MaxVideoSize)
+		}
+	}
+	if cfg.MaxFileSize > 0 {
+		files = dropOversized(files, sp.ShowDir, cfg.MaxFileSize, "-max-file-size", func(ArchiveFile) bool { return true }, summary)
+		if len(files) == 0 {
+			return fmt.Errorf("every file is larger than -max-file-size %s", cfg.MaxFileSize)
+		}
+	}
+
+	assignSets(files, sp.Source.Sets, cfg)
+	resolveNameCollisions(files, cfg)
+	if cfg.CoverArt && !cfg.NoTag {
+		sp.Cover = chooseCover(metadata.Files)
+	}
+
+	sp.Files = files
+	return nil
+}
+
+// estimatePlans totals the files and bytes of a planned run, noting how much
+// is already on disk and will be skipped
+func estimatePlans(cfg *Config, plans []*ShowPlan) PlanEstimate {
+	var estimate PlanEstimate
+	for _, plan := range plans {
+		if len(plan.Sources) == 0 {
+			continue
+		}
+		estimate.Shows++
+
+		for _, sp := range plan.Sources {
+			manifest, err := loadManifest(sp.ShowDir)
+			if err != nil {
+				manifest = &Manifest{}
+			}
+			for _, file := range sp.Files {
+				estimate.Files++
+				size, err := parseFileSize(file.Size)
+				if err != nil {
+					estimate.UnknownSizes++
+					continue
+				}
+				estimate.Bytes += size
+
+				fileName, _ := localFileNames(file, cfg)
+				if info, err := os.Stat(filepath.Join(sp.ShowDir, fileName)); err == nil && (info.Size() == size || isTaggedCopy(manifest, fileName, info.Size())) {
+					estimate.ExistingBytes += size
+				}
+			}
+		}
+	}
+	return estimate
+}
+
+// printPlans lists every planned show with its sources, their files, and
+// their size, for -dry-run
+func printPlans(plans []*ShowPlan) {
+	logger.Println("")
+	for _, plan := range plans {
+		logger.Printf("%s\n", showAlbum(plan.Show))
+		if plan.Note != "" {
+			logger.Printf("  %s\n", plan.Note)
+		}
+		for _, sp := range plan.Sources {
+			kind := "AUD"
+			if sp.Source.IsSoundboard {
+				kind = "SBD"
+			}
+			if sp.Identifier == "" {
+				logger.Printf("  - %s source without an archive.org link or track URLs, skipped\n", kind)
+				continue
+			}
+			if sp.TrackURLs {
+				kind += " from relisten.org track URLs"
+			}
+
+			var size int64
+			unknown := 0
+			for _, file := range sp.Files {
+				if n, err := parseFileSize(file.Size); err == nil {
+					size += n
+				} else {
+					unknown++
+				}
+			}
+			logger.Printf("  - %s %s, rating %.2f from %d review(s): %d file(s), %s", sp.Identifier, kind, sp.Source.AvgRating, sourceReviews(sp.Source), len(sp.Files), ByteSize(size))
+			if unknown > 0 {
+				logger.Printf(" (%d of unknown size)", unknown)
+			}
+			logger.Printf(" -> %s\n", sp.ShowDir)
+		}
+	}
+}
+
+// Print logs the estimate
+func (e PlanEstimate) Print() {
+	logger.Println("")
+	logger.Printf("Plan: %d show(s), %d file(s), %s total", e.Shows, e.Files, ByteSize(e.Bytes))
+	if e.ExistingBytes > 0 {
+		logger.Printf(", %s already downloaded, %s to fetch", ByteSize(e.ExistingBytes), ByteSize(e.Bytes-e.ExistingBytes))
+	}
+	logger.Println("")
+	if e.UnknownSizes > 0 {
+		logger.Printf("  (%d file(s) of unknown size not included)\n", e.UnknownSizes)
+	}
+}
+
+// confirm asks a yes/no question on the terminal. When stdin isn't a
+// terminal (cron, CI) there is nobody to ask, so it declines and -yes is
+// needed to go ahead.
+func confirm(question string) bool {
+	if !isTerminal(os.Stdin) {
+		logger.Error("Can't ask %q: stdin is not a terminal. Pass -yes to go ahead without asking", question)
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// InfoFileName is the file describing the source of a show directory
+const InfoFileName = "info.txt"
+
+var (
+	htmlBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+	htmlTagPattern   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// writeShowFiles writes the playlist and info.txt of a downloaded source
+// into its show directory, unless -no-playlist or -no-info. Failures are
+// logged; the downloads themselves are fine.
+func writeShowFiles(cfg *Config, show Show, sp *SourcePlan) {
+	manifest, err := loadManifest(sp.ShowDir)
+	if err != nil {
+		logger.Warn("Not writing playlist or info for %s: %v", sp.ShowDir, err)
+		return
+	}
+	// Never overwrite a file of the item that happens to have the same name
+	free := func(name string) bool {
+		_, ok := manifest.Lookup(name)
+		return !ok
+	}
+
+	if !cfg.NoPlaylist {
+		for name, data := range showPlaylists(cfg, show, sp) {
+			if !free(name) {
+				continue
+			}
+			if err := os.WriteFile(filepath.Join(sp.ShowDir, name), data, 0644); err != nil {
+				logger.Warn("Failed to write playlist %s: %v", name, err)
+			}
+		}
+	}
+	if !cfg.NoInfo && free(InfoFileName) {
+		if err := os.WriteFile(filepath.Join(sp.ShowDir, InfoFileName), showInfo(cfg, show, sp), 0644); err != nil {
+			logger.Warn("Failed to write %s: %v", InfoFileName, err)
+		}
+	}
+}
+
+// showPlaylists builds an extended M3U playlist of the downloaded audio
+// files of a source in set list order, by file name. The playlist is named
+// after the show directory; with more than one audio format, each format
+// gets its own, like "1977-05-08 (flac).m3u8".
+func showPlaylists(cfg *Config, show Show, sp *SourcePlan) map[string][]byte {
+	sets := append([]Set(nil), sp.Source.Sets...)
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].Index < sets[j].Index })
+	listed := setListTracks(sets)
+	byExt := audioOrder(sp.Files, len(sets), matchSets(sp.Files, sets), func(ArchiveFile) bool { return true })
+
+	base := sanitizeFilename(filepath.Base(sp.ShowDir))
+	playlists := make(map[string][]byte)
+	for ext, indexes := range byExt {
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n")
+		entries := 0
+		for _, i := range indexes {
+			file := sp.Files[i]
+			fileName, _ := localFileNames(file, cfg)
+			if _, err := os.Stat(filepath.Join(sp.ShowDir, fileName)); err != nil {
+				continue // Not downloaded
+			}
+
+			length := int64(-1)
+			if track, ok := listed[fileBase(file.Name)]; ok && track.Duration > 0 {
+				length = track.Duration
+			}
+			fmt.Fprintf(&b, "#EXTINF:%d,%s - %s\n", length, bandDisplayName(cfg.Band), fileTitle(file, fileName, listed))
+			b.WriteString(filepath.ToSlash(fileName) + "\n")
+			entries++
+		}
+		if entries == 0 {
+			continue
+		}
+
+		name := base + ".m3u8"
+		if len(byExt) > 1 {
+			name = fmt.Sprintf("%s (%s).m3u8", base, strings.TrimPrefix(ext, "."))
+		}
+		playlists[name] = []byte(b.String())
+	}
+	return playlists
+}
+
+// showInfo describes a source for info.txt: the show, where the recording
+// came from, its rating, and Relisten's description and taper notes
+func showInfo(cfg *Config, show Show, sp *SourcePlan) []byte {
+	source := sp.Source
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n\n", bandDisplayName(cfg.Band), showAlbum(show))
+
+	field := func(name, value string) {
+		if value = strings.TrimSpace(plainText(value)); value != "" {
+			fmt.Fprintf(&b, "%-12s %s\n", name+":", strings.ReplaceAll(value, "\n", "\n"+strings.Repeat(" ", 13)))
+		}
+	}
+	field("Identifier", sp.Identifier)
+	if isArchiveItem(sp.Identifier) {
+		field("archive.org", fmt.Sprintf("%s/details/%s", ArchiveAPIBase, sp.Identifier))
+	}
+	if source.IsSoundboard {
+		field("Recording", "Soundboard")
+	} else {
+		field("Recording", "Audience")
+	}
+	field("Source", source.Source)
+	field("Lineage", source.Lineage)
+	field("Taper", source.Taper)
+	field("Transferrer", source.Transferrer)
+	if source.AvgRating > 0 {
+		field("Rating", fmt.Sprintf("%.2f from %d review(s)", source.AvgRating, sourceReviews(source)))
+	}
+
+	section := func(title, text string) {
+		if text = strings.TrimSpace(plainText(text)); text != "" {
+			fmt.Fprintf(&b, "\n%s\n%s\n%s\n", title, strings.Repeat("-", len(title)), text)
+		}
+	}
+	section("Description", source.Description)
+	section("Taper Notes", source.TaperNotes)
+
+	sets := append([]Set(nil), source.Sets...)
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].Index < sets[j].Index })
+	var setList strings.Builder
+	for i, name := range setDirNames(sets) {
+		if len(sets) > 1 {
+			fmt.Fprintf(&setList, "%s:\n", name)
+		}
+		for _, track := range sets[i].Tracks {
+			fmt.Fprintf(&setList, "  %s\n", track.Title)
+		}
+	}
+	section("Set List", setList.String())
+
+	return []byte(b.String())
+}
+
+// plainText turns the HTML of Relisten descriptions into plain text
+func plainText(s string) string {
+	s = htmlBreakPattern.ReplaceAllString(s, "\n")
+	s = htmlTagPattern.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
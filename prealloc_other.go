@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+// preallocate extends f to size bytes; platforms without fallocate don't
+// reserve the blocks up front
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+)
+
+// Progress output modes accepted by -progress
+const (
+	ProgressAuto  = "auto"
+	ProgressBar   = "bar"
+	ProgressPlain = "plain"
+	ProgressNone  = "none"
+	ProgressJSON  = "json"
+)
+
+// asciiMarks spells out the status symbols of the console output for -ascii
+var asciiMarks = strings.NewReplacer("✓", "[ok]", "✗", "[failed]", "⚠", "[warning]", "⏱", "[timeout]")
+
+// plainProgressInterval is how often plain mode prints a summary line
+const plainProgressInterval = 10 * time.Second
+
+// ProgressReporter renders the progress of the file downloads of one batch
+type ProgressReporter interface {
+	// Start begins tracking a file; total is -1 or 0 when the size is unknown
+	Start(name string, total int64) FileProgress
+	// Wait blocks until every file of the batch is finished and flushes the output
+	Wait()
+}
+
+// FileProgress tracks a single file transfer
+type FileProgress interface {
+	// ProxyReader wraps r so that bytes read from it are counted
+	ProxyReader(r io.Reader) io.Reader
+	// Done marks the transfer as complete
+	Done()
+	// Abort drops the transfer after a failed attempt
+	Abort()
+}
+
+// resolveProgressMode picks the concrete output mode, falling back to plain
+// lines when stdout isn't a terminal (cron, CI, `| tee`)
+func resolveProgressMode(mode string) (string, error) {
+	switch mode {
+	case "", ProgressAuto:
+		if isTerminal(os.Stdout) {
+			return ProgressBar, nil
+		}
+		return ProgressPlain, nil
+	case ProgressBar, ProgressPlain, ProgressNone, ProgressJSON:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown progress mode %q (use bar, plain, none, or json)", mode)
+}
+
+// consoleWriter returns where human-readable output goes; JSON progress
+// owns stdout, so everything else moves to stderr
+func consoleWriter(mode string) io.Writer {
+	if mode == ProgressJSON {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// isTerminal reports whether f is attached to a character device such as a TTY
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgressReporter creates a reporter for a batch of downloads of a show
+// tracked by wg. When progress events are enabled, the reporter also emits them.
+func newProgressReporter(mode, show string, wg *sync.WaitGroup) ProgressReporter {
+	mode, err := resolveProgressMode(mode)
+	if err != nil {
+		mode = ProgressBar
+	}
+
+	var reporter ProgressReporter
+	switch mode {
+	case ProgressPlain:
+		reporter = newPlainProgress(wg)
+	case ProgressNone, ProgressJSON:
+		reporter = &noProgress{wg: wg}
+	default:
+		if sharedBars != nil {
+			reporter = &barProgress{p: sharedBars, shared: true, wg: wg}
+		} else {
+			reporter = &barProgress{p: mpb.New(mpb.WithWaitGroup(wg))}
+		}
+	}
+
+	if events.Enabled() {
+		reporter = newEventProgress(reporter, show)
+	}
+	return reporter
+}
+
+// truncateName shortens long file names for display
+func truncateName(name string) string {
+	maxNameLen := 40
+	if len(name) > maxNameLen {
+		return name[:maxNameLen-3] + "..."
+	}
+	return name
+}
+
+// sharedBars is the bar container of a run with -parallel-shows, so the
+// bars of shows downloading at the same time render as one block
+var sharedBars *mpb.Progress
+
+func newSharedBars() *mpb.Progress {
+	return mpb.New()
+}
+
+// barProgress renders animated progress bars. A shared container outlives
+// the batch, so finished bars are removed from it to make room for other shows.
+type barProgress struct {
+	p      *mpb.Progress
+	shared bool
+	wg     *sync.WaitGroup
+}
+
+// barOptions returns the options of a new bar
+func (b *barProgress) barOptions(options ...mpb.BarOption) []mpb.BarOption {
+	if b.shared {
+		options = append(options, mpb.BarRemoveOnComplete())
+	}
+	return options
+}
+
+func (b *barProgress) Start(name string, total int64) FileProgress {
+	truncatedName := truncateName(name)
+
+	var bar *mpb.Bar
+	if total > 0 {
+		// Content length is known, show byte progress with speed
+		bar = b.p.AddBar(total, b.barOptions(
+			mpb.PrependDecorators(
+				decor.Name(truncatedName, decor.WCSyncWidth),
+			),
+			mpb.AppendDecorators(
+				decor.CountersKibiByte("% .2f / % .2f"),
+				decor.Percentage(decor.WCSyncSpace),
+				decor.Name(" | "),
+				decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 30),
+			),
+		)...)
+	} else {
+		// Content length unknown, show indeterminate progress with speed
+		bar = b.p.AddBar(0, b.barOptions(
+			mpb.BarFillerClearOnComplete(),
+			mpb.PrependDecorators(
+				decor.Name(truncatedName, decor.WCSyncWidth),
+			),
+			mpb.AppendDecorators(
+				decor.CountersKibiByte("% .2f"),
+				decor.Name(" | "),
+				decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 30),
+			),
+		)...)
+	}
+	return &barFile{bar: bar}
+}
+
+func (b *barProgress) Wait() {
+	if b.shared {
+		b.wg.Wait()
+		return
+	}
+	b.p.Wait()
+}
+
+type barFile struct {
+	bar *mpb.Bar
+}
+
+func (f *barFile) ProxyReader(r io.Reader) io.Reader {
+	return f.bar.ProxyReader(r)
+}
+
+// Done marks the bar complete, which matters when the content length was unknown
+func (f *barFile) Done() {
+	f.bar.SetTotal(-1, true)
+}
+
+// Abort drops the bar so the progress container doesn't wait on it
+func (f *barFile) Abort() {
+	f.bar.Abort(true)
+}
+
+// plainProgress prints periodic one-line summaries instead of redrawing bars,
+// which keeps redirected logs readable
+type plainProgress struct {
+	wg     *sync.WaitGroup
+	mu     sync.Mutex
+	active map[*plainFile]struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newPlainProgress(wg *sync.WaitGroup) *plainProgress {
+	p := &plainProgress{
+		wg:     wg,
+		active: make(map[*plainFile]struct{}),
+		done:   make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+func (p *plainProgress) loop() {
+	ticker := time.NewTicker(plainProgressInterval)
+	defer ticker.Stop()
+
+	var lastBytes int64
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			var read, total int64
+			for f := range p.active {
+				read += f.read.Load()
+				if f.total > 0 {
+					total += f.total
+				}
+			}
+			count := len(p.active)
+			p.mu.Unlock()
+
+			if count == 0 {
+				continue
+			}
+			speed := ByteSize(float64(max(read-lastBytes, 0)) / plainProgressInterval.Seconds())
+			lastBytes = read
+			if total > 0 {
+				logger.Printf("      progress: %d active, %s / %s (%.0f%%), %s/s\n",
+					count, ByteSize(read), ByteSize(total), float64(read)*100/float64(total), speed)
+			} else {
+				logger.Printf("      progress: %d active, %s, %s/s\n", count, ByteSize(read), speed)
+			}
+		}
+	}
+}
+
+func (p *plainProgress) Start(name string, total int64) FileProgress {
+	f := &plainFile{parent: p, name: name, total: total, started: time.Now()}
+	p.mu.Lock()
+	p.active[f] = struct{}{}
+	p.mu.Unlock()
+	return f
+}
+
+func (p *plainProgress) Wait() {
+	p.wg.Wait()
+	p.once.Do(func() { close(p.done) })
+}
+
+func (p *plainProgress) remove(f *plainFile) {
+	p.mu.Lock()
+	delete(p.active, f)
+	p.mu.Unlock()
+}
+
+type plainFile struct {
+	parent  *plainProgress
+	name    string
+	total   int64
+	started time.Time
+	read    atomic.Int64
+}
+
+func (f *plainFile) ProxyReader(r io.Reader) io.Reader {
+	return &countingReader{r: r, count: &f.read}
+}
+
+func (f *plainFile) Done() {
+	f.parent.remove(f)
+	logger.Printf("      %s finished (%s in %s)\n",
+		truncateName(f.name), ByteSize(f.read.Load()), time.Since(f.started).Round(time.Second))
+}
+
+func (f *plainFile) Abort() {
+	f.parent.remove(f)
+}
+
+// noProgress discards all progress output
+type noProgress struct {
+	wg *sync.WaitGroup
+}
+
+func (n *noProgress) Start(name string, total int64) FileProgress {
+	return noFile{}
+}
+
+func (n *noProgress) Wait() {
+	n.wg.Wait()
+}
+
+type noFile struct{}
+
+func (noFile) ProxyReader(r io.Reader) io.Reader { return r }
+func (noFile) Done()                             {}
+func (noFile) Abort()                            {}
+
+// countingReader adds the number of bytes read to count
+type countingReader struct {
+	r     io.Reader
+	count *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Providers a show's listing and sources can come from. relisten.org is the
+// normal one; the others stand in while it is down.
+const (
+	ProviderRelisten = "relisten"
+	ProviderCache    = "cache" // An earlier relisten.org response from the metadata cache
+	ProviderArchive  = "archive.org"
+)
+
+// errRelistenDown is returned for relisten.org requests that failed with a
+// server error or no response at all
+var errRelistenDown = errors.New("relisten.org is unavailable")
+
+// archiveCollection is the -archive-collection override searched while
+// relisten.org is down; empty derives the collection from the band
+var archiveCollection string
+
+// getRelisten fetches a relisten.org API URL into v and returns the provider
+// that served it. When relisten.org is down, an earlier response of the same
+// URL from the metadata cache is used, however old it is.
+func getRelisten(url string, v interface{}) (string, error) {
+	resp, err := apiGet(url)
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return ProviderRelisten, json.NewDecoder(resp.Body).Decode(v)
+		}
+		if resp.StatusCode < 500 {
+			return "", fmt.Errorf("API returned status %d", resp.StatusCode)
+		}
+		err = fmt.Errorf("%w: API returned status %d", errRelistenDown, resp.StatusCode)
+	} else if errors.Is(err, errNotCached) {
+		return "", err
+	} else {
+		err = fmt.Errorf("%w: %v", errRelistenDown, err)
+	}
+
+	if apiCache != nil {
+		if data, readErr := os.ReadFile(apiCache.path(url)); readErr == nil {
+			if json.Unmarshal(data, v) == nil {
+				logger.Warn("%v, using the cached response of %s", err, url)
+				return ProviderCache, nil
+			}
+		}
+	}
+	return "", err
+}
+
+// bandCollection returns the archive.org collection of a band, e.g.
+// GratefulDead for grateful-dead, unless -archive-collection names it
+func bandCollection(band string) string {
+	if archiveCollection != "" {
+		return archiveCollection
+	}
+	var b strings.Builder
+	for _, word := range strings.Split(band, "-") {
+		if word != "" {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
+
+// archiveSearchDoc is an item of an archive.org advanced search
+type archiveSearchDoc struct {
+	Identifier string       `json:"identifier"`
+	Title      searchString `json:"title"`
+	Date       string       `json:"date"`
+	Venue      searchString `json:"venue"`
+	Coverage   searchString `json:"coverage"`
+	AvgRating  float64      `json:"avg_rating"`
+	NumReviews int64        `json:"num_reviews"`
+}
+
+// searchString is a metadata field of a search result, which archive.org
+// returns as a list when an item has several values; the first is kept
+type searchString string
+
+func (s *searchString) UnmarshalJSON(data []byte) error {
+	var values []string
+	if err := json.Unmarshal(data, &values); err == nil {
+		if len(values) > 0 {
+			*s = searchString(values[0])
+		}
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*s = searchString(value)
+	return nil
+}
+
+// searchArchive lists the items of a band's archive.org collection that
+// match query
+func searchArchive(band, query string) ([]archiveSearchDoc, error) {
+	params := url.Values{}
+	params.Set("q", fmt.Sprintf("collection:(%s) AND %s", bandCollection(band), query))
+	for _, field := range []string{"identifier", "title", "date", "venue", "coverage", "avg_rating", "num_reviews"} {
+		params.Add("fl[]", field)
+	}
+	params.Set("rows", "10000")
+	params.Set("output", "json")
+
+	resp, err := apiGet(fmt.Sprintf("%s/advancedsearch.php?%s", ArchiveAPIBase, params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("archive.org search returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Response struct {
+			Docs []archiveSearchDoc `json:"docs"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Response.Docs, nil
+}
+
+// archiveShows groups archive.org items into shows by date, each item being
+// a source. Ratings and soundboards are all there is to choose sources by;
+// set lists and tapers are only known to relisten.org.
+func archiveShows(docs []archiveSearchDoc) []Show {
+	byDate := make(map[string]*Show)
+	var dates []string
+	for _, doc := range docs {
+		if len(doc.Date) < len("2006-01-02") {
+			continue
+		}
+		date := doc.Date[:len("2006-01-02")]
+		show := byDate[date]
+		if show == nil {
+			show = &Show{
+				Date:        date,
+				DisplayDate: date,
+				Venue:       Venue{Name: string(doc.Venue), Location: string(doc.Coverage)},
+				Provider:    ProviderArchive,
+			}
+			byDate[date] = show
+			dates = append(dates, date)
+		}
+
+		lower := strings.ToLower(doc.Identifier + " " + string(doc.Title))
+		show.Sources = append(show.Sources, Source{
+			Links:              []Link{{URL: fmt.Sprintf("%s/details/%s", ArchiveAPIBase, doc.Identifier), Label: "View on archive.org"}},
+			DisplayDate:        date,
+			IsSoundboard:       strings.Contains(lower, "sbd") || strings.Contains(lower, "soundboard"),
+			AvgRating:          doc.AvgRating,
+			NumReviews:         doc.NumReviews,
+			UpstreamIdentifier: doc.Identifier,
+		})
+	}
+
+	sort.Strings(dates)
+	shows := make([]Show, 0, len(dates))
+	for _, date := range dates {
+		shows = append(shows, *byDate[date])
+	}
+	return shows
+}
+
+// searchArchiveYear lists a band's shows of a year on archive.org
+func searchArchiveYear(band, year string) ([]Show, error) {
+	docs, err := searchArchive(band, fmt.Sprintf("year:%s", year))
+	if err != nil {
+		return nil, err
+	}
+	return archiveShows(docs), nil
+}
+
+// searchArchiveShow finds the sources of a band's show on archive.org
+func searchArchiveShow(band, date string) (*ShowDetail, error) {
+	docs, err := searchArchive(band, fmt.Sprintf("date:%s", date))
+	if err != nil {
+		return nil, err
+	}
+	detail := &ShowDetail{DisplayDate: date, Provider: ProviderArchive}
+	for _, show := range archiveShows(docs) {
+		detail.Sources = append(detail.Sources, show.Sources...)
+	}
+	return detail, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pushgatewayTimeout bounds a push, so an unreachable Pushgateway can't hang a cron run
+const pushgatewayTimeout = 10 * time.Second
+
+// RunMetrics are the final numbers of a run pushed with -pushgateway-url
+type RunMetrics struct {
+	Started time.Time
+	Files   int
+	Failed  int
+	Bytes   int64
+	Status  string // complete, aborted, paused, or cancelled
+}
+
+// checkPushgatewayURL validates -pushgateway-url
+func checkPushgatewayURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -pushgateway-url %q: want an http:// or https:// URL", raw)
+	}
+	return nil
+}
+
+// pushgatewayGroupURL returns the URL of the run's metrics group, job
+// dead-dl with the band and run as labels. The run label is base64-encoded,
+// since years like 1972,1977 or tour names can't go into a path as they are.
+func pushgatewayGroupURL(cfg *Config) string {
+	return fmt.Sprintf("%s/metrics/job/dead-dl/band/%s/run@base64/%s",
+		strings.TrimRight(cfg.PushgatewayURL, "/"), url.PathEscape(cfg.Band),
+		base64.RawURLEncoding.EncodeToString([]byte(runLabel(cfg))))
+}
+
+// formatRunMetrics renders metrics in the Prometheus text format. The last
+// success time is only written by complete runs; since pushes replace only
+// the metrics they contain, it keeps the time of the last complete run, which
+// is what an alert on silently failing nightly runs needs.
+func formatRunMetrics(m RunMetrics) []byte {
+	var b bytes.Buffer
+	gauge := func(name, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+
+	now := time.Now()
+	gauge("dead_dl_run_duration_seconds", "Duration of the last run.", now.Sub(m.Started).Seconds())
+	gauge("dead_dl_run_bytes", "Bytes transferred by the last run.", m.Bytes)
+	gauge("dead_dl_run_files_downloaded", "Files downloaded by the last run.", m.Files)
+	gauge("dead_dl_run_files_failed", "Files that failed after all retries in the last run.", m.Failed)
+	fmt.Fprintf(&b, "# HELP dead_dl_run_status Status of the last run.\n# TYPE dead_dl_run_status gauge\n")
+	for _, status := range []string{"complete", "aborted", "paused", "cancelled"} {
+		value := 0
+		if status == m.Status {
+			value = 1
+		}
+		fmt.Fprintf(&b, "dead_dl_run_status{status=%q} %d\n", status, value)
+	}
+	gauge("dead_dl_last_run_timestamp_seconds", "Time the last run ended.", now.Unix())
+	if m.Status == "complete" {
+		gauge("dead_dl_last_success_timestamp_seconds", "Time the last complete run ended.", now.Unix())
+	}
+	return b.Bytes()
+}
+
+// pushMetrics pushes the final metrics of a run to -pushgateway-url.
+// Failures are logged and never fail the run.
+func pushMetrics(cfg *Config, m RunMetrics) {
+	if cfg.PushgatewayURL == "" {
+		return
+	}
+
+	target := pushgatewayGroupURL(cfg)
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(formatRunMetrics(m)))
+	if err != nil {
+		logger.Warn("Failed to push metrics: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Transport: retryTransport{}, Timeout: pushgatewayTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Warn("Failed to push metrics to %s: %v", cfg.PushgatewayURL, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logger.Warn("Failed to push metrics to %s: status %d", cfg.PushgatewayURL, resp.StatusCode)
+		return
+	}
+	logger.Debug("Pushed run metrics to %s", target)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// QuarantineDirName is the folder under the output directory that files
+// failing verification are moved into, laid out like the show directories
+const QuarantineDirName = "quarantine"
+
+// errChecksumMismatch is returned when a transfer's md5 differs from the archive metadata
+var errChecksumMismatch = errors.New("md5 mismatch")
+
+// quarantineFile moves src, a file that failed verification and was meant
+// to be at filePath, into the quarantine folder and writes a .reason.txt
+// next to it, so it can be inspected before it is downloaded again
+func quarantineFile(cfg *Config, src, filePath, url, reason string) (string, error) {
+	rel, err := filepath.Rel(cfg.OutputDir, filePath)
+	if err != nil || rel == ".." || filepath.IsAbs(rel) || len(rel) > 2 && rel[:3] == ".."+string(filepath.Separator) {
+		rel = filepath.Base(filePath)
+	}
+
+	dest := filepath.Join(cfg.OutputDir, QuarantineDirName, rel)
+	if _, err := os.Stat(dest); err == nil {
+		// Keep earlier quarantined copies
+		dest = fmt.Sprintf("%s.%s", dest, time.Now().Format("20060102-150405"))
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(src, dest); err != nil {
+		return "", err
+	}
+
+	note := fmt.Sprintf("File: %s\nURL: %s\nReason: %s\nQuarantined: %s\n",
+		filePath, url, reason, time.Now().Format(time.RFC3339))
+	if err := os.WriteFile(dest+".reason.txt", []byte(note), 0644); err != nil {
+		logger.Warn("Failed to write quarantine reason for %s: %v", dest, err)
+	}
+	logger.Warn("Quarantined %s: %s", filepath.Base(filePath), reason)
+	return dest, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventQuotaReached is emitted once when the monthly quota stops new downloads
+const EventQuotaReached = "quota_reached"
+
+// monthlyQuota limits the bytes downloaded per calendar month, counting the
+// runs recorded in the catalog as well as the current one
+type monthlyQuota struct {
+	limit   ByteSize
+	mu      sync.Mutex
+	history map[string]int64 // Bytes per month (YYYY-MM) from earlier runs
+}
+
+// quota is the limit of the current run; nil means no limit. quotaKey is
+// the -quota and catalog it was set up from.
+var (
+	quota    *monthlyQuota
+	quotaKey string
+	quotaMu  sync.Mutex
+)
+
+// newMonthlyQuota loads the usage of earlier runs from the catalog
+func newMonthlyQuota(limit ByteSize, catalog *Catalog) *monthlyQuota {
+	q := &monthlyQuota{limit: limit, history: make(map[string]int64)}
+	for day, bytes := range catalog.Bandwidth {
+		if len(day) >= 7 {
+			q.history[day[:7]] += bytes
+		}
+	}
+	return q
+}
+
+// Used returns the bytes downloaded so far this month
+func (q *monthlyQuota) Used() int64 {
+	month := time.Now().Format("2006-01")
+	q.mu.Lock()
+	used := q.history[month]
+	q.mu.Unlock()
+	for day, bytes := range bandwidth.Days() {
+		if strings.HasPrefix(day, month) {
+			used += bytes
+		}
+	}
+	return used
+}
+
+// Record moves flushed bytes from the meter into the usage of earlier runs
+func (q *monthlyQuota) Record(days map[string]int64) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for day, bytes := range days {
+		if len(day) >= 7 {
+			q.history[day[:7]] += bytes
+		}
+	}
+}
+
+// Reached reports whether no new downloads may start this month
+func (q *monthlyQuota) Reached() bool {
+	return q != nil && q.limit > 0 && q.Used() >= int64(q.limit)
+}
+
+// nextQuotaPeriod returns when the quota resets
+func nextQuotaPeriod(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+}
+
+// setupQuota sets the quota of the run from -quota and the usage recorded in
+// the catalog. It reports true when the quota is already used up.
+func setupQuota(cfg *Config, store CatalogStore, summary *RunSummary) bool {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if cfg.Quota <= 0 {
+		quota, quotaKey = nil, ""
+		return false
+	}
+
+	// Runs sharing the process with the same -quota and catalog, like jobs
+	// side by side or -band all artists, share the quota; recorded bytes are
+	// moved into its history as they are flushed. Jobs with another quota run
+	// one at a time (see processSettings) and set up their own.
+	key := fmt.Sprint(cfg.Quota, catalogFile(cfg))
+	if quota == nil || quotaKey != key {
+		catalog, err := store.Load()
+		if err != nil {
+			logger.Warn("Failed to load catalog, the quota only counts this run: %v", err)
+			catalog = &Catalog{}
+		}
+		quota, quotaKey = newMonthlyQuota(cfg.Quota, catalog), key
+	}
+	return checkQuota(summary)
+}
+
+// checkQuota stops new downloads once the quota is used up; the first caller
+// to notice logs it and emits a quota_reached event
+func checkQuota(summary *RunSummary) bool {
+	if !quota.Reached() {
+		return false
+	}
+	if summary.SetQuotaReached() {
+		logger.Warn("Monthly quota of %s reached (%s used), pausing new downloads until %s",
+			quota.limit, ByteSize(quota.Used()), nextQuotaPeriod(time.Now()).Format("2006-01-02"))
+		events.Emit(ProgressEvent{Type: EventQuotaReached, Bytes: quota.Used(), Total: int64(quota.limit)})
+	}
+	return true
+}
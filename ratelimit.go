@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces out requests evenly; a zero interval means no limit
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// apiLimiter is the process-wide limit on relisten.org and archive.org API
+// requests, shared by every artist, show, and source of a run
+var apiLimiter = &rateLimiter{}
+
+// SetRate allows perSecond requests per second; 0 removes the limit
+func (l *rateLimiter) SetRate(perSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = 0
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+// Wait blocks until the next request may be made
+func (l *rateLimiter) Wait() {
+	l.mu.Lock()
+	if l.interval == 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// apiGet fetches an API URL once the rate limit allows it, going through
+// the metadata cache when there is one
+func apiGet(url string) (*http.Response, error) {
+	if apiCache != nil {
+		if apiCache.offline {
+			return apiCache.Get(url)
+		}
+		apiLimiter.Wait()
+		return apiCache.Get(url)
+	}
+	apiLimiter.Wait()
+	return apiClient.Get(url)
+}
+
+// byteLimiter caps a transfer rate in bytes per second; a zero rate means
+// no limit
+type byteLimiter struct {
+	mu   sync.Mutex
+	rate ByteSize
+	next time.Time
+}
+
+// downloadLimiter is the -rate limit shared by every transfer of the process
+var downloadLimiter = &byteLimiter{}
+
+// SetRate changes the limit; 0 removes it
+func (l *byteLimiter) SetRate(rate ByteSize) {
+	l.mu.Lock()
+	l.rate = rate
+	l.mu.Unlock()
+}
+
+// Limited reports whether the limiter has a rate
+func (l *byteLimiter) Limited() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate > 0
+}
+
+// WaitN blocks until n more bytes fit into the rate
+func (l *byteLimiter) WaitN(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// limitedReader reads through one or more byte limiters, e.g. the global
+// -rate and the transfer's own -per-file-rate
+type limitedReader struct {
+	r        io.Reader
+	limiters []*byteLimiter
+}
+
+// limitChunk keeps reads small, so limited transfers flow evenly instead of
+// in bursts
+const limitChunk = 32 << 10
+
+// limitReader wraps r in the limiters that have a rate
+func limitReader(r io.Reader, limiters ...*byteLimiter) io.Reader {
+	var active []*byteLimiter
+	for _, l := range limiters {
+		if l.Limited() {
+			active = append(active, l)
+		}
+	}
+	if len(active) == 0 {
+		return r
+	}
+	return &limitedReader{r: r, limiters: active}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitChunk {
+		p = p[:limitChunk]
+	}
+	n, err := r.r.Read(p)
+	for _, l := range r.limiters {
+		l.WaitN(n)
+	}
+	return n, err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// retryBaseDelay is the wait before the first retry of a file; it doubles
+// with every attempt, up to retryMaxDelay
+const (
+	retryBaseDelay = 2 * time.Second
+	retryMaxDelay  = 2 * time.Minute
+)
+
+// errFileTimeout is the cause of a download cancelled by -file-timeout
+var errFileTimeout = errors.New("file download timed out")
+
+// errSizeMismatch is returned when a transfer ended with fewer or more bytes than expected
+var errSizeMismatch = errors.New("size mismatch")
+
+// downloadWithRetries downloads a file, retrying up to -max-retries-per-file times on failure.
+// Restricted files (401/403) and a full disk are not retried since they will never succeed, and
+// timed-out files are left to the caller's retry queue.
+func downloadWithRetries(url, filePath, displayName string, expectedSize int64, expectedMD5 string, progress ProgressReporter, cfg *Config) error {
+	maxRetries := cfg.MaxRetries
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := backoff(attempt-1, retryBaseDelay, retryMaxDelay)
+			logger.Printf("    - Retrying %s in %s (attempt %d/%d): %v\n", displayName, delay.Round(time.Second), attempt, maxRetries, err)
+			time.Sleep(delay)
+		}
+
+		err = downloadFile(url, filePath, displayName, expectedSize, expectedMD5, progress, cfg)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// isRetryable reports whether a download error may succeed on another attempt
+func isRetryable(err error) bool {
+	if errors.Is(err, errFileTimeout) || errors.Is(err, errRunCancelled) || errors.Is(err, syscall.ENOSPC) {
+		return false
+	}
+	msg := err.Error()
+	return !strings.Contains(msg, "status 401") &&
+		!strings.Contains(msg, "status 403") &&
+		!strings.Contains(msg, "status 404")
+}
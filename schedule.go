@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Events emitted when -only-between starts holding back downloads and when
+// its window opens
+const (
+	EventScheduleWait = "schedule_wait"
+	EventScheduleOpen = "schedule_open"
+)
+
+// timeWindow is a parsed -only-between, in minutes after midnight. A window
+// whose end is before its start runs past midnight, like 22:00-06:00.
+type timeWindow struct {
+	start, end int
+}
+
+// parseTimeWindow parses -only-between, like 01:00-07:00; an empty value
+// returns nil
+func parseTimeWindow(value string) (*timeWindow, error) {
+	if value == "" {
+		return nil, nil
+	}
+	from, to, ok := strings.Cut(value, "-")
+	start, err1 := parseClock(from)
+	end, err2 := parseClock(to)
+	if !ok || err1 != nil || err2 != nil || start == end {
+		return nil, fmt.Errorf("invalid -only-between %q (use HH:MM-HH:MM, like 01:00-07:00 or 22:00-06:00)", value)
+	}
+	return &timeWindow{start: start, end: end}, nil
+}
+
+// parseClock parses HH:MM into minutes after midnight
+func parseClock(s string) (int, error) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(s), ":")
+	h, err1 := strconv.Atoi(hours)
+	m, err2 := strconv.Atoi(minutes)
+	if !ok || err1 != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 || len(minutes) != 2 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w *timeWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// Next returns when the window next opens after t, in t's time zone
+func (w *timeWindow) Next(t time.Time) time.Time {
+	open := time.Date(t.Year(), t.Month(), t.Day(), w.start/60, w.start%60, 0, 0, t.Location())
+	if !open.After(t) {
+		open = open.AddDate(0, 0, 1)
+	}
+	return open
+}
+
+// String formats the window the way -only-between takes it
+func (w *timeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+}
+
+// scheduleWaiters counts the downloads held back by -only-between, so only
+// the first of them logs the wait
+var scheduleWaiters atomic.Int64
+
+// waitForWindow blocks until -only-between allows downloads to start. Like
+// control.Wait, it reports false once the run is cancelled.
+func waitForWindow(cfg *Config) bool {
+	window, _ := parseTimeWindow(cfg.OnlyBetween) // Validated at startup
+	if window == nil || window.Contains(time.Now()) {
+		return !control.Cancelled()
+	}
+	open := window.Next(time.Now())
+	if scheduleWaiters.Add(1) == 1 {
+		logger.Info("Outside -only-between %s, waiting until %s", window, open.Format("Mon 15:04"))
+		events.Emit(ProgressEvent{Type: EventScheduleWait})
+	}
+	ok := sleepRun(time.Until(open))
+	if scheduleWaiters.Add(-1) == 0 && ok {
+		logger.Info("Inside -only-between %s, resuming downloads", window)
+		events.Emit(ProgressEvent{Type: EventScheduleOpen})
+	}
+	return ok
+}
+
+// pauseBetweenShows waits -pause-between-shows after a show that downloaded
+// something, so a long run leaves the connection idle now and then. It
+// reports false once the run is cancelled.
+func pauseBetweenShows(cfg *Config, downloaded bool) bool {
+	if cfg.PauseBetween <= 0 || !downloaded {
+		return !control.Cancelled()
+	}
+	logger.Debug("Pausing %s before the next show", cfg.PauseBetween)
+	return sleepRun(cfg.PauseBetween)
+}
+
+// sleepRun sleeps for d, returning early with false if the run is cancelled
+func sleepRun(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return !control.Cancelled()
+	case <-control.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// weightedRatingPrior is how many reviews at the middle of the rating scale
+// a source's own reviews are blended with for -use-weighted-rating, when
+// relisten.org doesn't provide a weighted rating
+const weightedRatingPrior = 5
+
+// sourceReviews returns the number of reviews of a source; relisten.org and
+// archive.org search results count them in different fields
+func sourceReviews(source Source) int64 {
+	return max(source.ReviewCount, source.NumReviews)
+}
+
+// sourceRatings returns the rating each source is ranked by: its average
+// rating, or with -use-weighted-rating one that trusts few reviews less.
+// relisten.org's weighted rating is used where it has one; otherwise the
+// rating is blended with weightedRatingPrior reviews at the middle of the
+// scale, so a 5-star source with two reviews doesn't beat a 4.5-star one
+// with forty. Ratings are out of 5 stars, or out of 10 if any is above 5.
+func sourceRatings(sources []Source, weighted bool) []float64 {
+	ratings := make([]float64, len(sources))
+	scale := 5.0
+	for i, source := range sources {
+		ratings[i] = source.AvgRating
+		if source.AvgRating > 5 {
+			scale = 10
+		}
+	}
+	if !weighted {
+		return ratings
+	}
+
+	middle := (1 + scale) / 2
+	for i, source := range sources {
+		if source.AvgRatingWeighted > 0 {
+			ratings[i] = source.AvgRatingWeighted
+			continue
+		}
+		if source.AvgRating <= 0 {
+			continue // Unrated
+		}
+		reviews := float64(sourceReviews(source))
+		ratings[i] = (reviews*source.AvgRating + weightedRatingPrior*middle) / (reviews + weightedRatingPrior)
+	}
+	return ratings
+}
+
+// bestSource picks the source -highest-rated downloads: with -prefer-sbd
+// any soundboard beats any audience recording, then the higher rating wins,
+// and between equal ratings the one with more reviews. It also returns why
+// the source was picked, or nil when no source is rated and there is no
+// soundboard to prefer.
+func bestSource(sources []Source, cfg *Config) (*Source, string) {
+	ratings := sourceRatings(sources, cfg.WeightedRating)
+	best := -1
+	better := func(i int) bool {
+		a, b := sources[i], sources[best]
+		if cfg.PreferSBD && a.IsSoundboard != b.IsSoundboard {
+			return a.IsSoundboard
+		}
+		if ratings[i] != ratings[best] {
+			return ratings[i] > ratings[best]
+		}
+		return sourceReviews(a) > sourceReviews(b)
+	}
+	for i, source := range sources {
+		if ratings[i] <= 0 && !(cfg.PreferSBD && source.IsSoundboard) {
+			continue
+		}
+		if best < 0 || better(i) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, ""
+	}
+
+	kind := "average"
+	if cfg.WeightedRating {
+		kind = "weighted"
+	}
+	reason := fmt.Sprintf("Selected highest rated source with %s rating %.2f from %d review(s)", kind, ratings[best], sourceReviews(sources[best]))
+	if cfg.PreferSBD && sources[best].IsSoundboard {
+		reason = strings.Replace(reason, "highest rated source", "highest rated soundboard", 1)
+	}
+	return &sources[best], reason
+}
+
+// matchesTaper reports whether -taper names the taper or transferrer of a source
+func matchesTaper(source Source, taper string) bool {
+	taper = strings.ToLower(taper)
+	return strings.Contains(strings.ToLower(source.Taper), taper) ||
+		strings.Contains(strings.ToLower(source.Transferrer), taper)
+}
+
+// compileLineage compiles -exclude-lineage, a regular expression matched
+// against a source's lineage without regard to case
+func compileLineage(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -exclude-lineage %q: %w", pattern, err)
+	}
+	return re, nil
+}
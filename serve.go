@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// DefaultServeListen is the address serve listens on by default
+const DefaultServeListen = ":8200"
+
+// runServe handles `dead-dl serve -dlna|-subsonic`, which serves the
+// downloaded collection on the LAN until interrupted
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	outputDir := fs.String("output", "./downloads", "Output directory to serve")
+	catalogFlag := fs.String("catalog", "", "Catalog file or postgres:// URL to use instead of the catalog under -output")
+	dlna := fs.Bool("dlna", false, "Serve the collection as a DLNA/UPnP media server")
+	subsonic := fs.Bool("subsonic", false, "Serve a Subsonic-compatible API for apps like DSub and Symfonium")
+	listen := fs.String("listen", DefaultServeListen, "Address to serve on")
+	name := fs.String("name", "dead-dl", "Name the server shows up as on TVs and receivers")
+	user := fs.String("user", "", "User name Subsonic apps log in with")
+	password := fs.String("password", "", "Password Subsonic apps log in with")
+	fs.Parse(args)
+
+	if !*dlna && !*subsonic {
+		fmt.Fprintln(os.Stderr, `Usage:
+  dead-dl serve -dlna [-output dir] [-catalog file] [-listen :8200] [-name dead-dl]
+  dead-dl serve -subsonic -user name -password secret [-output dir] [-catalog file] [-listen :8200]`)
+		os.Exit(2)
+	}
+
+	initLogger(os.Stderr)
+	defer logger.Close()
+
+	if *subsonic && (*user == "" || *password == "") {
+		logger.Fatal("-subsonic requires -user and -password")
+	}
+
+	store, err := openCatalog(&Config{OutputDir: *outputDir, Catalog: *catalogFlag})
+	if err != nil {
+		logger.Fatal("Failed to open catalog: %v", err)
+	}
+	defer store.Close()
+
+	root, err := filepath.Abs(*outputDir)
+	if err != nil {
+		logger.Fatal("Invalid -output: %v", err)
+	}
+
+	ln, err := net.Listen("tcp4", *listen)
+	if err != nil {
+		logger.Fatal("Failed to listen on %s: %v", *listen, err)
+	}
+
+	mux := http.NewServeMux()
+	var ssdp *ssdpServer
+	if *dlna {
+		srv := &dlnaServer{
+			store:    store,
+			root:     root,
+			name:     *name,
+			uuid:     dlnaUUID(root),
+			updateID: uint32(time.Now().Unix()),
+		}
+		srv.register(mux)
+
+		ssdp, err = newSSDPServer(srv.uuid, ln.Addr().(*net.TCPAddr).Port)
+		if err != nil {
+			logger.Fatal("Failed to start discovery: %v", err)
+		}
+		go ssdp.Serve()
+		logger.Info("Serving %s as DLNA server %q on %s", root, *name, ln.Addr())
+	}
+	if *subsonic {
+		srv := &subsonicServer{store: store, root: root, name: *name, user: *user, password: *password}
+		srv.register(mux)
+		logger.Info("Serving %s over the Subsonic API on %s", root, ln.Addr())
+	}
+
+	httpServer := &http.Server{Handler: mux}
+	go func() {
+		if err := httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Server failed: %v", err)
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+
+	if ssdp != nil {
+		ssdp.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	httpServer.Shutdown(ctx)
+	logger.Info("Server stopped")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// systemdServiceTemplate runs a single dead-dl pass; the timer below starts it on a schedule
+var systemdServiceTemplate = template.Must(template.New("service").Parse(`[Unit]
+Description=dead-dl live music sync
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+WorkingDirectory={{.WorkDir}}
+ExecStart={{.ExecStart}}
+`))
+
+var systemdTimerTemplate = template.Must(template.New("timer").Parse(`[Unit]
+Description=Run dead-dl live music sync on a schedule
+
+[Timer]
+OnCalendar={{.Schedule}}
+Persistent=true
+RandomizedDelaySec=15m
+
+[Install]
+WantedBy=timers.target
+`))
+
+// serviceConfig describes the service to install
+type serviceConfig struct {
+	Name      string
+	Schedule  string
+	WorkDir   string
+	Exe       string
+	Args      []string
+	ExecStart string
+}
+
+// runService handles `dead-dl service install [flags] -- <download flags>`
+func runService(args []string) {
+	if len(args) == 0 || args[0] != "install" {
+		fmt.Fprintln(os.Stderr, "Usage: dead-dl service install [-name dead-dl] [-schedule daily] [-user] [-dry-run] -- <download flags>")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("service install", flag.ExitOnError)
+	name := fs.String("name", "dead-dl", "Name of the service (and timer/task)")
+	schedule := fs.String("schedule", "daily", "When to run: a systemd OnCalendar expression on Linux, or DAILY/HOURLY/WEEKLY on Windows")
+	user := fs.Bool("user", false, "Install a systemd user unit instead of a system unit (Linux only)")
+	dryRun := fs.Bool("dry-run", false, "Print what would be installed without writing anything")
+	fs.Parse(args[1:])
+
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to locate the dead-dl binary: %v\n", err)
+		os.Exit(1)
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get working directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	svc := serviceConfig{
+		Name:     *name,
+		Schedule: *schedule,
+		WorkDir:  workDir,
+		Exe:      exe,
+		Args:     withYes(fs.Args()),
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		err = installSystemd(svc, *user, *dryRun)
+	case "windows":
+		err = installWindowsTask(svc, *dryRun)
+	default:
+		err = fmt.Errorf("service install is not supported on %s", runtime.GOOS)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to install service: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// confirmingCommands are the subcommands that ask for confirmation before
+// going ahead; "" is download without a subcommand
+var confirmingCommands = map[string]bool{
+	"": true, "download": true, "today": true, "sync": true, "upgrade": true, "gc": true, "run": true,
+}
+
+// withYes adds -yes to the arguments of a command that asks for
+// confirmation, since a scheduled run has no terminal to answer on and
+// would decline every time
+func withYes(args []string) []string {
+	command, flags := "", args
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, flags = args[0], args[1:]
+	}
+	if !confirmingCommands[command] {
+		return args
+	}
+	for _, arg := range flags {
+		if arg == "--" {
+			break
+		}
+		if name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "="); strings.HasPrefix(arg, "-") && name == "yes" {
+			return args
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Adding -yes, since nobody can answer confirmations of a scheduled run")
+	withYes := append([]string(nil), args[:len(args)-len(flags)]...)
+	return append(append(withYes, "-yes"), flags...)
+}
+
+// installSystemd writes a oneshot service and a timer that starts it on schedule
+func installSystemd(svc serviceConfig, user, dryRun bool) error {
+	unitDir := "/etc/systemd/system"
+	systemctl := "systemctl"
+	if user {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+		unitDir = filepath.Join(configDir, "systemd", "user")
+		systemctl = "systemctl --user"
+	}
+
+	quoted := []string{systemdQuote(svc.Exe)}
+	for _, arg := range svc.Args {
+		quoted = append(quoted, systemdQuote(arg))
+	}
+	svc.ExecStart = strings.Join(quoted, " ")
+	svc.WorkDir = strings.ReplaceAll(svc.WorkDir, "%", "%%") // Specifiers are expanded there too
+
+	units := []struct {
+		file string
+		tmpl *template.Template
+	}{
+		{svc.Name + ".service", systemdServiceTemplate},
+		{svc.Name + ".timer", systemdTimerTemplate},
+	}
+
+	for _, unit := range units {
+		var b strings.Builder
+		if err := unit.tmpl.Execute(&b, svc); err != nil {
+			return err
+		}
+
+		path := filepath.Join(unitDir, unit.file)
+		if dryRun {
+			fmt.Printf("# %s\n%s\n", path, b.String())
+			continue
+		}
+
+		if err := os.MkdirAll(unitDir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+
+	fmt.Printf("\nEnable it with:\n  %s daemon-reload && %s enable --now %s.timer\n", systemctl, systemctl, svc.Name)
+	return nil
+}
+
+// systemdQuote quotes an ExecStart argument when it contains whitespace,
+// quotes, or the % specifiers and $ variables systemd would expand, which are
+// escaped as %% and $$
+func systemdQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\%$") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `%`, `%%`, `$`, `$$`).Replace(arg) + `"`
+}
+
+// installWindowsTask registers a scheduled task with schtasks. A plain
+// executable can't act as a Windows service, so the Task Scheduler starts
+// each pass instead.
+func installWindowsTask(svc serviceConfig, dryRun bool) error {
+	command := []string{windowsQuote(svc.Exe)}
+	for _, arg := range svc.Args {
+		command = append(command, windowsQuote(arg))
+	}
+
+	schedule := strings.ToUpper(svc.Schedule)
+	taskArgs := []string{"/Create", "/F", "/TN", svc.Name, "/SC", schedule, "/TR", strings.Join(command, " ")}
+	if schedule == "DAILY" || schedule == "WEEKLY" {
+		taskArgs = append(taskArgs, "/ST", "03:00")
+	}
+
+	if dryRun {
+		fmt.Printf("schtasks %s\n", strings.Join(taskArgs, " "))
+		return nil
+	}
+
+	// Scheduled tasks don't start in the current directory, so relative paths would move
+	fmt.Println("Note: use absolute paths for -output; the task does not run from", svc.WorkDir)
+
+	cmd := exec.Command("schtasks", taskArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// windowsQuote quotes an argument for a Task Scheduler command line
+func windowsQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	return `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+}
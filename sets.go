@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// setDirNames names the subfolder of every set: "Set 1", "Set 2", ... and
+// "Encore" (or "Encore 2" for a second encore)
+func setDirNames(sets []Set) []string {
+	names := make([]string, len(sets))
+	setNum, encoreNum := 0, 0
+	for i, set := range sets {
+		if set.IsEncore {
+			encoreNum++
+			names[i] = "Encore"
+			if encoreNum > 1 {
+				names[i] = fmt.Sprintf("Encore %d", encoreNum)
+			}
+			continue
+		}
+		setNum++
+		names[i] = fmt.Sprintf("Set %d", setNum)
+	}
+	return names
+}
+
+// fileBase returns the lower-case base name of a file or URL without extension
+func fileBase(name string) string {
+	base := path.Base(name)
+	return strings.ToLower(strings.TrimSuffix(base, path.Ext(base)))
+}
+
+// trackBase returns the file base of a track's mp3_url, unescaped so it
+// matches the name of the file it points at
+func trackBase(track Track) string {
+	if base, err := url.PathUnescape(path.Base(track.Mp3URL)); err == nil {
+		return fileBase(base)
+	}
+	return fileBase(track.Mp3URL)
+}
+
+// assignSets maps the audio files of a source onto Relisten's set list and
+// records the set folder of each file for -split-sets or {set} in
+// -output-template, and the encore number of encore tracks for -encore-names
+func assignSets(files []ArchiveFile, sets []Set, cfg *Config) {
+	t, _ := parseOutputTemplate(cfg.OutputTemplate)
+	splitSets := cfg.SplitSets || (t != nil && t.usesSets())
+	if len(sets) == 0 || (!splitSets && !cfg.EncoreNames) {
+		return
+	}
+
+	sets = append([]Set(nil), sets...)
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].Index < sets[j].Index })
+	names := setDirNames(sets)
+	matches := matchSets(files, sets)
+
+	// Encore tracks are numbered across all encores, per audio format
+	encores := make(map[string][]int)
+	for i, set := range matches {
+		if splitSets && len(sets) > 1 {
+			files[i].SetDir = names[set]
+		}
+		if cfg.EncoreNames && sets[set].IsEncore {
+			ext := strings.ToLower(path.Ext(files[i].Name))
+			encores[ext] = append(encores[ext], i)
+		}
+	}
+	for _, indexes := range encores {
+		sort.Slice(indexes, func(a, b int) bool {
+			if matches[indexes[a]] != matches[indexes[b]] {
+				return matches[indexes[a]] < matches[indexes[b]]
+			}
+			return files[indexes[a]].Name < files[indexes[b]].Name
+		})
+		for n, i := range indexes {
+			files[i].EncoreTrack = n + 1
+		}
+	}
+}
+
+// matchSets returns the set index of every audio file it can place. Files
+// are matched to Relisten tracks by base name, since Relisten's mp3_url
+// points at the archive file of the same name. If no names match but an
+// audio format has exactly one file per track, files are placed in order.
+func matchSets(files []ArchiveFile, sets []Set) map[int]int {
+	byBase := make(map[string]int)
+	var order []int
+	for i, set := range sets {
+		tracks := append([]Track(nil), set.Tracks...)
+		sort.SliceStable(tracks, func(a, b int) bool { return tracks[a].TrackPosition < tracks[b].TrackPosition })
+		for _, track := range tracks {
+			if track.Mp3URL != "" {
+				byBase[trackBase(track)] = i
+			}
+			order = append(order, i)
+		}
+	}
+
+	matches := make(map[int]int)
+	byExt := make(map[string][]int)
+	for i := range files {
+		if classifyFile(files[i]) != ClassAudio {
+			continue
+		}
+		if set, ok := byBase[fileBase(files[i].Name)]; ok {
+			matches[i] = set
+		}
+		ext := strings.ToLower(path.Ext(files[i].Name))
+		byExt[ext] = append(byExt[ext], i)
+	}
+	if len(matches) > 0 {
+		return matches
+	}
+
+	for _, indexes := range byExt {
+		if len(indexes) != len(order) {
+			continue
+		}
+		sort.Slice(indexes, func(a, b int) bool { return files[indexes[a]].Name < files[indexes[b]].Name })
+		for n, i := range indexes {
+			matches[i] = order[n]
+		}
+	}
+	return matches
+}
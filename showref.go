@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// showUUIDPattern matches relisten.org show UUIDs, as opposed to archive.org identifiers
+var showUUIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// findShow resolves -show to the show it belongs to. A relisten.org show
+// UUID is looked up directly. An archive.org identifier is looked up on
+// archive.org for its date, and relisten.org's show of that date is used if
+// it lists the item as a source, for its set list; otherwise the show is
+// made from the item's metadata alone.
+func findShow(cfg *Config) (*Show, error) {
+	if showUUIDPattern.MatchString(cfg.Show) {
+		var show Show
+		provider, err := getRelisten(fmt.Sprintf("%s/shows/%s", RelistenAPIBase, strings.ToLower(cfg.Show)), &show)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch show %s: %w", cfg.Show, err)
+		}
+		// planShow fetches the sources of the date and keeps those of this show
+		show.Sources, show.Provider = nil, provider
+		return &show, nil
+	}
+
+	metadata, err := fetchArchiveMetadata(cfg.Show)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch archive.org item %s: %w", cfg.Show, err)
+	}
+	if metadata.Metadata.Identifier == "" {
+		return nil, fmt.Errorf("no archive.org item %s", cfg.Show)
+	}
+	date := metadata.Metadata.Date
+	if len(date) < len("2006-01-02") || !showDatePattern.MatchString(date[:len("2006-01-02")]) {
+		return nil, fmt.Errorf("archive.org item %s has no show date", cfg.Show)
+	}
+	date = date[:len("2006-01-02")]
+
+	if shows, err := fetchShows(cfg.Band, date[:4]); err == nil {
+		for _, show := range shows {
+			if show.DisplayDate != date || show.Provider == ProviderArchive {
+				continue
+			}
+			detail, err := fetchShowDetail(cfg.Band, date)
+			if err == nil && len(filterShowSources(detail.Sources, cfg.Show)) > 0 {
+				return &show, nil
+			}
+		}
+	} else {
+		logger.Warn("Failed to fetch %s shows of %s: %v", cfg.Band, date[:4], err)
+	}
+
+	logger.Warn("%s isn't a %s source on relisten.org, downloading it without a set list", cfg.Show, cfg.Band)
+	shows := archiveShows([]archiveSearchDoc{{
+		Identifier: cfg.Show,
+		Title:      metadata.Metadata.Title,
+		Date:       date,
+		Venue:      metadata.Metadata.Venue,
+		Coverage:   metadata.Metadata.Coverage,
+	}})
+	return &shows[0], nil
+}
+
+// filterShowSources keeps the sources -show names: those of a relisten.org
+// show UUID, or the one of an archive.org identifier. Without -show, every
+// source is kept.
+func filterShowSources(sources []Source, show string) []Source {
+	if show == "" {
+		return sources
+	}
+	var kept []Source
+	for _, source := range sources {
+		if strings.EqualFold(source.ShowUUID, show) || archiveIdentifier(source) == show {
+			kept = append(kept, source)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// What to do when a soundboard appears for a show we only have audience recordings of
+const (
+	SoundboardOff      = "off"
+	SoundboardDownload = "download"
+	SoundboardArchive  = "archive-aud"
+)
+
+// AudArchiveDirName is the folder, next to the show directories of a year,
+// that superseded audience recordings are moved into
+const AudArchiveDirName = "aud"
+
+// parseSoundboardPolicy validates -sbd-policy
+func parseSoundboardPolicy(policy string) error {
+	switch policy {
+	case SoundboardOff, SoundboardDownload, SoundboardArchive:
+		return nil
+	}
+	return fmt.Errorf("unknown -sbd-policy %q (use %s, %s, or %s)", policy,
+		SoundboardOff, SoundboardDownload, SoundboardArchive)
+}
+
+// findSoundboard returns the best rated soundboard source of a show that
+// isn't cataloged yet, along with the identifiers of the audience recordings
+// it supersedes. It returns nil unless the catalog has the show, and only as
+// audience recordings.
+func findSoundboard(catalog *Catalog, band string, show Show, sources []Source) (*Source, []string) {
+	var audience []string
+	for _, src := range catalog.Sources {
+		if src.Band != band || src.Date != show.DisplayDate || src.SupersededBy != "" {
+			continue
+		}
+		if src.Soundboard {
+			return nil, nil
+		}
+		audience = append(audience, src.Identifier)
+	}
+	if len(audience) == 0 {
+		return nil, nil
+	}
+
+	var best *Source
+	for i, source := range sources {
+		id := archiveIdentifier(source)
+		if !source.IsSoundboard || id == "" || catalog.Sources[id] != nil {
+			continue
+		}
+		if best == nil || source.AvgRating > best.AvgRating {
+			best = &sources[i]
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return best, audience
+}
+
+// planSoundboard adds a newly available soundboard to the plan of a show we
+// only have audience recordings of. It gets its own directory, since the
+// usual one may hold the audience recording.
+func planSoundboard(cfg *Config, catalog *Catalog, plan *ShowPlan, sources []Source, summary *RunSummary) {
+	sbd, audience := findSoundboard(catalog, cfg.Band, plan.Show, sources)
+	if sbd == nil {
+		return
+	}
+	id := archiveIdentifier(*sbd)
+
+	// The soundboard may already be planned, e.g. as the highest rated source
+	planned := plan.Sources[:0]
+	for _, sp := range plan.Sources {
+		if sp.Identifier != id {
+			planned = append(planned, sp)
+		}
+	}
+
+	sp := &SourcePlan{
+		Identifier: id,
+		Source:     *sbd,
+		ShowDir:    sourceShowDir(cfg, plan.Show, id, "-"+id),
+		Supersedes: audience,
+	}
+	if err := sp.selectFiles(cfg, summary); err != nil {
+		logger.Warn("Failed to plan files for %s: %v", id, err)
+	}
+	plan.Sources = append(planned, sp)
+	plan.Note = fmt.Sprintf("Soundboard %s available for a show we only have audience recordings of", id)
+}
+
+// supersedeAudience marks the audience recordings a completely downloaded
+// soundboard replaces, moving them into the aud/ folder with -sbd-policy archive-aud
+func supersedeAudience(cfg *Config, store CatalogStore, sp *SourcePlan) {
+	if _, ok, err := store.LookupSource(sp.Identifier); err != nil || !ok {
+		logger.Warn("Keeping the audience recordings, the soundboard %s is incomplete", sp.Identifier)
+		return
+	}
+
+	for _, id := range sp.Supersedes {
+		src, ok, err := store.LookupSource(id)
+		if err != nil || !ok {
+			continue
+		}
+		src.SupersededBy = sp.Identifier
+
+		if cfg.SoundboardPolicy == SoundboardArchive {
+			if rel, err := archiveAudience(cfg, src.ShowDir); err != nil {
+				logger.Warn("Failed to move %s to the %s folder: %v", src.ShowDir, AudArchiveDirName, err)
+			} else {
+				logger.Printf("    ✓ Moved audience recording %s to %s\n", id, rel)
+				src.ShowDir = rel
+			}
+		}
+
+		if err := store.AddSource(src); err != nil {
+			logger.Warn("Failed to update catalog: %v", err)
+		}
+	}
+}
+
+// archiveAudience moves a show directory, relative to the output directory,
+// into the aud/ folder next to it and returns its new relative path
+func archiveAudience(cfg *Config, showDir string) (string, error) {
+	oldDir := filepath.Join(cfg.OutputDir, filepath.FromSlash(showDir))
+	newDir := filepath.Join(filepath.Dir(oldDir), AudArchiveDirName, filepath.Base(oldDir))
+
+	// Don't move the directory away from an instance that is still writing to it
+	lock, err := acquireShowLock(oldDir, cfg.StaleLock)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		lock.Release()
+		return "", err
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		lock.Release()
+		return "", err
+	}
+	if err := os.Remove(filepath.Join(newDir, LockFileName)); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Failed to release lock for %s: %v", newDir, err)
+	}
+
+	rel, err := filepath.Rel(cfg.OutputDir, newDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
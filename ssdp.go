@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SSDP announces the DLNA server on the LAN and answers discovery requests
+const (
+	ssdpAddr         = "239.255.255.250:1900"
+	ssdpMaxAge       = 1800 // Seconds announcements are valid for
+	ssdpNotifyPeriod = 15 * time.Minute
+)
+
+// ssdpServer is the discovery side of the DLNA server
+type ssdpServer struct {
+	uuid     string
+	port     int // HTTP port of the device description
+	server   string
+	conn     *net.UDPConn
+	group    *net.UDPAddr
+	services []string
+}
+
+func newSSDPServer(uuid string, port int) (*ssdpServer, error) {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join the SSDP multicast group: %w", err)
+	}
+	return &ssdpServer{
+		uuid:   uuid,
+		port:   port,
+		server: "Linux/1.0 UPnP/1.0 dead-dl/1.0",
+		conn:   conn,
+		group:  group,
+		services: []string{
+			"upnp:rootdevice",
+			"uuid:" + uuid,
+			"urn:schemas-upnp-org:device:MediaServer:1",
+			"urn:schemas-upnp-org:service:ContentDirectory:1",
+			"urn:schemas-upnp-org:service:ConnectionManager:1",
+		},
+	}, nil
+}
+
+// usn returns the unique service name of a notification type
+func (s *ssdpServer) usn(nt string) string {
+	if nt == "uuid:"+s.uuid {
+		return nt
+	}
+	return "uuid:" + s.uuid + "::" + nt
+}
+
+// location returns the device description URL as seen from the remote address
+func (s *ssdpServer) location(remote *net.UDPAddr) string {
+	ip := localIPFor(remote)
+	return fmt.Sprintf("http://%s/rootDesc.xml", net.JoinHostPort(ip, fmt.Sprint(s.port)))
+}
+
+// Serve answers M-SEARCH requests and re-announces the server periodically
+// until Close is called
+func (s *ssdpServer) Serve() {
+	s.notify("ssdp:alive")
+	go func() {
+		ticker := time.NewTicker(ssdpNotifyPeriod)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.notify("ssdp:alive")
+		}
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, remote, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		s.respond(remote, req.Header.Get("ST"))
+	}
+}
+
+// respond sends a search response for every service matching st
+func (s *ssdpServer) respond(remote *net.UDPAddr, st string) {
+	for _, nt := range s.services {
+		if st != "ssdp:all" && st != nt {
+			continue
+		}
+		msg := fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+			"CACHE-CONTROL: max-age=%d\r\n"+
+			"DATE: %s\r\n"+
+			"EXT:\r\n"+
+			"LOCATION: %s\r\n"+
+			"SERVER: %s\r\n"+
+			"ST: %s\r\n"+
+			"USN: %s\r\n\r\n",
+			ssdpMaxAge, time.Now().UTC().Format(http.TimeFormat), s.location(remote), s.server, nt, s.usn(nt))
+		if _, err := s.conn.WriteToUDP([]byte(msg), remote); err != nil {
+			logger.Warn("Failed to answer SSDP search from %s: %v", remote, err)
+			return
+		}
+	}
+}
+
+// notify multicasts an ssdp:alive or ssdp:byebye announcement of every service
+func (s *ssdpServer) notify(nts string) {
+	for _, nt := range s.services {
+		msg := fmt.Sprintf("NOTIFY * HTTP/1.1\r\n"+
+			"HOST: %s\r\n"+
+			"NT: %s\r\n"+
+			"NTS: %s\r\n"+
+			"USN: %s\r\n", ssdpAddr, nt, nts, s.usn(nt))
+		if nts == "ssdp:alive" {
+			msg += fmt.Sprintf("CACHE-CONTROL: max-age=%d\r\n"+
+				"LOCATION: %s\r\n"+
+				"SERVER: %s\r\n", ssdpMaxAge, s.location(s.group), s.server)
+		}
+		msg += "\r\n"
+		if _, err := s.conn.WriteToUDP([]byte(msg), s.group); err != nil {
+			logger.Warn("Failed to send SSDP announcement: %v", err)
+			return
+		}
+	}
+}
+
+// Close announces that the server is leaving and stops serving
+func (s *ssdpServer) Close() error {
+	s.notify("ssdp:byebye")
+	return s.conn.Close()
+}
+
+// localIPFor returns the local address used to reach remote, which is the
+// address to advertise to it
+func localIPFor(remote *net.UDPAddr) string {
+	conn, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP.String()
+	if strings.HasPrefix(ip, "0.") {
+		return "127.0.0.1"
+	}
+	return ip
+}
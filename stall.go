@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// stallReader counts the bytes read from a response body so a watchdog can
+// detect transfers that have slowed to a crawl
+type stallReader struct {
+	r     io.Reader
+	count atomic.Int64
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.count.Add(int64(n))
+	return n, err
+}
+
+// watchStall cancels the download context when fewer than minSpeed bytes per
+// second were read over the last window. archive.org connections sometimes
+// stall indefinitely, so this turns a hung transfer into a retryable error.
+// The returned function stops the watchdog.
+func watchStall(reader *stallReader, cancel context.CancelCauseFunc, minSpeed ByteSize, window time.Duration) func() {
+	done := make(chan struct{})
+	if minSpeed <= 0 || window <= 0 {
+		return func() {}
+	}
+
+	go func() {
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+
+		last := reader.count.Load()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := reader.count.Load()
+				speed := ByteSize(float64(current-last) / window.Seconds())
+				if speed < minSpeed {
+					cancel(fmt.Errorf("transfer stalled: %s/s over the last %s is below the minimum of %s/s",
+						speed, window, minSpeed))
+					return
+				}
+				last = current
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
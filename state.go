@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RunState is persisted to disk as a run progresses so that a crashed run
+// can be continued with `dead-dl resume <statefile>`
+type RunState struct {
+	Config    Config          `json:"config"`
+	Shows     []Show          `json:"shows"`
+	Plans     []*ShowPlan     `json:"plans,omitempty"`
+	Processed map[string]bool `json:"processed"`
+	UpdatedAt time.Time       `json:"updated_at"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// newRunState creates a state for a fresh run over the given shows
+func newRunState(path string, cfg Config, shows []Show) *RunState {
+	return &RunState{
+		Config:    cfg,
+		Shows:     shows,
+		Processed: make(map[string]bool),
+		path:      path,
+	}
+}
+
+// loadRunState reads a state file written by a previous run
+func loadRunState(path string) (*RunState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &RunState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if state.Processed == nil {
+		state.Processed = make(map[string]bool)
+	}
+	state.path = path
+
+	return state, nil
+}
+
+// showKey identifies a show within a run; the UUID is preferred since a
+// band can play more than one show on the same date
+func showKey(show Show) string {
+	if show.UUID != "" {
+		return show.UUID
+	}
+	return show.DisplayDate
+}
+
+// IsProcessed reports whether the show was already handled by this run
+func (s *RunState) IsProcessed(show Show) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Processed[showKey(show)]
+}
+
+// MarkProcessed records the show as handled and flushes the state to disk
+func (s *RunState) MarkProcessed(show Show) error {
+	s.mu.Lock()
+	s.Processed[showKey(show)] = true
+	s.mu.Unlock()
+	return s.Save()
+}
+
+// Save writes the state atomically so a crash mid-write never leaves a
+// truncated state file behind. A -dry-run has no state file.
+func (s *RunState) Save() error {
+	if s.Config.DryRun {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// Remove deletes the state file once the run has completed
+func (s *RunState) Remove() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runStats handles `dead-dl stats`, which reports on the catalog of a downloads tree
+func runStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	outputDir := fs.String("output", "./downloads", "Output directory whose catalog to read")
+	catalog := fs.String("catalog", "", "Catalog file or postgres:// URL to read instead of the catalog under -output")
+	showBandwidth := fs.Bool("bandwidth", false, "Show bytes transferred per day, month, and run")
+	days := fs.Int("days", 30, "Number of days to show with -bandwidth")
+	runs := fs.Int("runs", 10, "Number of recent runs to show with -bandwidth")
+	fs.Parse(args)
+
+	if !*showBandwidth {
+		fmt.Fprintln(os.Stderr, "Usage: dead-dl stats -bandwidth [-output ./downloads | -catalog file] [-days 30] [-runs 10]")
+		os.Exit(2)
+	}
+
+	initLogger(os.Stderr)
+
+	store, err := openCatalog(&Config{OutputDir: *outputDir, Catalog: *catalog})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open catalog: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	c, err := store.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load catalog: %v\n", err)
+		os.Exit(1)
+	}
+
+	printBandwidth(os.Stdout, c, *days, *runs)
+}
@@ -0,0 +1,668 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubsonicAPIVersion is the Subsonic REST API version served
+const SubsonicAPIVersion = "1.16.1"
+
+// Subsonic error codes
+const (
+	subsonicErrGeneric       = 0
+	subsonicErrWrongPassword = 40
+	subsonicErrNotFound      = 70
+)
+
+// Lengths of album lists and search results when the request doesn't say,
+// and at most
+const (
+	subsonicDefaultListLength = 10
+	subsonicMaxListLength     = 500
+)
+
+// subsonicServer implements the parts of the Subsonic API that streaming
+// apps need to browse, search, and play the collection. IDs are those of
+// the media library, so browsing by folder and by tags share them.
+type subsonicServer struct {
+	store    CatalogStore
+	root     string
+	name     string
+	user     string
+	password string
+}
+
+// register adds the API endpoints, which clients call as /rest/<method> or
+// /rest/<method>.view
+func (s *subsonicServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("/rest/", s.serveAPI)
+}
+
+// subsonicResponse is the envelope of every API response; exactly one of
+// the payload fields is set
+type subsonicResponse struct {
+	XMLName xml.Name `xml:"subsonic-response" json:"-"`
+	Xmlns   string   `xml:"xmlns,attr" json:"-"`
+	Status  string   `xml:"status,attr" json:"status"`
+	Version string   `xml:"version,attr" json:"version"`
+	Type    string   `xml:"type,attr" json:"type"`
+
+	Error         *subsonicError        `xml:"error,omitempty" json:"error,omitempty"`
+	License       *subsonicLicense      `xml:"license,omitempty" json:"license,omitempty"`
+	MusicFolders  *subsonicMusicFolders `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes       *subsonicIndexes      `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Artists       *subsonicIndexes      `xml:"artists,omitempty" json:"artists,omitempty"`
+	Directory     *subsonicDirectory    `xml:"directory,omitempty" json:"directory,omitempty"`
+	Artist        *subsonicArtist       `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album         *subsonicAlbum        `xml:"album,omitempty" json:"album,omitempty"`
+	Song          *subsonicChild        `xml:"song,omitempty" json:"song,omitempty"`
+	AlbumList     *subsonicAlbumList    `xml:"albumList,omitempty" json:"albumList,omitempty"`
+	AlbumList2    *subsonicAlbumList    `xml:"albumList2,omitempty" json:"albumList2,omitempty"`
+	SearchResult2 *subsonicSearchResult `xml:"searchResult2,omitempty" json:"searchResult2,omitempty"`
+	SearchResult3 *subsonicSearchResult `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	RandomSongs   *subsonicSongs        `xml:"randomSongs,omitempty" json:"randomSongs,omitempty"`
+	Playlists     *subsonicEmpty        `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Starred       *subsonicEmpty        `xml:"starred,omitempty" json:"starred,omitempty"`
+	Starred2      *subsonicEmpty        `xml:"starred2,omitempty" json:"starred2,omitempty"`
+	Genres        *subsonicEmpty        `xml:"genres,omitempty" json:"genres,omitempty"`
+	NowPlaying    *subsonicEmpty        `xml:"nowPlaying,omitempty" json:"nowPlaying,omitempty"`
+	User          *subsonicUser         `xml:"user,omitempty" json:"user,omitempty"`
+}
+
+type subsonicEmpty struct{}
+
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type subsonicLicense struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type subsonicMusicFolders struct {
+	Folders []subsonicMusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type subsonicMusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type subsonicIndexes struct {
+	LastModified    int64           `xml:"lastModified,attr,omitempty" json:"lastModified,omitempty"`
+	IgnoredArticles string          `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []subsonicIndex `xml:"index" json:"index"`
+}
+
+type subsonicIndex struct {
+	Name    string           `xml:"name,attr" json:"name"`
+	Artists []subsonicArtist `xml:"artist" json:"artist"`
+}
+
+type subsonicArtist struct {
+	ID         string          `xml:"id,attr" json:"id"`
+	Name       string          `xml:"name,attr" json:"name"`
+	AlbumCount int             `xml:"albumCount,attr" json:"albumCount"`
+	Albums     []subsonicAlbum `xml:"album,omitempty" json:"album,omitempty"`
+}
+
+type subsonicAlbum struct {
+	ID        string          `xml:"id,attr" json:"id"`
+	Parent    string          `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir     bool            `xml:"isDir,attr" json:"isDir"`
+	Name      string          `xml:"name,attr" json:"name"`
+	Title     string          `xml:"title,attr" json:"title"`
+	Album     string          `xml:"album,attr" json:"album"`
+	Artist    string          `xml:"artist,attr" json:"artist"`
+	ArtistID  string          `xml:"artistId,attr" json:"artistId"`
+	Year      int             `xml:"year,attr,omitempty" json:"year,omitempty"`
+	SongCount int             `xml:"songCount,attr" json:"songCount"`
+	Duration  int             `xml:"duration,attr" json:"duration"`
+	Created   string          `xml:"created,attr,omitempty" json:"created,omitempty"`
+	Songs     []subsonicChild `xml:"song,omitempty" json:"song,omitempty"`
+}
+
+// subsonicChild is an entry of a directory, a folder or a song
+type subsonicChild struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track       int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	Year        int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Size        int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Duration    int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	Path        string `xml:"path,attr,omitempty" json:"path,omitempty"`
+	AlbumID     string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID    string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type        string `xml:"type,attr,omitempty" json:"type,omitempty"`
+}
+
+type subsonicDirectory struct {
+	ID       string          `xml:"id,attr" json:"id"`
+	Parent   string          `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name     string          `xml:"name,attr" json:"name"`
+	Children []subsonicChild `xml:"child" json:"child"`
+}
+
+type subsonicAlbumList struct {
+	Albums []subsonicAlbum `xml:"album" json:"album"`
+}
+
+type subsonicSearchResult struct {
+	Artists []subsonicArtist `xml:"artist" json:"artist"`
+	Albums  []subsonicAlbum  `xml:"album" json:"album"`
+	Songs   []subsonicChild  `xml:"song" json:"song"`
+}
+
+type subsonicSongs struct {
+	Songs []subsonicChild `xml:"song" json:"song"`
+}
+
+type subsonicUser struct {
+	Username      string `xml:"username,attr" json:"username"`
+	StreamRole    bool   `xml:"streamRole,attr" json:"streamRole"`
+	DownloadRole  bool   `xml:"downloadRole,attr" json:"downloadRole"`
+	ScrobbleRole  bool   `xml:"scrobblingEnabled,attr" json:"scrobblingEnabled"`
+	AdminRole     bool   `xml:"adminRole,attr" json:"adminRole"`
+	SettingsRole  bool   `xml:"settingsRole,attr" json:"settingsRole"`
+	PlaylistRole  bool   `xml:"playlistRole,attr" json:"playlistRole"`
+	CoverArtRole  bool   `xml:"coverArtRole,attr" json:"coverArtRole"`
+	CommentRole   bool   `xml:"commentRole,attr" json:"commentRole"`
+	PodcastRole   bool   `xml:"podcastRole,attr" json:"podcastRole"`
+	ShareRole     bool   `xml:"shareRole,attr" json:"shareRole"`
+	JukeboxRole   bool   `xml:"jukeboxRole,attr" json:"jukeboxRole"`
+	UploadRole    bool   `xml:"uploadRole,attr" json:"uploadRole"`
+	VideoRole     bool   `xml:"videoConversionRole,attr" json:"videoConversionRole"`
+	MusicFolderID []int  `xml:"folder" json:"folder"`
+}
+
+func (s *subsonicServer) serveAPI(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/rest/"), ".view")
+	r.ParseForm()
+	q := r.Form
+
+	if !s.authenticate(q) {
+		s.fail(w, q, subsonicErrWrongPassword, "Wrong username or password")
+		return
+	}
+	lib, err := loadMediaLibrary(s.store, s.root, s.name)
+	if err != nil {
+		logger.Warn("Subsonic %s failed: %v", method, err)
+		s.fail(w, q, subsonicErrGeneric, err.Error())
+		return
+	}
+
+	resp := &subsonicResponse{}
+	switch method {
+	case "ping", "scrobble":
+	case "getLicense":
+		resp.License = &subsonicLicense{Valid: true}
+	case "getMusicFolders":
+		resp.MusicFolders = &subsonicMusicFolders{Folders: []subsonicMusicFolder{{ID: 1, Name: s.name}}}
+	case "getIndexes", "getArtists":
+		indexes := s.indexes(lib)
+		if method == "getIndexes" {
+			resp.Indexes = indexes
+		} else {
+			resp.Artists = indexes
+		}
+	case "getMusicDirectory":
+		dir, ok := s.directory(lib, q.Get("id"))
+		if !ok {
+			s.fail(w, q, subsonicErrNotFound, "Directory not found")
+			return
+		}
+		resp.Directory = dir
+	case "getArtist":
+		band := strings.TrimPrefix(q.Get("id"), "band:")
+		if _, ok := lib.years[band]; !ok {
+			s.fail(w, q, subsonicErrNotFound, "Artist not found")
+			return
+		}
+		artist := s.artist(lib, band)
+		for _, year := range lib.years[band] {
+			for _, src := range lib.shows[band+"/"+year] {
+				artist.Albums = append(artist.Albums, s.album(lib, src))
+			}
+		}
+		resp.Artist = &artist
+	case "getAlbum":
+		src, ok := lib.sources[strings.TrimPrefix(q.Get("id"), "show:")]
+		if !ok {
+			s.fail(w, q, subsonicErrNotFound, "Album not found")
+			return
+		}
+		album := s.album(lib, src)
+		album.Songs = s.songs(lib, src)
+		resp.Album = &album
+	case "getSong":
+		src, track, ok := s.track(lib, q.Get("id"))
+		if !ok {
+			s.fail(w, q, subsonicErrNotFound, "Song not found")
+			return
+		}
+		song := s.song(src, track)
+		resp.Song = &song
+	case "getAlbumList", "getAlbumList2":
+		list := &subsonicAlbumList{Albums: s.albumList(lib, q)}
+		if list.Albums == nil {
+			list.Albums = []subsonicAlbum{}
+		}
+		if method == "getAlbumList" {
+			resp.AlbumList = list
+		} else {
+			resp.AlbumList2 = list
+		}
+	case "search2", "search3":
+		result := s.search(lib, q)
+		if method == "search2" {
+			resp.SearchResult2 = result
+		} else {
+			resp.SearchResult3 = result
+		}
+	case "getRandomSongs":
+		resp.RandomSongs = &subsonicSongs{Songs: s.randomSongs(lib, listSize(q, "size"))}
+	case "stream", "download":
+		_, track, ok := s.track(lib, q.Get("id"))
+		if !ok {
+			s.fail(w, q, subsonicErrNotFound, "Song not found")
+			return
+		}
+		s.stream(w, r, track)
+		return
+	case "getCoverArt":
+		s.fail(w, q, subsonicErrNotFound, "No cover art")
+		return
+	case "getPlaylists":
+		resp.Playlists = &subsonicEmpty{}
+	case "getStarred":
+		resp.Starred = &subsonicEmpty{}
+	case "getStarred2":
+		resp.Starred2 = &subsonicEmpty{}
+	case "getGenres":
+		resp.Genres = &subsonicEmpty{}
+	case "getNowPlaying":
+		resp.NowPlaying = &subsonicEmpty{}
+	case "getUser":
+		resp.User = &subsonicUser{Username: s.user, StreamRole: true, DownloadRole: true, MusicFolderID: []int{1}}
+	default:
+		s.fail(w, q, subsonicErrGeneric, "Method not supported: "+method)
+		return
+	}
+	s.write(w, q, resp)
+}
+
+// authenticate checks the credentials of a request: a plain or hex-encoded
+// ("enc:") password p, or a token t, the md5 of the password and a salt s
+func (s *subsonicServer) authenticate(q url.Values) bool {
+	if subtle.ConstantTimeCompare([]byte(q.Get("u")), []byte(s.user)) != 1 {
+		return false
+	}
+	if token := q.Get("t"); token != "" {
+		sum := md5.Sum([]byte(s.password + q.Get("s")))
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(token)), []byte(hex.EncodeToString(sum[:]))) == 1
+	}
+
+	password := q.Get("p")
+	if encoded, ok := strings.CutPrefix(password, "enc:"); ok {
+		decoded, err := hex.DecodeString(encoded)
+		if err != nil {
+			return false
+		}
+		password = string(decoded)
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+}
+
+// write sends a successful response as XML, or as JSON with f=json
+func (s *subsonicServer) write(w http.ResponseWriter, q url.Values, resp *subsonicResponse) {
+	resp.Xmlns = "http://subsonic.org/restapi"
+	if resp.Status == "" {
+		resp.Status = "ok"
+	}
+	resp.Version = SubsonicAPIVersion
+	resp.Type = "dead-dl"
+
+	if q.Get("f") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]*subsonicResponse{"subsonic-response": resp})
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(resp)
+}
+
+// fail sends an error response; Subsonic errors are reported with HTTP 200
+func (s *subsonicServer) fail(w http.ResponseWriter, q url.Values, code int, message string) {
+	s.write(w, q, &subsonicResponse{Status: "failed", Error: &subsonicError{Code: code, Message: message}})
+}
+
+// indexes groups the bands by their first letter
+func (s *subsonicServer) indexes(lib *mediaLibrary) *subsonicIndexes {
+	indexes := &subsonicIndexes{LastModified: time.Now().UnixMilli(), IgnoredArticles: "The", Index: []subsonicIndex{}}
+	for _, band := range lib.bands {
+		artist := s.artist(lib, band)
+		letter := strings.ToUpper(artist.Name[:1])
+		if n := len(indexes.Index); n == 0 || indexes.Index[n-1].Name != letter {
+			indexes.Index = append(indexes.Index, subsonicIndex{Name: letter})
+		}
+		last := &indexes.Index[len(indexes.Index)-1]
+		last.Artists = append(last.Artists, artist)
+	}
+	return indexes
+}
+
+func (s *subsonicServer) artist(lib *mediaLibrary, band string) subsonicArtist {
+	count := 0
+	for _, year := range lib.years[band] {
+		count += len(lib.shows[band+"/"+year])
+	}
+	return subsonicArtist{ID: "band:" + band, Name: bandDisplayName(band), AlbumCount: count}
+}
+
+// album describes a source as an album; its songs are left out
+func (s *subsonicServer) album(lib *mediaLibrary, src *CatalogSource) subsonicAlbum {
+	title := lib.showTitle(src)
+	album := subsonicAlbum{
+		ID:       "show:" + src.Identifier,
+		Parent:   "year:" + src.Band + "/" + src.Year,
+		IsDir:    true,
+		Name:     title,
+		Title:    title,
+		Album:    title,
+		Artist:   bandDisplayName(src.Band),
+		ArtistID: "band:" + src.Band,
+	}
+	album.Year, _ = strconv.Atoi(src.Year)
+	if !src.DownloadedAt.IsZero() {
+		album.Created = src.DownloadedAt.UTC().Format(time.RFC3339)
+	}
+	for _, track := range lib.tracks(src) {
+		album.SongCount++
+		album.Duration += int(track.Duration)
+	}
+	return album
+}
+
+func (s *subsonicServer) songs(lib *mediaLibrary, src *CatalogSource) []subsonicChild {
+	var songs []subsonicChild
+	for _, track := range lib.tracks(src) {
+		songs = append(songs, s.song(src, track))
+	}
+	return songs
+}
+
+func (s *subsonicServer) song(src *CatalogSource, track mediaObject) subsonicChild {
+	song := subsonicChild{
+		ID:          track.ID,
+		Parent:      track.ParentID,
+		Title:       track.Title,
+		Album:       track.Album,
+		Artist:      track.Artist,
+		Track:       track.TrackNumber,
+		Size:        track.Size,
+		ContentType: track.MIME,
+		Suffix:      strings.TrimPrefix(strings.ToLower(filepath.Ext(track.Path)), "."),
+		Duration:    int(track.Duration),
+		AlbumID:     track.ParentID,
+		ArtistID:    "band:" + src.Band,
+		Type:        "music",
+	}
+	song.Year, _ = strconv.Atoi(src.Year)
+	if rel, err := filepath.Rel(s.root, track.Path); err == nil {
+		song.Path = filepath.ToSlash(rel)
+	}
+	return song
+}
+
+// track finds a song by ID, track:<identifier>/<n>
+func (s *subsonicServer) track(lib *mediaLibrary, id string) (*CatalogSource, mediaObject, bool) {
+	identifier, _, _ := strings.Cut(strings.TrimPrefix(id, "track:"), "/")
+	src, ok := lib.sources[identifier]
+	if !ok {
+		return nil, mediaObject{}, false
+	}
+	for _, track := range lib.tracks(src) {
+		if track.ID == id {
+			return src, track, true
+		}
+	}
+	return nil, mediaObject{}, false
+}
+
+// directory lists a folder: the root lists bands, a band its years, a year
+// its shows, and a show its songs
+func (s *subsonicServer) directory(lib *mediaLibrary, id string) (*subsonicDirectory, bool) {
+	kind, rest, _ := strings.Cut(id, ":")
+	dir := &subsonicDirectory{ID: id, Children: []subsonicChild{}}
+	switch kind {
+	case "band":
+		years, ok := lib.years[rest]
+		if !ok {
+			return nil, false
+		}
+		dir.Name = bandDisplayName(rest)
+		for _, year := range years {
+			dir.Children = append(dir.Children, subsonicChild{ID: "year:" + rest + "/" + year, Parent: id,
+				IsDir: true, Title: year, Artist: dir.Name})
+		}
+	case "year":
+		sources, ok := lib.shows[rest]
+		if !ok {
+			return nil, false
+		}
+		band, year, _ := strings.Cut(rest, "/")
+		dir.Name, dir.Parent = year, "band:"+band
+		for _, src := range sources {
+			dir.Children = append(dir.Children, subsonicChild{ID: "show:" + src.Identifier, Parent: id,
+				IsDir: true, Title: lib.showTitle(src), Artist: bandDisplayName(band)})
+		}
+	case "show":
+		src, ok := lib.sources[rest]
+		if !ok {
+			return nil, false
+		}
+		dir.Name, dir.Parent = lib.showTitle(src), "year:"+src.Band+"/"+src.Year
+		if songs := s.songs(lib, src); songs != nil {
+			dir.Children = songs
+		}
+	default:
+		return nil, false
+	}
+	return dir, true
+}
+
+// allSources returns every source, ordered by band and date
+func (s *subsonicServer) allSources(lib *mediaLibrary) []*CatalogSource {
+	var sources []*CatalogSource
+	for _, band := range lib.bands {
+		for _, year := range lib.years[band] {
+			sources = append(sources, lib.shows[band+"/"+year]...)
+		}
+	}
+	return sources
+}
+
+// albumList answers getAlbumList(2) for the list types that make sense
+// for a collection of shows; other types list shows in order
+func (s *subsonicServer) albumList(lib *mediaLibrary, q url.Values) []subsonicAlbum {
+	sources := s.allSources(lib)
+	switch q.Get("type") {
+	case "newest", "recent":
+		sort.SliceStable(sources, func(i, j int) bool { return sources[i].DownloadedAt.After(sources[j].DownloadedAt) })
+	case "random":
+		rand.Shuffle(len(sources), func(i, j int) { sources[i], sources[j] = sources[j], sources[i] })
+	case "byYear":
+		from, to := q.Get("fromYear"), q.Get("toYear")
+		descending := from > to
+		if descending {
+			from, to = to, from
+		}
+		var kept []*CatalogSource
+		for _, src := range sources {
+			if src.Year >= from && src.Year <= to {
+				kept = append(kept, src)
+			}
+		}
+		sort.SliceStable(kept, func(i, j int) bool {
+			if descending {
+				return kept[i].Date > kept[j].Date
+			}
+			return kept[i].Date < kept[j].Date
+		})
+		sources = kept
+	case "alphabeticalByName":
+		sort.SliceStable(sources, func(i, j int) bool { return sources[i].Date < sources[j].Date })
+	}
+
+	var albums []subsonicAlbum
+	for _, src := range page(sources, q, "offset", "size") {
+		albums = append(albums, s.album(lib, src))
+	}
+	return albums
+}
+
+// search matches the query against band names, show dates, identifiers, and
+// song titles. An empty query matches everything, which apps use to sync
+// the whole library page by page.
+func (s *subsonicServer) search(lib *mediaLibrary, q url.Values) *subsonicSearchResult {
+	query := strings.ToLower(strings.Trim(q.Get("query"), `"*`))
+	matches := func(values ...string) bool {
+		for _, v := range values {
+			if strings.Contains(strings.ToLower(v), query) {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := &subsonicSearchResult{Artists: []subsonicArtist{}, Albums: []subsonicAlbum{}, Songs: []subsonicChild{}}
+	var bands []string
+	for _, band := range lib.bands {
+		if matches(band, bandDisplayName(band)) {
+			bands = append(bands, band)
+		}
+	}
+	for _, band := range page(bands, q, "artistOffset", "artistCount") {
+		result.Artists = append(result.Artists, s.artist(lib, band))
+	}
+
+	var sources []*CatalogSource
+	for _, src := range s.allSources(lib) {
+		if matches(src.Date, src.Identifier, bandDisplayName(src.Band)) {
+			sources = append(sources, src)
+		}
+	}
+	for _, src := range page(sources, q, "albumOffset", "albumCount") {
+		result.Albums = append(result.Albums, s.album(lib, src))
+	}
+
+	// Songs need the manifests, so they are only read until the page is full
+	offset, count := pageBounds(q, "songOffset", "songCount")
+	for _, src := range s.allSources(lib) {
+		if len(result.Songs) >= count {
+			break
+		}
+		for _, track := range lib.tracks(src) {
+			if !matches(track.Title, src.Date) {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if len(result.Songs) < count {
+				result.Songs = append(result.Songs, s.song(src, track))
+			}
+		}
+	}
+	return result
+}
+
+// randomSongs picks size songs from random shows
+func (s *subsonicServer) randomSongs(lib *mediaLibrary, size int) []subsonicChild {
+	sources := s.allSources(lib)
+	rand.Shuffle(len(sources), func(i, j int) { sources[i], sources[j] = sources[j], sources[i] })
+
+	songs := []subsonicChild{}
+	for _, src := range sources {
+		tracks := lib.tracks(src)
+		if len(tracks) == 0 {
+			continue
+		}
+		songs = append(songs, s.song(src, tracks[rand.Intn(len(tracks))]))
+		if len(songs) >= size {
+			break
+		}
+	}
+	return songs
+}
+
+// stream sends a song file as is, with range support for seeking;
+// transcoding parameters are ignored
+func (s *subsonicServer) stream(w http.ResponseWriter, r *http.Request, track mediaObject) {
+	f, err := os.Open(track.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", track.MIME)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// listSize reads a list length parameter, capped at subsonicMaxListLength
+func listSize(q url.Values, name string) int {
+	size, err := strconv.Atoi(q.Get(name))
+	if err != nil || size < 0 {
+		return subsonicDefaultListLength
+	}
+	return min(size, subsonicMaxListLength)
+}
+
+// pageBounds reads the offset and length parameters of a paged list
+func pageBounds(q url.Values, offsetName, sizeName string) (int, int) {
+	offset, err := strconv.Atoi(q.Get(offsetName))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return offset, listSize(q, sizeName)
+}
+
+// page returns the part of items a paged request asks for
+func page[T any](items []T, q url.Values, offsetName, sizeName string) []T {
+	offset, size := pageBounds(q, offsetName, sizeName)
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if size < len(items) {
+		items = items[:size]
+	}
+	return items
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// RunSummary collects notable events of a run so they can be reported once it finishes
+type RunSummary struct {
+	mu           sync.Mutex
+	LockedShows  []string
+	SkippedFiles []string
+	Mismatches   []string
+	failures     int
+	downloaded   int
+	aborted      bool
+	quotaReached bool
+}
+
+// AddFailure counts a file that failed after all retries. It reports true
+// when this failure reaches maxFailures, after which the run is aborted.
+// A maxFailures of 0 never aborts.
+func (s *RunSummary) AddFailure(maxFailures int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures++
+	if maxFailures > 0 && s.failures >= maxFailures && !s.aborted {
+		s.aborted = true
+		return true
+	}
+	return false
+}
+
+// AddDownloaded counts a file that was downloaded successfully
+func (s *RunSummary) AddDownloaded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downloaded++
+}
+
+// Downloaded returns the number of files downloaded successfully
+func (s *RunSummary) Downloaded() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.downloaded
+}
+
+// Failures returns the number of files that failed after all retries
+func (s *RunSummary) Failures() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failures
+}
+
+// Aborted reports whether the run hit its failure limit
+func (s *RunSummary) Aborted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aborted
+}
+
+// SetQuotaReached marks the run as paused by its quota. It reports true
+// the first time so the caller can notify once.
+func (s *RunSummary) SetQuotaReached() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first := !s.quotaReached
+	s.quotaReached = true
+	return first
+}
+
+// QuotaReached reports whether the run stopped starting downloads because of its quota
+func (s *RunSummary) QuotaReached() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quotaReached
+}
+
+// AddLockedShow records a show directory that was skipped because another instance holds its lock
+func (s *RunSummary) AddLockedShow(showDir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LockedShows = append(s.LockedShows, showDir)
+}
+
+// AddSkippedFile records a file that was deliberately not downloaded and why
+func (s *RunSummary) AddSkippedFile(path, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SkippedFiles = append(s.SkippedFiles, fmt.Sprintf("%s (%s)", path, reason))
+}
+
+// AddTrackMismatch records a source whose files don't match Relisten's track list
+func (s *RunSummary) AddTrackMismatch(showDir string, m TrackMismatch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Mismatches = append(s.Mismatches, fmt.Sprintf("%s: %s", showDir, m))
+}
+
+// Print writes the summary to the log
+func (s *RunSummary) Print() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		logger.Warn("%d file(s) failed to download after retries", s.failures)
+	}
+
+	if len(s.SkippedFiles) > 0 {
+		logger.Printf("\nSkipped %d file(s):\n", len(s.SkippedFiles))
+		for _, file := range s.SkippedFiles {
+			logger.Printf("  - %s\n", file)
+		}
+	}
+
+	if len(s.Mismatches) > 0 {
+		logger.Printf("\n%d track list mismatch(es), these items may be mislabeled:\n", len(s.Mismatches))
+		for _, m := range s.Mismatches {
+			logger.Printf("  - %s\n", m)
+		}
+	}
+
+	if len(s.LockedShows) > 0 {
+		logger.Printf("\nSkipped %d show(s) locked by another instance:\n", len(s.LockedShows))
+		for _, showDir := range s.LockedShows {
+			logger.Printf("  - %s\n", showDir)
+		}
+	}
+}
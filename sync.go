@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runSync handles `dead-dl sync`, which downloads only the sources
+// relisten.org added or updated since the last complete sync of the band.
+// Sources in the catalog are skipped unless relisten.org updated them after
+// they were downloaded, in which case their files are checked again.
+func runSync(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	cfg := &Config{}
+	registerFlags(fs, cfg)
+	since := fs.String("since", "", "Look for sources updated since this date, YYYY-MM-DD (default: the start of the last complete sync)")
+	fs.Parse(args)
+
+	initRunLogger(cfg)
+	defer logger.Close()
+
+	if cfg.Band == AllArtists || cfg.Show != "" || cfg.Offline {
+		logger.Fatal("sync can't be used with -band %s, -show, or -offline", AllArtists)
+	}
+	cfg.Sync = true
+	validateConfig(cfg)
+
+	store, err := openCatalog(cfg)
+	if err != nil {
+		logger.Fatal("Failed to open catalog: %v", err)
+	}
+	catalog, err := store.Load()
+	store.Close()
+	if err != nil {
+		logger.Fatal("Failed to load catalog: %v", err)
+	}
+
+	from := lastSync(catalog, cfg.Band)
+	if *since != "" {
+		if err := checkShowDate("-since", *since); err != nil {
+			logger.Fatal("%v", err)
+		}
+		from, _ = time.ParseInLocation("2006-01-02", *since, time.Local)
+	}
+	if from.IsZero() {
+		logger.Fatal("Nothing of %s is in the catalog yet; download it with -year first, or pass -since", cfg.Band)
+	}
+
+	logger.Info("Looking for %s sources updated since %s...", cfg.Band, from.Format("2006-01-02 15:04"))
+	shows, err := fetchRunShows(cfg)
+	if err != nil {
+		logger.Fatal("Failed to fetch shows: %v", err)
+	}
+	shows = updatedShows(cfg, catalog, shows, from)
+	if len(shows) == 0 {
+		logger.Info("No %s sources were updated since %s", cfg.Band, from.Format("2006-01-02 15:04"))
+		return
+	}
+	logger.Info("Found %d show(s) with new or updated sources", len(shows))
+
+	if err := setupEvents(cfg); err != nil {
+		logger.Fatal("Failed to set up progress events: %v", err)
+	}
+	defer events.Close()
+
+	if cfg.StateFile == "" {
+		cfg.StateFile = filepath.Join(cfg.OutputDir, fmt.Sprintf(".dead-dl-%s-sync.state.json", cfg.Band))
+	}
+	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+		logger.Fatal("Failed to create output directory %s: %v", cfg.OutputDir, err)
+	}
+
+	state := newRunState(cfg.StateFile, *cfg, shows)
+	if err := state.Save(); err != nil {
+		logger.Warn("Failed to write state file %s: %v", cfg.StateFile, err)
+	}
+
+	if err := runDownload(cfg, state); err != nil {
+		logger.Fatal("%v", err)
+	}
+}
+
+// lastSync returns when the last complete sync of a band started or, before
+// the first one, when the newest of its cataloged sources was downloaded.
+// It is zero if the catalog has neither.
+func lastSync(catalog *Catalog, band string) time.Time {
+	var last time.Time
+	for _, run := range catalog.Runs {
+		if run.Sync && run.Band == band && !run.Aborted && run.StartedAt.After(last) {
+			last = run.StartedAt
+		}
+	}
+	if !last.IsZero() {
+		return last
+	}
+	for _, src := range catalog.Sources {
+		if src.Band == band && src.DownloadedAt.After(last) {
+			last = src.DownloadedAt
+		}
+	}
+	return last
+}
+
+// updatedShows keeps the shows with a source added or updated after since.
+// Shows that don't say when their sources changed, such as those found on
+// archive.org while relisten.org is down, are kept, and so are those with
+// an MP3 download awaiting a lossless upgrade, since archive.org adding
+// FLAC files doesn't update the source on relisten.org.
+func updatedShows(cfg *Config, catalog *Catalog, shows []Show, since time.Time) []Show {
+	upgradable := make(map[string]bool)
+	for _, src := range catalog.Sources {
+		if src.Band == cfg.Band && awaitsLosslessUpgrade(cfg, src) {
+			upgradable[src.Date] = true
+		}
+	}
+
+	var kept []Show
+	for _, show := range shows {
+		updated, err := parseRelistenTime(show.SourceUpdatedAt)
+		if err != nil || updated.After(since) || upgradable[show.DisplayDate] {
+			kept = append(kept, show)
+		}
+	}
+	return kept
+}
+
+// syncedSources returns the identifiers of the sources the catalog has
+// downloaded since relisten.org last updated them. MP3 downloads awaiting a
+// lossless upgrade aren't synced, so the run checks them for FLAC files.
+func syncedSources(cfg *Config, catalog *Catalog, sources []Source) map[string]bool {
+	synced := make(map[string]bool)
+	for _, source := range sources {
+		id := sourceIdentifier(source)
+		src, ok := catalog.Lookup(id)
+		if !ok || awaitsLosslessUpgrade(cfg, src) {
+			continue
+		}
+		if updated, err := parseRelistenTime(source.UpdatedAt); err == nil && updated.After(src.DownloadedAt) {
+			continue
+		}
+		synced[id] = true
+	}
+	return synced
+}
+
+// parseRelistenTime parses a relisten.org timestamp, which may leave out
+// the time zone of UTC
+func parseRelistenTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05.999999999", value)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MaxCoverSize caps the cover art embedded with -cover-art, since every
+// track of the show carries its own copy
+const MaxCoverSize = 4 << 20
+
+// TrackTags are the tags written into a downloaded track
+type TrackTags struct {
+	Artist      string
+	Album       string
+	Title       string
+	Date        string
+	Track       int
+	Tracks      int
+	Disc        int
+	Discs       int
+	SetName     string
+	Encore      bool   // The track is in an encore of the set list
+	Fingerprint string // AcoustID fingerprint, with -fingerprint
+	Cover       *CoverArt
+}
+
+// encoreLabel is the grouping and comment of encore tracks, so players can
+// tell them apart from the sets
+const encoreLabel = "Encore"
+
+// grouping returns the grouping of a track, or "" when it has none
+func (t *TrackTags) grouping() string {
+	if t.Encore {
+		return encoreLabel
+	}
+	return ""
+}
+
+// CoverArt is an image embedded as the front cover of a track
+type CoverArt struct {
+	MIME string
+	Data []byte
+}
+
+// extras returns the user-defined tags as description/value pairs, written
+// as TXXX frames into MP3s and as plain comments into FLACs
+func (t *TrackTags) extras() [][2]string {
+	if t.Fingerprint == "" {
+		return nil
+	}
+	return [][2]string{{"ACOUSTID_FINGERPRINT", t.Fingerprint}}
+}
+
+// hasExtra reports whether a user-defined tag of this description is written
+func (t *TrackTags) hasExtra(desc string) bool {
+	for _, extra := range t.extras() {
+		if strings.EqualFold(extra[0], desc) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTaggable reports whether dead-dl can write tags into an audio file
+func isTaggable(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".mp3" || ext == ".flac"
+}
+
+// chooseCover picks the image of an archive item to embed with -cover-art:
+// one named like a cover or front, else the first image, else the thumbnail
+// archive.org generates. Images over MaxCoverSize are passed over.
+func chooseCover(files []ArchiveFile) *ArchiveFile {
+	var best *ArchiveFile
+	bestScore := 0
+	for i, file := range files {
+		ext := strings.ToLower(path.Ext(file.Name))
+		if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
+			continue
+		}
+		if size, err := parseFileSize(file.Size); err == nil && size > MaxCoverSize {
+			continue
+		}
+
+		name := strings.ToLower(path.Base(file.Name))
+		score := 2
+		switch {
+		case strings.Contains(name, "cover") || strings.Contains(name, "front"):
+			score = 3
+		case name == "__ia_thumb.jpg":
+			score = 1
+		}
+		if score > bestScore {
+			best, bestScore = &files[i], score
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	cover := *best
+	return &cover
+}
+
+// fetchCover downloads the cover image of an archive item
+func fetchCover(identifier string, file *ArchiveFile) (*CoverArt, error) {
+	resp, err := apiClient.Get(archiveFileURL(identifier, file.Name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxCoverSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxCoverSize {
+		return nil, fmt.Errorf("larger than %d bytes", MaxCoverSize)
+	}
+
+	mime := http.DetectContentType(data)
+	if mime != "image/jpeg" && mime != "image/png" {
+		return nil, fmt.Errorf("not a JPEG or PNG image (%s)", mime)
+	}
+	return &CoverArt{MIME: mime, Data: data}, nil
+}
+
+// showAlbum names the album of a show: its date followed by the venue
+func showAlbum(show Show) string {
+	album := show.DisplayDate
+	if show.Venue.Name != "" {
+		album += " " + show.Venue.Name
+	}
+	if show.Venue.Location != "" {
+		album += ", " + show.Venue.Location
+	}
+	return album
+}
+
+// trackTags builds the tags of every taggable audio file of a source, by
+// index into its files. Titles and set numbers come from Relisten's set
+// list where a file can be placed in it; tracks are numbered per format in
+// set list order, so the MP3s and FLACs of a show are numbered alike.
+func trackTags(cfg *Config, show Show, sp *SourcePlan) map[int]*TrackTags {
+	sets := append([]Set(nil), sp.Source.Sets...)
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].Index < sets[j].Index })
+	names := setDirNames(sets)
+	matches := matchSets(sp.Files, sets)
+	listed := setListTracks(sets)
+
+	tags := make(map[int]*TrackTags)
+	for _, indexes := range audioOrder(sp.Files, len(sets), matches, func(file ArchiveFile) bool { return isTaggable(file.Name) }) {
+		for n, i := range indexes {
+			file := sp.Files[i]
+			fileName, _ := localFileNames(file, cfg)
+			t := &TrackTags{
+				Artist: bandDisplayName(cfg.Band),
+				Album:  showAlbum(show),
+				Title:  fileTitle(file, fileName, listed),
+				Date:   show.DisplayDate,
+				Track:  n + 1,
+				Tracks: len(indexes),
+			}
+			if set, ok := matches[i]; ok {
+				if len(sets) > 1 {
+					t.Disc, t.Discs, t.SetName = set+1, len(sets), names[set]
+				}
+				t.Encore = sets[set].IsEncore
+			}
+			tags[i] = t
+		}
+	}
+	return tags
+}
+
+// setListTracks maps the base names of the tracks of a set list to them
+func setListTracks(sets []Set) map[string]Track {
+	listed := make(map[string]Track)
+	for _, set := range sets {
+		for _, track := range set.Tracks {
+			if track.Mp3URL != "" {
+				listed[trackBase(track)] = track
+			}
+		}
+	}
+	return listed
+}
+
+// fileTitle returns the title of an audio file: its title in the set list,
+// else in the archive metadata, else the one in its local name
+func fileTitle(file ArchiveFile, fileName string, listed map[string]Track) string {
+	if track, ok := listed[fileBase(file.Name)]; ok && track.Title != "" {
+		return track.Title
+	}
+	if file.Title != "" {
+		return file.Title
+	}
+	return trackTitle(fileName)
+}
+
+// audioOrder returns the indexes of the audio files keep accepts, by
+// extension, each in set list order given the set of each file from
+// matchSets. Files outside the set list go after it, by name.
+func audioOrder(files []ArchiveFile, sets int, matches map[int]int, keep func(ArchiveFile) bool) map[string][]int {
+	byExt := make(map[string][]int)
+	for i, file := range files {
+		if classifyFile(file) == ClassAudio && keep(file) {
+			ext := strings.ToLower(path.Ext(file.Name))
+			byExt[ext] = append(byExt[ext], i)
+		}
+	}
+
+	setOf := func(i int) int {
+		if set, ok := matches[i]; ok {
+			return set
+		}
+		return sets
+	}
+	for _, indexes := range byExt {
+		sort.SliceStable(indexes, func(a, b int) bool {
+			if setOf(indexes[a]) != setOf(indexes[b]) {
+				return setOf(indexes[a]) < setOf(indexes[b])
+			}
+			return files[indexes[a]].Name < files[indexes[b]].Name
+		})
+	}
+	return byExt
+}
+
+// tagShow writes artist, album, title, track, set, and date tags into the
+// MP3 and FLAC files of a downloaded source, plus the cover art picked for
+// it with -cover-art and the gapless info of MP3s. Tagged files are marked
+// in the manifest with their new size and md5, so later runs and verify
+// know them from corrupt downloads; files already tagged are left alone. Failures are logged and skip the file.
+func tagShow(cfg *Config, show Show, sp *SourcePlan) {
+	if cfg.NoTag {
+		return
+	}
+	tags := trackTags(cfg, show, sp)
+	if len(tags) == 0 {
+		return
+	}
+
+	manifest, err := loadManifest(sp.ShowDir)
+	if err != nil {
+		logger.Warn("Not tagging %s: %v", sp.ShowDir, err)
+		return
+	}
+
+	var cover *CoverArt
+	if sp.Cover != nil {
+		if cover, err = fetchCover(sp.Identifier, sp.Cover); err != nil {
+			logger.Warn("Failed to fetch cover art %s: %v", sp.Cover.Name, err)
+		}
+	}
+
+	tagged := 0
+	for i, t := range tags {
+		fileName, _ := localFileNames(sp.Files[i], cfg)
+		entry, ok := manifest.Lookup(fileName)
+		if !ok {
+			continue // Failed to download
+		}
+		filePath, err := safeJoin(sp.ShowDir, fileName)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(filePath)
+		if err != nil || (entry.Tagged && info.Size() == entry.Size) {
+			continue
+		}
+
+		t.Fingerprint = entry.Fingerprint
+		t.Cover = cover
+		if isFlacFile(sp.Files[i]) {
+			err = writeFlacTags(filePath, t)
+		} else {
+			err = writeID3(filePath, t)
+		}
+		if err != nil {
+			logger.Warn("Failed to tag %s: %v", fileName, err)
+			continue
+		}
+
+		if info, err = os.Stat(filePath); err != nil {
+			logger.Warn("Failed to stat %s for manifest: %v", filePath, err)
+			continue
+		}
+		sum, err := fileMD5(filePath)
+		if err != nil {
+			logger.Warn("Failed to checksum %s: %v", filePath, err)
+			continue
+		}
+		entry.Size, entry.Tagged, entry.TaggedMD5 = info.Size(), true, sum
+		manifest.Record(entry)
+		tagged++
+	}
+
+	if tagged > 0 {
+		if err := manifest.Save(); err != nil {
+			logger.Warn("Failed to write manifest for %s: %v", sp.ShowDir, err)
+		}
+		logger.Printf("    - Tagged %d file(s)\n", tagged)
+	}
+}
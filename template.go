@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Placeholders of -output-template. Those naming the show directory can't
+// be used in file names and vice versa.
+var (
+	dirPlaceholders  = []string{"band", "artist", "year", "month", "date", "venue", "city", "location", "tour", "identifier"}
+	filePlaceholders = []string{"set", "track_num", "title"}
+)
+
+var placeholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// outputTemplate is a parsed -output-template
+type outputTemplate struct {
+	dir  []string // Path components of the show directory, under -output
+	file []string // Path components of an audio file in the show directory; nil keeps the usual names
+}
+
+// parseOutputTemplate parses an -output-template like
+// "{band}/{year}/{date} - {venue} - {city}/{set}/{track_num} {title}". The
+// components up to the first one with a file placeholder name the show
+// directory, which needs {date} or {identifier} so shows don't share it;
+// the rest name its audio files, and the last of them needs {track_num} or
+// {title}. The extension is added to the file name. An empty template
+// returns nil.
+func parseOutputTemplate(template string) (*outputTemplate, error) {
+	if template == "" {
+		return nil, nil
+	}
+	template = filepath.ToSlash(template)
+	if path.IsAbs(template) || filepath.IsAbs(template) {
+		return nil, fmt.Errorf("-output-template %q must be relative to -output", template)
+	}
+
+	t := &outputTemplate{}
+	for _, part := range strings.Split(template, "/") {
+		if part == "" || part == "." || part == ".." {
+			return nil, fmt.Errorf("-output-template %q has an empty, . or .. folder", template)
+		}
+		dirLevel, fileLevel := false, false
+		for _, m := range placeholderPattern.FindAllStringSubmatch(part, -1) {
+			switch {
+			case slices.Contains(dirPlaceholders, m[1]):
+				dirLevel = true
+			case slices.Contains(filePlaceholders, m[1]):
+				fileLevel = true
+			default:
+				return nil, fmt.Errorf("unknown placeholder {%s} in -output-template (use %s)", m[1], placeholderList())
+			}
+		}
+		if dirLevel && (fileLevel || t.file != nil) {
+			return nil, fmt.Errorf("-output-template %q names files with show placeholders; only {set}, {track_num}, and {title} can follow them", template)
+		}
+		if fileLevel || t.file != nil {
+			t.file = append(t.file, part)
+		} else {
+			t.dir = append(t.dir, part)
+		}
+	}
+
+	if !t.dirHas("date") && !t.dirHas("identifier") {
+		return nil, fmt.Errorf("-output-template %q needs {date} or {identifier} in the show directory", template)
+	}
+	if t.file != nil {
+		last := t.file[len(t.file)-1]
+		if !strings.Contains(last, "{track_num}") && !strings.Contains(last, "{title}") {
+			return nil, fmt.Errorf("-output-template %q needs {track_num} or {title} in the file name", template)
+		}
+	}
+	return t, nil
+}
+
+// placeholderList formats every placeholder for an error message
+func placeholderList() string {
+	var names []string
+	for _, name := range append(append([]string(nil), dirPlaceholders...), filePlaceholders...) {
+		names = append(names, "{"+name+"}")
+	}
+	return strings.Join(names, ", ")
+}
+
+// dirHas reports whether the show directory uses a placeholder
+func (t *outputTemplate) dirHas(name string) bool {
+	for _, part := range t.dir {
+		if strings.Contains(part, "{"+name+"}") {
+			return true
+		}
+	}
+	return false
+}
+
+// usesSets reports whether audio files are named by their set
+func (t *outputTemplate) usesSets() bool {
+	for _, part := range t.file {
+		if strings.Contains(part, "{set}") {
+			return true
+		}
+	}
+	return false
+}
+
+// render fills in the placeholders of path components and joins them,
+// dropping components left empty
+func render(parts []string, values map[string]string) string {
+	var rendered []string
+	for _, part := range parts {
+		s := placeholderPattern.ReplaceAllStringFunc(part, func(m string) string {
+			return sanitizeFilename(values[m[1:len(m)-1]])
+		})
+		// Separators around a value that was empty, as in "{date} - {venue}"
+		s = strings.Join(strings.Fields(s), " ")
+		for strings.Contains(s, " - - ") {
+			s = strings.ReplaceAll(s, " - - ", " - ")
+		}
+		s = strings.Trim(s, " -_")
+		if s = sanitizeFilename(s); s != "" {
+			rendered = append(rendered, s)
+		}
+	}
+	return filepath.Join(rendered...)
+}
+
+// showDir renders the show directory of a source
+func (t *outputTemplate) showDir(cfg *Config, show Show, identifier string) string {
+	date := show.DisplayDate
+	city, _, _ := strings.Cut(show.Venue.Location, ",")
+	values := map[string]string{
+		"band":       cfg.Band,
+		"artist":     bandDisplayName(cfg.Band),
+		"year":       dateYear(date),
+		"date":       date,
+		"venue":      show.Venue.Name,
+		"city":       city,
+		"location":   show.Venue.Location,
+		"identifier": identifier,
+	}
+	if len(date) >= 7 {
+		values["month"] = date[5:7]
+	}
+	if cfg.Tour != "" {
+		values["tour"] = tourDirName(cfg.Tour)
+	}
+	return filepath.Join(cfg.OutputDir, render(t.dir, values))
+}
+
+// fileName renders the name of an audio file, relative to the show directory
+func (t *outputTemplate) fileName(file ArchiveFile) string {
+	ext := filepath.Ext(file.Name)
+	title := file.Title
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(file.Name), path.Ext(file.Name))
+	}
+	track := file.Track
+	if n, err := strconv.Atoi(track); err == nil {
+		track = fmt.Sprintf("%02d", n)
+	}
+	if file.EncoreTrack > 0 {
+		track = fmt.Sprintf("e%02d", file.EncoreTrack)
+	}
+
+	values := map[string]string{"set": file.SetDir, "track_num": track, "title": title}
+	name := render(t.file, values)
+	if name == "" || name == "." {
+		name = sanitizeFilename(path.Base(file.Name))
+		ext = ""
+	}
+	return name + ext
+}
+
+// sourceShowDir returns the directory a source of a show is saved in. The
+// first source gets the show's directory; others get it with suffix, unless
+// -output-template names directories by identifier.
+func sourceShowDir(cfg *Config, show Show, identifier, suffix string) string {
+	t, _ := parseOutputTemplate(cfg.OutputTemplate) // Validated at startup
+	if t == nil {
+		return filepath.Join(showParentDir(cfg, show.DisplayDate), show.DisplayDate) + suffix
+	}
+	dir := t.showDir(cfg, show, identifier)
+	if t.dirHas("identifier") {
+		return dir
+	}
+	return dir + suffix
+}
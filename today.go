@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dayPattern = regexp.MustCompile(`^(\d{1,2})-(\d{1,2})$`)
+
+// runToday handles `dead-dl today`, which downloads or lists the shows
+// performed on today's month and day in every year
+func runToday(args []string) {
+	fs := flag.NewFlagSet("today", flag.ExitOnError)
+	cfg := &Config{}
+	registerFlags(fs, cfg)
+	on := fs.String("on", time.Now().Format("01-02"), "Month and day to look up as MM-DD")
+	list := fs.Bool("list", false, "Only list the shows instead of downloading them")
+	fs.Parse(args)
+
+	initRunLogger(cfg)
+	defer logger.Close()
+
+	validateConfig(cfg)
+	day, err := parseDay(*on)
+	if err != nil {
+		logger.Fatal("%v", err)
+	}
+
+	years, err := fetchYears(cfg.Band)
+	if err != nil {
+		logger.Fatal("Failed to fetch years: %v", err)
+	}
+
+	logger.Info("Looking for %s shows on %s in %d years...", cfg.Band, day, len(years))
+	var shows []Show
+	var showYears []string
+	for _, year := range years {
+		yearShows, err := fetchShows(cfg.Band, year.Year)
+		if err != nil {
+			logger.Warn("Failed to fetch shows for %s: %v", year.Year, err)
+			continue
+		}
+		found := false
+		for _, show := range yearShows {
+			if strings.HasSuffix(show.DisplayDate, "-"+day) {
+				shows = append(shows, show)
+				found = true
+			}
+		}
+		if found {
+			showYears = append(showYears, year.Year)
+		}
+	}
+
+	if len(shows) == 0 {
+		logger.Info("No %s shows on %s", cfg.Band, day)
+		return
+	}
+
+	if *list {
+		for _, show := range shows {
+			fmt.Printf("%s  %s, %s\n", show.DisplayDate, show.Venue.Name, show.Venue.Location)
+		}
+		return
+	}
+
+	logger.Info("Found %d shows for %s on %s", len(shows), cfg.Band, day)
+
+	cfg.Year = strings.Join(showYears, ",")
+
+	if err := setupEvents(cfg); err != nil {
+		logger.Fatal("Failed to set up progress events: %v", err)
+	}
+	defer events.Close()
+
+	if cfg.StateFile == "" {
+		cfg.StateFile = filepath.Join(cfg.OutputDir, fmt.Sprintf(".dead-dl-%s-on-%s.state.json", cfg.Band, day))
+	}
+	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+		logger.Fatal("Failed to create output directory %s: %v", cfg.OutputDir, err)
+	}
+
+	state := newRunState(cfg.StateFile, *cfg, shows)
+	if err := state.Save(); err != nil {
+		logger.Warn("Failed to write state file %s: %v", cfg.StateFile, err)
+	}
+
+	if err := runDownload(cfg, state); err != nil {
+		logger.Fatal("%v", err)
+	}
+}
+
+// parseDay validates an MM-DD value and returns it with two-digit month and
+// day, as it appears in show dates
+func parseDay(value string) (string, error) {
+	m := dayPattern.FindStringSubmatch(value)
+	if m == nil {
+		return "", fmt.Errorf("invalid -on %q (use MM-DD)", value)
+	}
+	month, err := parseMonth(m[1])
+	if err != nil {
+		return "", err
+	}
+	day, _ := strconv.Atoi(m[2])
+	if day < 1 || day > 31 {
+		return "", fmt.Errorf("invalid day %q", m[2])
+	}
+	return fmt.Sprintf("%s-%02d", month, day), nil
+}
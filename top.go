@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// topRefreshInterval is how often the dashboard is redrawn
+const topRefreshInterval = time.Second
+
+// topMaxErrors is the number of recent errors kept on the dashboard
+const topMaxErrors = 5
+
+// topTransfer is an active file transfer as seen by the dashboard
+type topTransfer struct {
+	show      string
+	file      string
+	bytes     int64
+	total     int64
+	speed     float64
+	lastBytes int64
+	lastSeen  time.Time
+}
+
+// topModel is the dashboard state built from the event stream
+type topModel struct {
+	mu        sync.Mutex
+	show      string
+	position  int
+	shows     int
+	transfers map[string]*topTransfer
+	finished  int
+	failed    int
+	bytesDone int64
+	errors    []string
+	closed    bool
+}
+
+// runTop connects to the progress socket of a running dead-dl and shows a
+// live dashboard of the queue, active transfers, speeds, and recent errors
+func runTop(args []string) {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	socket := fs.String("socket", "", "Progress socket of the running instance (its -progress-socket)")
+	fs.Parse(args)
+
+	if *socket == "" && fs.NArg() == 1 {
+		*socket = fs.Arg(0)
+	}
+	if *socket == "" {
+		fmt.Fprintln(os.Stderr, "Usage: dead-dl top <socket>")
+		os.Exit(2)
+	}
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to %s: %v\n", *socket, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	model := &topModel{transfers: make(map[string]*topTransfer)}
+	go model.consume(conn)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	ticker := time.NewTicker(topRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		model.render(os.Stdout, *socket)
+		if model.isClosed() {
+			fmt.Println("\nConnection closed, the run has finished.")
+			return
+		}
+
+		select {
+		case <-interrupt:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// consume reads JSON-lines events until the connection closes
+func (m *topModel) consume(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var ev ProgressEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		m.apply(ev)
+	}
+
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+}
+
+// apply updates the dashboard state with a single event
+func (m *topModel) apply(ev ProgressEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := ev.Show + "/" + ev.File
+	switch ev.Type {
+	case EventShowStarted:
+		m.show = ev.Show
+		m.position = ev.Position
+		m.shows = ev.Shows
+	case EventFileStarted:
+		m.transfers[key] = &topTransfer{show: ev.Show, file: ev.File, total: ev.Total, lastSeen: ev.Time}
+	case EventFileProgress:
+		t, ok := m.transfers[key]
+		if !ok {
+			t = &topTransfer{show: ev.Show, file: ev.File, lastSeen: ev.Time}
+			m.transfers[key] = t
+		}
+		if elapsed := ev.Time.Sub(t.lastSeen).Seconds(); elapsed > 0 {
+			t.speed = float64(ev.Bytes-t.lastBytes) / elapsed
+		}
+		t.bytes, t.total = ev.Bytes, ev.Total
+		t.lastBytes, t.lastSeen = ev.Bytes, ev.Time
+	case EventFileFinished:
+		delete(m.transfers, key)
+		m.finished++
+		m.bytesDone += ev.Bytes
+	case EventFileFailed:
+		delete(m.transfers, key)
+		m.failed++
+		m.addError(fmt.Sprintf("%s %s: transfer failed", ev.Time.Format("15:04:05"), ev.File))
+	case EventShowFinished:
+		if ev.Error != "" {
+			m.addError(fmt.Sprintf("%s %s: %s", ev.Time.Format("15:04:05"), ev.Show, ev.Error))
+		}
+	}
+}
+
+// addError keeps the most recent errors; the caller holds the lock
+func (m *topModel) addError(msg string) {
+	m.errors = append(m.errors, msg)
+	if len(m.errors) > topMaxErrors {
+		m.errors = m.errors[len(m.errors)-topMaxErrors:]
+	}
+}
+
+func (m *topModel) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+// render redraws the whole dashboard
+func (m *topModel) render(w io.Writer, socket string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J") // Move home and clear the screen
+	fmt.Fprintf(&b, "dead-dl top - %s - %s\n\n", socket, time.Now().Format("15:04:05"))
+
+	if m.shows > 0 {
+		fmt.Fprintf(&b, "Show %d/%d: %s\n", m.position, m.shows, m.show)
+	} else {
+		b.WriteString("Waiting for the next show...\n")
+	}
+
+	var totalSpeed float64
+	transfers := make([]*topTransfer, 0, len(m.transfers))
+	for _, t := range m.transfers {
+		transfers = append(transfers, t)
+		totalSpeed += t.speed
+	}
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].file < transfers[j].file })
+
+	fmt.Fprintf(&b, "Files: %d finished, %d failed, %s downloaded, %s/s\n\n",
+		m.finished, m.failed, ByteSize(m.bytesDone), ByteSize(totalSpeed))
+
+	fmt.Fprintf(&b, "%-42s %22s %6s %12s\n", "ACTIVE TRANSFER", "BYTES", "%", "SPEED")
+	for _, t := range transfers {
+		percent := "-"
+		size := ByteSize(t.bytes).String()
+		if t.total > 0 {
+			percent = fmt.Sprintf("%.0f", float64(t.bytes)*100/float64(t.total))
+			size += " / " + ByteSize(t.total).String()
+		}
+		fmt.Fprintf(&b, "%-42s %22s %6s %10s/s\n", truncateName(t.file), size, percent, ByteSize(t.speed))
+	}
+	if len(transfers) == 0 {
+		b.WriteString("(none)\n")
+	}
+
+	b.WriteString("\nRECENT ERRORS\n")
+	for _, e := range m.errors {
+		b.WriteString(e + "\n")
+	}
+	if len(m.errors) == 0 {
+		b.WriteString("(none)\n")
+	}
+
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Tour is an entry of a band's tour listing
+type Tour struct {
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	StartDate   string `json:"start_date"`
+	EndDate     string `json:"end_date"`
+	ShowsOnTour int    `json:"shows_on_tour"`
+	Shows       []Show `json:"shows,omitempty"`
+}
+
+// fetchTours lists the tours of a band
+func fetchTours(band string) ([]Tour, error) {
+	url := fmt.Sprintf("%s/artists/%s/tours", RelistenAPIBase, band)
+	resp, err := apiGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var tours []Tour
+	if err := json.NewDecoder(resp.Body).Decode(&tours); err != nil {
+		return nil, err
+	}
+	return tours, nil
+}
+
+// fetchTourShows lists the shows of a tour
+func fetchTourShows(band, slug string) ([]Show, error) {
+	url := fmt.Sprintf("%s/artists/%s/tours/%s", RelistenAPIBase, band, slug)
+	resp, err := apiGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var tour Tour
+	if err := json.NewDecoder(resp.Body).Decode(&tour); err != nil {
+		return nil, err
+	}
+	return tour.Shows, nil
+}
+
+// findTour looks up a tour of a band by name or slug, ignoring case
+func findTour(band, name string) (*Tour, error) {
+	tours, err := fetchTours(band)
+	if err != nil {
+		return nil, err
+	}
+	for i, tour := range tours {
+		if strings.EqualFold(tour.Name, name) || strings.EqualFold(tour.Slug, name) {
+			return &tours[i], nil
+		}
+	}
+
+	var names []string
+	for _, tour := range tours {
+		names = append(names, tour.Name)
+	}
+	return nil, fmt.Errorf("no %s tour named %q (tours: %s)", band, name, strings.Join(names, ", "))
+}
+
+// tourDirName turns a tour name into a folder name
+func tourDirName(name string) string {
+	return strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(strings.TrimSpace(name))
+}
+
+// showParentDir is the folder show directories go into: the tour's with
+// -tour, otherwise the year's
+func showParentDir(cfg *Config, date string) string {
+	if cfg.Tour != "" {
+		return filepath.Join(cfg.OutputDir, cfg.Band, tourDirName(cfg.Tour))
+	}
+	return filepath.Join(cfg.OutputDir, cfg.Band, dateYear(date))
+}
+
+// filterVenue keeps the shows at venues whose name contains venue, ignoring
+// case; an empty venue keeps all
+func filterVenue(shows []Show, venue string) []Show {
+	if venue == "" {
+		return shows
+	}
+	var kept []Show
+	for _, show := range shows {
+		if strings.Contains(strings.ToLower(show.Venue.Name), strings.ToLower(venue)) {
+			kept = append(kept, show)
+		}
+	}
+	return kept
+}
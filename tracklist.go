@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TrackMismatch describes an audio format of an archive item whose files
+// don't line up with Relisten's track list, a hint that the item may be
+// mislabeled
+type TrackMismatch struct {
+	Format         string   `json:"format"`
+	RelistenTracks int      `json:"relisten_tracks"`
+	ArchiveFiles   int      `json:"archive_files"`
+	OutOfOrder     []string `json:"out_of_order,omitempty"` // Files that come earlier in Relisten's list than the file before them
+}
+
+func (m TrackMismatch) String() string {
+	var problems []string
+	if m.RelistenTracks != m.ArchiveFiles {
+		problems = append(problems, fmt.Sprintf("%d %s file(s) for %d Relisten track(s)", m.ArchiveFiles, m.Format, m.RelistenTracks))
+	}
+	if len(m.OutOfOrder) > 0 {
+		problems = append(problems, fmt.Sprintf("%s file(s) out of order: %s", m.Format, strings.Join(m.OutOfOrder, ", ")))
+	}
+	return strings.Join(problems, "; ")
+}
+
+// checkTrackList compares the audio files of each format with Relisten's
+// track list, by count and by order. The archive order is the files' track
+// numbers; Relisten positions are found by matching file names to mp3_url.
+func checkTrackList(files []ArchiveFile, sets []Set) []TrackMismatch {
+	sets = append([]Set(nil), sets...)
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].Index < sets[j].Index })
+
+	positions := make(map[string]int)
+	tracks := 0
+	for _, set := range sets {
+		setTracks := append([]Track(nil), set.Tracks...)
+		sort.SliceStable(setTracks, func(a, b int) bool { return setTracks[a].TrackPosition < setTracks[b].TrackPosition })
+		for _, track := range setTracks {
+			if track.Mp3URL != "" {
+				positions[trackBase(track)] = tracks
+			}
+			tracks++
+		}
+	}
+	if tracks == 0 {
+		// Nothing to compare against
+		return nil
+	}
+
+	byExt := make(map[string][]ArchiveFile)
+	for _, file := range files {
+		if classifyFile(file) == ClassAudio {
+			ext := strings.TrimPrefix(strings.ToLower(path.Ext(file.Name)), ".")
+			byExt[ext] = append(byExt[ext], file)
+		}
+	}
+
+	exts := make([]string, 0, len(byExt))
+	for ext := range byExt {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	var mismatches []TrackMismatch
+	for _, ext := range exts {
+		group := byExt[ext]
+		sort.SliceStable(group, func(i, j int) bool { return archiveTrackLess(group[i], group[j]) })
+
+		m := TrackMismatch{Format: ext, RelistenTracks: tracks, ArchiveFiles: len(group)}
+		last := -1
+		for _, file := range group {
+			pos, ok := positions[fileBase(file.Name)]
+			if !ok {
+				continue
+			}
+			if pos < last {
+				m.OutOfOrder = append(m.OutOfOrder, path.Base(file.Name))
+			}
+			last = pos
+		}
+
+		if m.RelistenTracks != m.ArchiveFiles || len(m.OutOfOrder) > 0 {
+			mismatches = append(mismatches, m)
+		}
+	}
+	return mismatches
+}
+
+// archiveTrackLess orders files by their track number, then by name
+func archiveTrackLess(a, b ArchiveFile) bool {
+	ta, errA := strconv.Atoi(strings.Split(a.Track, "/")[0])
+	tb, errB := strconv.Atoi(strings.Split(b.Track, "/")[0])
+	if errA == nil && errB == nil && ta != tb {
+		return ta < tb
+	}
+	return a.Name < b.Name
+}
+
+// recordTrackMismatches stores the mismatches of a source in its manifest so
+// the show directory itself says the item may be mislabeled
+func recordTrackMismatches(showDir string, mismatches []TrackMismatch) {
+	manifest, err := loadManifest(showDir)
+	if err != nil {
+		logger.Warn("Failed to load manifest of %s: %v", showDir, err)
+		return
+	}
+	if len(manifest.TrackMismatches) == 0 && len(mismatches) == 0 {
+		return
+	}
+
+	manifest.TrackMismatches = mismatches
+	if err := manifest.Save(); err != nil {
+		logger.Warn("Failed to save manifest: %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Where the files of a source are downloaded from, for -source
+const (
+	FileSourceAuto     = "auto"     // archive.org, or relisten.org's track URLs for sources without an archive.org item
+	FileSourceArchive  = "archive"  // archive.org only; sources without an item are skipped
+	FileSourceRelisten = "relisten" // relisten.org's track URLs, even for sources on archive.org
+)
+
+// relistenIDPrefix starts the identifier of a source that has no archive.org
+// item, which is named after its relisten.org UUID instead
+const relistenIDPrefix = "relisten-"
+
+// parseFileSource validates -source
+func parseFileSource(value string) error {
+	switch value {
+	case "", FileSourceAuto, FileSourceArchive, FileSourceRelisten:
+		return nil
+	}
+	return fmt.Errorf("unknown -source %q (use %s, %s, or %s)", value,
+		FileSourceAuto, FileSourceArchive, FileSourceRelisten)
+}
+
+// sourceIdentifier returns the identifier a source is downloaded and
+// cataloged under: its archive.org identifier, or for a source without an
+// archive.org item, relisten- followed by its relisten.org UUID
+func sourceIdentifier(source Source) string {
+	if id := archiveIdentifier(source); id != "" {
+		return id
+	}
+	if source.UUID != "" {
+		return relistenIDPrefix + source.UUID
+	}
+	return ""
+}
+
+// isArchiveItem reports whether an identifier names an archive.org item
+func isArchiveItem(identifier string) bool {
+	return identifier != "" && !strings.HasPrefix(identifier, relistenIDPrefix)
+}
+
+// usesTrackURLs reports whether the files of a source are downloaded from
+// relisten.org's track URLs under -source
+func usesTrackURLs(cfg *Config, source Source) bool {
+	switch cfg.FileSource {
+	case FileSourceArchive:
+		return false
+	case FileSourceRelisten:
+		return hasTrackURLs(source)
+	}
+	return archiveIdentifier(source) == "" && hasTrackURLs(source)
+}
+
+// hasTrackURLs reports whether relisten.org lists a file URL for any track
+// of a source
+func hasTrackURLs(source Source) bool {
+	for _, set := range source.Sets {
+		for _, track := range set.Tracks {
+			if track.Mp3URL != "" || trackFLACURL(track) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trackFLACURL returns the FLAC URL of a track, which relisten.org leaves
+// null for most sources
+func trackFLACURL(track Track) string {
+	s, _ := track.FLACURL.(string)
+	return s
+}
+
+// selectTrackFiles picks the files of a source from relisten.org's track
+// URLs in the requested format, in set list order, falling back to MP3 when
+// FLAC was requested but isn't listed. Their sizes come from HEAD requests,
+// so files already on disk are checked and skipped like archive.org files.
+func (sp *SourcePlan) selectTrackFiles(cfg *Config, summary *RunSummary) error {
+	wantFlac := cfg.Format == "flac" || cfg.Format == "both"
+	wantMp3 := cfg.Format == "mp3" || cfg.Format == "both"
+
+	var flacs, mp3s []ArchiveFile
+	for n, track := range sortedTracks(sp.Source.Sets) {
+		number := strconv.Itoa(n + 1)
+		if flacURL := trackFLACURL(track); flacURL != "" {
+			md5, _ := track.FLACMd5.(string)
+			flacs = append(flacs, trackFile(track, number, flacURL, ".flac", "Flac", md5))
+		}
+		if track.Mp3URL != "" {
+			mp3s = append(mp3s, trackFile(track, number, track.Mp3URL, ".mp3", "VBR MP3", track.Mp3Md5))
+		}
+	}
+
+	var files []ArchiveFile
+	if wantFlac {
+		files = append(files, flacs...)
+	}
+	if wantMp3 || (cfg.Format == "flac" && len(flacs) == 0) {
+		if cfg.Format == "flac" && len(mp3s) > 0 {
+			logger.Println("    - No FLAC track URLs found, falling back to MP3...")
+		}
+		files = append(files, mp3s...)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no track URLs found in requested format")
+	}
+	for i := range files {
+		files[i].Size = remoteSize(files[i].URL)
+	}
+
+	if cfg.MaxFileSize > 0 {
+		files = dropOversized(files, sp.ShowDir, cfg.MaxFileSize, "-max-file-size", func(ArchiveFile) bool { return true }, summary)
+		if len(files) == 0 {
+			return fmt.Errorf("every file is larger than -max-file-size %s", cfg.MaxFileSize)
+		}
+	}
+
+	assignSets(files, sp.Source.Sets, cfg)
+	resolveNameCollisions(files, cfg)
+	sp.Files = files
+	return nil
+}
+
+// sortedTracks lists the tracks of every set in set list order
+func sortedTracks(sets []Set) []Track {
+	sets = append([]Set(nil), sets...)
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].Index < sets[j].Index })
+	var tracks []Track
+	for _, set := range sets {
+		setTracks := append([]Track(nil), set.Tracks...)
+		sort.SliceStable(setTracks, func(a, b int) bool { return setTracks[a].TrackPosition < setTracks[b].TrackPosition })
+		tracks = append(tracks, setTracks...)
+	}
+	return tracks
+}
+
+// trackFile describes a track URL as a file, named after the last component
+// of the URL, with ext added if the URL has no audio extension
+func trackFile(track Track, number, fileURL, ext, format, md5 string) ArchiveFile {
+	name := track.Slug
+	if u, err := url.Parse(fileURL); err == nil {
+		if base, err := url.PathUnescape(path.Base(u.Path)); err == nil && base != "/" && base != "." {
+			name = base
+		}
+	}
+	if !isAudioFile(name) {
+		name += ext
+	}
+	return ArchiveFile{
+		Name:   name,
+		Format: format,
+		Title:  track.Title,
+		Track:  number,
+		MD5:    md5,
+		Source: "original",
+		URL:    fileURL,
+	}
+}
+
+// remoteSize asks the host of a file for its size with a HEAD request,
+// returning "" when it's unknown. Offline runs don't ask.
+func remoteSize(fileURL string) string {
+	if apiCache != nil && apiCache.offline {
+		return ""
+	}
+	apiLimiter.Wait()
+	resp, err := apiClient.Head(fileURL)
+	if err != nil {
+		logger.Debug("Failed to get the size of %s: %v", fileURL, err)
+		return ""
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		logger.Debug("No size for %s (status %d)", fileURL, resp.StatusCode)
+		return ""
+	}
+	return strconv.FormatInt(resp.ContentLength, 10)
+}
+
+// downloadURL returns where a file of a source is downloaded from: its track
+// URL, or its archive.org download URL
+func downloadURL(identifier string, file ArchiveFile) string {
+	if file.URL != "" {
+		return file.URL
+	}
+	return archiveFileURL(identifier, file.Name)
+}
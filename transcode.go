@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// losslessExts are the audio formats -transcode converts; lossy downloads
+// are left alone, since re-encoding them only loses quality
+var losslessExts = map[string]bool{".flac": true, ".wav": true, ".aif": true, ".aiff": true, ".shn": true, ".ape": true, ".wv": true}
+
+var mp3VBRPattern = regexp.MustCompile(`^v([0-9])$`)
+
+// transcodeTarget is a parsed -transcode, like opus:128 or mp3:v0
+type transcodeTarget struct {
+	Codec string   // opus or mp3
+	Ext   string   // Extension of the converted files
+	Args  []string // ffmpeg encoder options
+}
+
+// parseTranscode parses -transcode: opus:<kbps>, mp3:v0 to mp3:v9 for VBR
+// quality, or mp3:<kbps> for a constant bitrate
+func parseTranscode(value string) (*transcodeTarget, error) {
+	codec, quality, _ := strings.Cut(strings.ToLower(value), ":")
+	bitrate, err := strconv.Atoi(quality)
+	switch {
+	case codec == "opus" && err == nil && bitrate >= 6 && bitrate <= 510:
+		return &transcodeTarget{Codec: codec, Ext: ".opus", Args: []string{"-c:a", "libopus", "-b:a", quality + "k"}}, nil
+	case codec == "mp3" && mp3VBRPattern.MatchString(quality):
+		return &transcodeTarget{Codec: codec, Ext: ".mp3", Args: []string{"-c:a", "libmp3lame", "-q:a", quality[1:]}}, nil
+	case codec == "mp3" && err == nil && bitrate >= 32 && bitrate <= 320:
+		return &transcodeTarget{Codec: codec, Ext: ".mp3", Args: []string{"-c:a", "libmp3lame", "-b:a", quality + "k"}}, nil
+	}
+	return nil, fmt.Errorf("invalid -transcode %q (use opus:<kbps> like opus:128, mp3:v0 to mp3:v9, or mp3:<kbps> like mp3:320)", value)
+}
+
+// encoder returns the ffmpeg encoder a target needs
+func (t *transcodeTarget) encoder() string {
+	return t.Args[1]
+}
+
+// checkTranscoding makes sure -transcode is valid and ffmpeg is installed
+// with the encoder it needs
+func checkTranscoding(cfg *Config) error {
+	if cfg.Transcode == "" {
+		return nil
+	}
+	target, err := parseTranscode(cfg.Transcode)
+	if err != nil {
+		return err
+	}
+	if cfg.TranscodeJobs < 1 {
+		return fmt.Errorf("-transcode-jobs must be at least 1")
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("-transcode needs ffmpeg (https://ffmpeg.org), which wasn't found: %w", err)
+	}
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return fmt.Errorf("-transcode failed to run ffmpeg -encoders: %w", err)
+	}
+	if !strings.Contains(string(out), " "+target.encoder()+" ") {
+		return fmt.Errorf("-transcode %s needs an ffmpeg built with %s, which this one lacks", cfg.Transcode, target.encoder())
+	}
+	return nil
+}
+
+// transcodeDir returns the root of the tree converted files are written to:
+// -transcode-dir, or the output directory with the codec appended, like
+// downloads-opus
+func transcodeDir(cfg *Config) string {
+	if cfg.TranscodeDir != "" {
+		return cfg.TranscodeDir
+	}
+	target, _ := parseTranscode(cfg.Transcode) // Validated at startup
+	return filepath.Clean(cfg.OutputDir) + "-" + target.Codec
+}
+
+// transcodeJob is a file waiting to be converted
+type transcodeJob struct {
+	src, dest string
+}
+
+// transcoder converts the lossless files of downloaded shows with
+// -transcode-jobs ffmpeg processes, while the next shows download
+type transcoder struct {
+	cfg    *Config
+	target *transcodeTarget
+	jobs   chan transcodeJob
+	queued sync.WaitGroup // Shows being handed to the workers
+	done   sync.WaitGroup // Workers
+
+	converted, failed atomic.Int64
+}
+
+// startTranscoder starts the workers of -transcode, or returns nil without it
+func startTranscoder(cfg *Config) *transcoder {
+	if cfg.Transcode == "" {
+		return nil
+	}
+	target, _ := parseTranscode(cfg.Transcode) // Validated at startup
+	t := &transcoder{cfg: cfg, target: target, jobs: make(chan transcodeJob)}
+	for i := 0; i < max(cfg.TranscodeJobs, 1); i++ {
+		t.done.Add(1)
+		go func() {
+			defer t.done.Done()
+			for job := range t.jobs {
+				// Leave the rest for the next run
+				if control.Cancelled() {
+					continue
+				}
+				if err := t.convert(job); err != nil {
+					logger.Warn("Failed to transcode %s: %v", job.src, err)
+					t.failed.Add(1)
+					continue
+				}
+				t.converted.Add(1)
+			}
+		}()
+	}
+	return t
+}
+
+// Queue hands the lossless audio files of a downloaded show directory to
+// the workers, without waiting for them. Files converted before and not
+// changed since are skipped.
+func (t *transcoder) Queue(showDir string) {
+	if t == nil {
+		return
+	}
+	manifest, err := loadManifest(showDir)
+	if err != nil {
+		logger.Warn("Not transcoding %s: %v", showDir, err)
+		return
+	}
+	rel, err := filepath.Rel(filepath.Clean(t.cfg.OutputDir), showDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// Shows outside -output, like archived audience recordings, keep their base name
+		rel = filepath.Base(showDir)
+	}
+
+	var jobs []transcodeJob
+	for _, entry := range manifest.Entries() {
+		ext := filepath.Ext(entry.LocalName)
+		if !isAudioFile(entry.LocalName) || !losslessExts[strings.ToLower(ext)] {
+			continue
+		}
+		src := filepath.Join(showDir, entry.LocalName)
+		dest := filepath.Join(transcodeDir(t.cfg), rel, strings.TrimSuffix(entry.LocalName, ext)+t.target.Ext)
+		srcInfo, err := os.Stat(src)
+		if err != nil {
+			continue
+		}
+		if destInfo, err := os.Stat(dest); err == nil && destInfo.ModTime().After(srcInfo.ModTime()) {
+			continue
+		}
+		jobs = append(jobs, transcodeJob{src: src, dest: dest})
+	}
+	if len(jobs) == 0 {
+		return
+	}
+
+	logger.Printf("    - Transcoding %d file(s) to %s in the background\n", len(jobs), t.cfg.Transcode)
+	t.queued.Add(1)
+	go func() {
+		defer t.queued.Done()
+		for _, job := range jobs {
+			t.jobs <- job
+		}
+	}()
+}
+
+// Wait waits for every queued file to be converted and logs the result
+func (t *transcoder) Wait() {
+	if t == nil {
+		return
+	}
+	t.queued.Wait()
+	close(t.jobs)
+	t.done.Wait()
+
+	if converted, failed := t.converted.Load(), t.failed.Load(); converted > 0 || failed > 0 {
+		logger.Info("Transcoded %d file(s) to %s into %s, %d failed", converted, t.cfg.Transcode, transcodeDir(t.cfg), failed)
+	}
+}
+
+// convert encodes a file with ffmpeg, keeping its tags and, in MP3s, its
+// cover art. MP3s get the encoder delay and padding in a LAME header and an
+// iTunSMPB comment, so segues play without gaps.
+func (t *transcoder) convert(job transcodeJob) error {
+	if err := os.MkdirAll(filepath.Dir(job.dest), 0755); err != nil {
+		return err
+	}
+
+	tmp := strings.TrimSuffix(job.dest, t.target.Ext) + ".part" + t.target.Ext
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", job.src, "-map", "0:a", "-map_metadata", "0"}
+	if t.target.Codec == "mp3" {
+		args = append(args, "-map", "0:v?", "-c:v", "copy", "-id3v2_version", "3", "-write_xing", "1")
+	}
+	args = append(args, t.target.Args...)
+	args = append(args, tmp)
+
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if t.target.Codec == "mp3" {
+		if err := writeGapless(tmp); err != nil {
+			logger.Warn("Failed to write gapless info into %s: %v", job.dest, err)
+		}
+	}
+	return os.Rename(tmp, job.dest)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TrimmedDirName is the folder of a show directory that listening copies
+// with the silence trimmed go into; the downloaded files stay untouched
+const TrimmedDirName = "trimmed"
+
+// checkTrimming makes sure ffmpeg is installed and the thresholds make sense
+// when -trim-silence is set
+func checkTrimming(cfg *Config) error {
+	if !cfg.TrimSilence {
+		return nil
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("-trim-silence needs ffmpeg: %w", err)
+	}
+	if !strings.HasSuffix(cfg.SilenceThreshold, "dB") {
+		return fmt.Errorf("invalid -silence-threshold %q (use e.g. -50dB)", cfg.SilenceThreshold)
+	}
+	if cfg.SilenceMin <= 0 {
+		return fmt.Errorf("-silence-min must be positive")
+	}
+	return nil
+}
+
+// silenceFilter is the ffmpeg filter that trims leading and trailing
+// silence: it trims the start, reverses the audio, trims the start again,
+// and reverses it back
+func silenceFilter(threshold string, min time.Duration) string {
+	trim := fmt.Sprintf("silenceremove=start_periods=1:start_duration=%g:start_threshold=%s", min.Seconds(), threshold)
+	return strings.Join([]string{trim, "areverse", trim, "areverse"}, ",")
+}
+
+// trimShow writes a listening copy of every audio file of a show directory
+// into its trimmed/ folder, skipping copies newer than their original
+func trimShow(cfg *Config, showDir string) {
+	if !cfg.TrimSilence {
+		return
+	}
+	manifest, err := loadManifest(showDir)
+	if err != nil {
+		logger.Warn("Failed to load manifest of %s: %v", showDir, err)
+		return
+	}
+
+	trimmed := 0
+	for _, entry := range manifest.Entries() {
+		if !isAudioFile(entry.LocalName) {
+			continue
+		}
+		src := filepath.Join(showDir, entry.LocalName)
+		dest := filepath.Join(showDir, TrimmedDirName, entry.LocalName)
+		srcInfo, err := os.Stat(src)
+		if err != nil {
+			continue
+		}
+		if destInfo, err := os.Stat(dest); err == nil && destInfo.ModTime().After(srcInfo.ModTime()) {
+			continue
+		}
+
+		if err := trimFile(cfg, src, dest); err != nil {
+			logger.Warn("Failed to trim silence of %s: %v", entry.LocalName, err)
+			continue
+		}
+		trimmed++
+	}
+	if trimmed > 0 {
+		logger.Printf("    ✓ Trimmed silence of %d file(s) into %s/\n", trimmed, TrimmedDirName)
+	}
+}
+
+// trimFile writes src with its leading and trailing silence removed to dest.
+// FLAC stays lossless; lossy formats are re-encoded at high quality.
+func trimFile(cfg *Config, src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(dest)
+	tmp := strings.TrimSuffix(dest, ext) + ".part" + ext
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", src,
+		"-af", silenceFilter(cfg.SilenceThreshold, cfg.SilenceMin), "-map_metadata", "0"}
+	if strings.EqualFold(ext, ".mp3") {
+		args = append(args, "-q:a", "2")
+	}
+	args = append(args, tmp)
+
+	cmd := exec.Command("ffmpeg", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(tmp, dest)
+}
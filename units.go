@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes that can be given on the command line with
+// a binary unit suffix, e.g. "10K", "500M" or "2G"
+type ByteSize int64
+
+// byteUnits maps unit suffixes to their multiplier, longest suffixes first
+var byteUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses a size such as "10K" or "1.5G" into bytes
+func parseByteSize(s string) (ByteSize, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	if str == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	mult := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(str, unit.suffix) {
+			mult = unit.mult
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(value * float64(mult)), nil
+}
+
+// String formats the size with the largest whole binary unit
+func (b ByteSize) String() string {
+	switch {
+	case b >= 1<<40:
+		return fmt.Sprintf("%.2fT", float64(b)/(1<<40))
+	case b >= 1<<30:
+		return fmt.Sprintf("%.2fG", float64(b)/(1<<30))
+	case b >= 1<<20:
+		return fmt.Sprintf("%.2fM", float64(b)/(1<<20))
+	case b >= 1<<10:
+		return fmt.Sprintf("%.2fK", float64(b)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
+
+// Set implements flag.Value
+func (b *ByteSize) Set(s string) error {
+	size, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
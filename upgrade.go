@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Upgrade policies: keep the old source next to the upgrade, or replace it
+const (
+	UpgradeSideBySide = "side-by-side"
+	UpgradeReplace    = "replace"
+)
+
+// Upgrade is a better source for a show that is already in the catalog
+type Upgrade struct {
+	Band       string
+	Date       string
+	Current    *CatalogSource // The cataloged source the upgrade supersedes
+	Source     Source
+	Identifier string
+	Reason     string
+}
+
+// runUpgrade handles `dead-dl upgrade`, which looks for better sources of
+// shows in the catalog and downloads them
+func runUpgrade(args []string) {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	cfg := &Config{}
+	registerFlags(fs, cfg)
+	policy := fs.String("policy", UpgradeSideBySide, "What to do with the old source: side-by-side keeps it, replace deletes it once the upgrade is complete")
+	minGain := fs.Float64("min-rating-gain", 0.5, "How much higher a source's average rating must be to count as an upgrade")
+	dryRun := fs.Bool("dry-run", false, "Only list the upgrades that would be downloaded")
+	fs.Parse(args)
+
+	// Without -band, every band in the catalog is checked
+	bandSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "band" {
+			bandSet = true
+		}
+	})
+	if !bandSet {
+		cfg.Band = ""
+	}
+
+	initRunLogger(cfg)
+	defer logger.Close()
+
+	validateConfig(cfg)
+	if *policy != UpgradeSideBySide && *policy != UpgradeReplace {
+		logger.Fatal("Unknown -policy %q (use %s or %s)", *policy, UpgradeSideBySide, UpgradeReplace)
+	}
+
+	if err := setupEvents(cfg); err != nil {
+		logger.Fatal("Failed to set up progress events: %v", err)
+	}
+	defer events.Close()
+
+	store, err := openCatalog(cfg)
+	if err != nil {
+		logger.Fatal("Failed to open catalog: %v", err)
+	}
+	defer store.Close()
+
+	catalog, err := store.Load()
+	if err != nil {
+		logger.Fatal("Failed to load catalog: %v", err)
+	}
+
+	upgrades := findUpgrades(cfg, catalog, *minGain)
+	if len(upgrades) == 0 {
+		logger.Info("No upgrades found")
+		return
+	}
+
+	logger.Printf("\nFound %d upgrade(s):\n", len(upgrades))
+	for _, u := range upgrades {
+		logger.Printf("  %s %s: %s -> %s (%s)\n", u.Band, u.Date, u.Current.Identifier, u.Identifier, u.Reason)
+	}
+	if *dryRun {
+		return
+	}
+	if !cfg.Yes && !confirm(fmt.Sprintf("Download %d upgrade(s) (%s)?", len(upgrades), *policy)) {
+		logger.Info("Upgrade cancelled")
+		return
+	}
+
+	summary := &RunSummary{}
+	started := time.Now()
+	if setupQuota(cfg, store, summary) {
+		return
+	}
+
+	for i, u := range upgrades {
+		if summary.Aborted() || summary.QuotaReached() {
+			break
+		}
+		logger.Printf("\n[%d/%d] Upgrading %s %s\n", i+1, len(upgrades), u.Band, u.Date)
+		applyUpgrade(cfg, store, u, *policy, summary)
+	}
+
+	recordRun(cfg, store, started, summary)
+	summary.Print()
+}
+
+// findUpgrades checks every show in the catalog, optionally limited to
+// -band and -year, for sources that aren't cataloged and are better than the
+// best one we have: a soundboard where we only have audience recordings, or
+// a rating at least minGain higher
+func findUpgrades(cfg *Config, catalog *Catalog, minGain float64) []Upgrade {
+	type showKey struct{ band, date string }
+	shows := make(map[showKey][]*CatalogSource)
+	for _, src := range catalog.Sources {
+		if cfg.Band != "" && src.Band != cfg.Band {
+			continue
+		}
+		if !matchesYears(cfg.Year, src.Year) {
+			continue
+		}
+		key := showKey{src.Band, src.Date}
+		shows[key] = append(shows[key], src)
+	}
+
+	keys := make([]showKey, 0, len(shows))
+	for key := range shows {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].band != keys[j].band {
+			return keys[i].band < keys[j].band
+		}
+		return keys[i].date < keys[j].date
+	})
+
+	logger.Info("Checking %d show(s) for better sources...", len(keys))
+
+	var upgrades []Upgrade
+	for _, key := range keys {
+		var current *CatalogSource
+		haveSoundboard := false
+		for _, src := range shows[key] {
+			if src.SupersededBy != "" {
+				continue
+			}
+			if current == nil || src.Rating > current.Rating {
+				current = src
+			}
+			haveSoundboard = haveSoundboard || src.Soundboard
+		}
+		if current == nil {
+			continue
+		}
+
+		detail, err := fetchShowDetail(key.band, key.date)
+		if err != nil {
+			logger.Warn("Failed to fetch show details for %s %s: %v", key.band, key.date, err)
+			continue
+		}
+
+		var best *Upgrade
+		for _, source := range detail.Sources {
+			id := archiveIdentifier(source)
+			if id == "" || catalog.Sources[id] != nil {
+				continue
+			}
+
+			var reason string
+			switch {
+			case source.IsSoundboard && !haveSoundboard:
+				reason = "soundboard"
+			case source.AvgRating >= current.Rating+minGain:
+				reason = fmt.Sprintf("rated %.2f vs %.2f", source.AvgRating, current.Rating)
+			default:
+				continue
+			}
+
+			// Prefer soundboards, then the highest rating
+			if best == nil || (source.IsSoundboard && !best.Source.IsSoundboard) ||
+				(source.IsSoundboard == best.Source.IsSoundboard && source.AvgRating > best.Source.AvgRating) {
+				best = &Upgrade{Band: key.band, Date: key.date, Current: current,
+					Source: source, Identifier: id, Reason: reason}
+			}
+		}
+		if best != nil {
+			upgrades = append(upgrades, *best)
+		}
+	}
+	return upgrades
+}
+
+// applyUpgrade downloads an upgrade and, once all of its files are in, either
+// marks the old source as superseded or replaces its directory
+func applyUpgrade(cfg *Config, store CatalogStore, u Upgrade, policy string, summary *RunSummary) {
+	upgradeCfg := *cfg
+	upgradeCfg.Band = u.Band
+
+	currentDir := filepath.Join(cfg.OutputDir, filepath.FromSlash(u.Current.ShowDir))
+	sp := &SourcePlan{Identifier: u.Identifier, Source: u.Source}
+	if policy == UpgradeReplace {
+		sp.ShowDir = currentDir + ".upgrade"
+	} else {
+		sp.ShowDir = filepath.Join(filepath.Dir(currentDir), u.Date+"-"+u.Identifier)
+	}
+
+	plan := &ShowPlan{Show: Show{DisplayDate: u.Date}, Sources: []*SourcePlan{sp}}
+	downloadShow(&upgradeCfg, plan, store, summary, nil)
+
+	upgraded, ok, err := store.LookupSource(u.Identifier)
+	if err != nil || !ok {
+		logger.Warn("Upgrade %s is incomplete, keeping %s", u.Identifier, u.Current.Identifier)
+		return
+	}
+
+	if policy == UpgradeSideBySide {
+		u.Current.SupersededBy = u.Identifier
+		if err := store.AddSource(u.Current); err != nil {
+			logger.Warn("Failed to update catalog: %v", err)
+		}
+		return
+	}
+
+	if err := replaceShowDir(currentDir, sp.ShowDir, cfg.StaleLock); err != nil {
+		logger.Error("Failed to replace %s with the upgrade: %v", currentDir, err)
+		return
+	}
+	upgraded.ShowDir = u.Current.ShowDir
+	if err := store.AddSource(upgraded); err != nil {
+		logger.Warn("Failed to update catalog: %v", err)
+	}
+	if err := store.RemoveSource(u.Current.Identifier); err != nil {
+		logger.Warn("Failed to update catalog: %v", err)
+	}
+	logger.Printf("    ✓ Replaced %s with %s\n", u.Current.Identifier, u.Identifier)
+}
+
+// replaceShowDir swaps newDir into the place of oldDir and deletes the old
+// files. The old directory is moved aside first so a failure never leaves
+// neither in place.
+func replaceShowDir(oldDir, newDir string, staleLock time.Duration) error {
+	// Don't pull the directory away from an instance that is still writing to it
+	lock, err := acquireShowLock(oldDir, staleLock)
+	if errors.Is(err, ErrShowLocked) {
+		return err
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	backup := oldDir + ".replaced"
+	if err := os.Rename(oldDir, backup); err != nil && !os.IsNotExist(err) {
+		if lock != nil {
+			lock.Release()
+		}
+		return err
+	}
+	if err := os.Rename(newDir, oldDir); err != nil {
+		os.Rename(backup, oldDir)
+		if lock != nil {
+			lock.Release()
+		}
+		return err
+	}
+	return os.RemoveAll(backup)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verifyProblem is a downloaded file that doesn't match what archive.org has
+type verifyProblem struct {
+	Entry  ManifestEntry
+	Path   string
+	Size   int64  // Expected size, or 0 when unknown
+	MD5    string // Expected md5, or "" when unknown
+	Reason string
+}
+
+// verifyStats counts the outcome of a verify run
+type verifyStats struct {
+	Shows    int
+	Files    int
+	Problems int
+	Repaired int
+}
+
+// runVerify handles `dead-dl verify`, which checks every file listed in the
+// show manifests against archive.org's sizes and md5s, and with -repair
+// quarantines and downloads again the ones that are missing, truncated, or corrupt
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	cfg := &Config{}
+	registerFlags(fs, cfg)
+	repair := fs.Bool("repair", false, "Quarantine bad files and download them again")
+	local := fs.Bool("local", false, "Only check files against the sizes and md5s in their manifests, without asking archive.org")
+	fs.Parse(args)
+
+	// Without -band, every show directory under -output is checked
+	bandSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "band" {
+			bandSet = true
+		}
+	})
+
+	initRunLogger(cfg)
+	defer logger.Close()
+
+	validateConfig(cfg)
+
+	// An -output-template that doesn't start with the band folder spreads a
+	// band's shows over the whole tree
+	root := cfg.OutputDir
+	if t, _ := parseOutputTemplate(cfg.OutputTemplate); bandSet && (t == nil || t.dir[0] == "{band}") {
+		root = filepath.Join(cfg.OutputDir, cfg.Band)
+	}
+
+	stats, err := verifyTree(cfg, root, *local, *repair)
+	if err != nil {
+		logger.Fatal("Failed to scan %s: %v", root, err)
+	}
+
+	logger.Info("Verified %d file(s) of %d show(s): %d problem(s), %d repaired", stats.Files, stats.Shows, stats.Problems, stats.Repaired)
+	if stats.Problems > stats.Repaired {
+		if !*repair {
+			logger.Info("Run again with -repair to download the bad files again")
+		}
+		os.Exit(1)
+	}
+}
+
+// verifyTree verifies every show directory under root
+func verifyTree(cfg *Config, root string, local, repair bool) (verifyStats, error) {
+	var stats verifyStats
+	err := filepath.WalkDir(root, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == CatalogDirName || dir == filepath.Join(cfg.OutputDir, QuarantineDirName) {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(dir, ManifestFileName)); err != nil {
+			return nil
+		}
+
+		problems, files, err := verifyShow(dir, local)
+		stats.Shows++
+		stats.Files += files
+		if err != nil {
+			logger.Warn("Failed to verify %s: %v", dir, err)
+			return nil
+		}
+		for _, p := range problems {
+			logger.Printf("  %s: %s\n", p.Path, p.Reason)
+		}
+		stats.Problems += len(problems)
+		if repair && len(problems) > 0 {
+			stats.Repaired += repairShow(cfg, dir, problems)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// verifyShow checks the files of a show directory and returns the bad ones
+// along with the number of files checked. Unless local is set, sizes and
+// md5s come from the item's current archive.org metadata, falling back to
+// the manifest for files archive.org no longer lists and for sources
+// downloaded from relisten.org track URLs.
+func verifyShow(showDir string, local bool) ([]verifyProblem, int, error) {
+	manifest, err := loadManifest(showDir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	remote := make(map[string]ArchiveFile)
+	if !local && isArchiveItem(manifest.Identifier) {
+		metadata, err := fetchArchiveMetadata(manifest.Identifier)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to fetch archive.org metadata of %s: %w", manifest.Identifier, err)
+		}
+		for _, file := range metadata.Files {
+			remote[file.Name] = file
+		}
+	}
+
+	var problems []verifyProblem
+	entries := manifest.Entries()
+	for _, entry := range entries {
+		p := verifyProblem{Entry: entry, Size: entry.Size, MD5: entry.MD5}
+		if entry.Tagged {
+			// Tagged files only match their manifest entry, not archive.org
+			p.MD5 = entry.TaggedMD5
+		} else if file, ok := remote[remoteFileName(manifest.Identifier, entry.RemoteURL)]; ok {
+			if size, err := parseFileSize(file.Size); err == nil {
+				p.Size = size
+			}
+			if file.MD5 != "" {
+				p.MD5 = file.MD5
+			}
+		}
+
+		path, err := safeJoin(showDir, entry.LocalName)
+		if err != nil {
+			logger.Warn("Skipping %s in the manifest of %s: %v", entry.LocalName, showDir, err)
+			continue
+		}
+		p.Path = path
+
+		info, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			p.Reason = "missing"
+		case err != nil:
+			p.Reason = err.Error()
+		case p.Size > 0 && info.Size() < p.Size:
+			p.Reason = fmt.Sprintf("truncated: local=%d, remote=%d", info.Size(), p.Size)
+		case p.Size > 0 && info.Size() != p.Size:
+			p.Reason = fmt.Sprintf("size mismatch: local=%d, remote=%d", info.Size(), p.Size)
+		case p.MD5 != "":
+			sum, err := fileMD5(path)
+			if err != nil {
+				p.Reason = err.Error()
+			} else if !strings.EqualFold(sum, p.MD5) {
+				p.Reason = fmt.Sprintf("md5 mismatch: got %s, expected %s", sum, p.MD5)
+			}
+		}
+		if p.Reason != "" {
+			problems = append(problems, p)
+		}
+	}
+	return problems, len(entries), nil
+}
+
+// remoteFileName returns the archive.org file name of a download URL
+func remoteFileName(identifier, remoteURL string) string {
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Path, "/download/"+identifier+"/")
+}
+
+// repairShow quarantines the bad files of a show and downloads them again,
+// holding the show lock so no running download touches them meanwhile.
+// It returns the number of files repaired.
+func repairShow(cfg *Config, showDir string, problems []verifyProblem) int {
+	lock, err := acquireShowLock(showDir, cfg.StaleLock)
+	if err != nil {
+		logger.Warn("Not repairing %s: %v", showDir, err)
+		return 0
+	}
+	defer lock.Release()
+
+	manifest, err := loadManifest(showDir)
+	if err != nil {
+		logger.Warn("Failed to load manifest of %s: %v", showDir, err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	progress := newProgressReporter(cfg.Progress, showDir, &wg)
+	defer progress.Wait()
+
+	repaired := 0
+	for _, p := range problems {
+		if _, err := os.Stat(p.Path); err == nil {
+			if _, err := quarantineFile(cfg, p.Path, p.Path, p.Entry.RemoteURL, p.Reason); err != nil {
+				logger.Warn("Failed to quarantine %s: %v", p.Path, err)
+				continue
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(p.Path), 0755); err != nil {
+			logger.Warn("Failed to create directory for %s: %v", p.Path, err)
+			continue
+		}
+		size, md5 := p.Size, p.MD5
+		if p.Entry.Tagged {
+			// archive.org's copy is untagged, so the tagged size and md5 no longer apply
+			size, md5 = -1, p.Entry.MD5
+		}
+		if size <= 0 {
+			size = -1
+		}
+		if err := downloadWithRetries(p.Entry.RemoteURL, p.Path, p.Entry.LocalName, size, md5, progress, cfg); err != nil {
+			logger.Error("Failed to download %s again: %v", p.Entry.LocalName, err)
+			continue
+		}
+
+		entry := p.Entry
+		if info, err := os.Stat(p.Path); err == nil {
+			entry.Size = info.Size()
+		}
+		entry.MD5 = md5
+		entry.Tagged, entry.TaggedMD5 = false, ""
+		entry.DownloadedAt = time.Now()
+		manifest.Record(entry)
+		logger.Printf("    ✓ Repaired %s\n", p.Path)
+		repaired++
+	}
+
+	if repaired > 0 {
+		if err := manifest.Save(); err != nil {
+			logger.Warn("Failed to write manifest for %s: %v", showDir, err)
+		}
+	}
+	return repaired
+}